@@ -0,0 +1,115 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+	"strings"
+
+	"go-weaviate-deepseek/conf"
+	"go-weaviate-deepseek/conn"
+	"go-weaviate-deepseek/ext/embedder"
+	"go-weaviate-deepseek/ext/weaviatelib"
+	"go-weaviate-deepseek/jobs/eval"
+	"go-weaviate-deepseek/services"
+)
+
+// runEval 检索评估
+//
+//	go run ./cmd eval -cls Eggman -data questions.jsonl -k 5 -baseline last.json
+//	go run ./cmd eval -store memory -corpus docs.jsonl -cls Eggman -data questions.jsonl
+func runEval(args []string) {
+	fs := flag.NewFlagSet("eval", flag.ExitOnError)
+	e := fs.String("e", "development", "production | development")
+	c := fs.String("c", conf.SettingsFile, "config file, embedders etc.")
+	cls := fs.String("cls", "", "cls_name, also used to generate chunk ids for the memory store")
+	data := fs.String("data", "", "questions jsonl, {\"question\": \"\", \"expected_urls\": [], \"expected_ids\": []}")
+	k := fs.Int("k", 5, "top k")
+	out := fs.String("out", "eval-report.json", "report file")
+	baseline := fs.String("baseline", "", "previous report to compare with, the diff is written next to -out")
+	store := fs.String("store", "weaviate", "weaviate | memory")
+	corpus := fs.String("corpus", "", "documents jsonl for the memory store, {\"title\": \"\", \"url\": \"\", \"text\": \"\"}")
+	dim := fs.Int("dim", 256, "hash embedder dimension for the memory store")
+	mode := fs.String("mode", "simple", "retrieval mode for weaviate, simple | multi_query | hyde")
+	distance := fs.Float64("distance", services.DefaultDistance, "max distance")
+	alpha := fs.Float64("alpha", 0, "hybrid alpha for weaviate, 0-1 to enable hybrid search")
+	_ = fs.Parse(args)
+
+	if len(*cls) == 0 || len(*data) == 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	defer Prepare(*e)()
+	err := conf.LoadSettings(*c)
+	if err != nil {
+		log.Fatalln("load config file err:", err)
+	}
+
+	cases, err := eval.LoadCases(*data)
+	if err != nil {
+		log.Fatalln("load questions err:", err)
+	}
+
+	options := map[string]string{
+		"cls":      *cls,
+		"data":     *data,
+		"store":    *store,
+		"distance": flagValue(fs, "distance"),
+	}
+	var retriever eval.Retriever
+	switch *store {
+	case "memory":
+		if len(*corpus) == 0 {
+			log.Fatalln("-corpus is required for the memory store")
+		}
+		h := embedder.NewHash(*dim)
+		retriever, err = eval.NewMemoryStore(*cls, *corpus, h, float32(*distance))
+		if err != nil {
+			log.Fatalln("load corpus err:", err)
+		}
+		options["corpus"] = *corpus
+		options["embedder"] = h.ModelID()
+	case "weaviate":
+		// 向量缓存
+		_ = conn.RedisConnect()
+		retriever = &eval.WeaviateRetriever{
+			ClsName: *cls,
+			Mode:    *mode,
+			Opts: weaviatelib.QueryOpts{
+				Distance: float32(*distance),
+				Alpha:    float32(*alpha),
+			},
+		}
+		options["mode"] = *mode
+		options["alpha"] = flagValue(fs, "alpha")
+	default:
+		log.Fatalln("unknown store:", *store)
+	}
+
+	log.Printf("eval start, questions: %d, store: %s, k: %d", len(cases), *store, *k)
+	report := eval.NewReport(options, *k, eval.Run(cases, retriever, *k))
+	if err := eval.WriteJSON(*out, report); err != nil {
+		log.Fatalln("write report err:", err)
+	}
+
+	var diff *eval.Diff
+	if len(*baseline) > 0 {
+		base, err := eval.LoadReport(*baseline)
+		if err != nil {
+			log.Fatalln("load baseline err:", err)
+		}
+		diff = eval.NewDiff(base, report)
+		diffPath := strings.TrimSuffix(*out, ".json") + ".diff.json"
+		if err := eval.WriteJSON(diffPath, diff); err != nil {
+			log.Fatalln("write diff err:", err)
+		}
+		log.Println("diff written:", diffPath)
+	}
+	eval.Print(os.Stdout, report, diff)
+	log.Println("report written:", *out)
+}
+
+func flagValue(fs *flag.FlagSet, name string) string {
+	return fs.Lookup(name).Value.String()
+}
@@ -11,19 +11,31 @@ import (
 	"go-weaviate-deepseek/conn"
 	"go-weaviate-deepseek/ext"
 	"go-weaviate-deepseek/jobs/api"
+	"go-weaviate-deepseek/services"
 )
 
 func main() {
+	// 子命令
+	if len(os.Args) > 1 && os.Args[1] == "eval" {
+		runEval(os.Args[2:])
+		return
+	}
 	ext.RunWithRecover(createParentProcess)
 }
 
 func createParentProcess() {
 	e := flag.String("e", "development", "production | development")
+	c := flag.String("c", conf.SettingsFile, "config file, embedders etc.")
 	flag.Parse()
 
 	// setup logrus
 	defer Prepare(*e)()
 
+	err := conf.LoadSettings(*c)
+	if err != nil {
+		log.Fatalln("load config file err:", err)
+	}
+
 	log.Println("env:", *e)
 	log.Println("tika host:", conf.TIKA_HOST)
 
@@ -61,6 +73,8 @@ func Prepare(env string) func() {
 	conf.Parse(env)
 
 	weaviatelib.VectorizerFunc = api.Vectorizer
+	weaviatelib.ClsVectorizerFunc = api.ClsVectorizer
+	services.ChatFunc = api.ChatText
 
 	return func() {
 		file.Close()
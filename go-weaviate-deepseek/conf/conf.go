@@ -8,6 +8,7 @@ var TIKA_HOST string
 const (
 	AuthHeaderKey    = "X_KEY"
 	AuthHeaderSecret = "xxx"
+	APIKeyHeader     = "X_API_KEY" // 调用方的key，用于统计用量和限额
 	WebAPIPort       = ":5012"
 	WebAPIPrdSelfURL = "https://eggman.tv" + WebAPIPort
 
@@ -18,6 +19,7 @@ const (
 	APITypeAliDeepSeeK = "AliDeepSeeK"
 
 	AliDeepSeekModelName = "deepseek-v3" // "deepseek-r1"
+	AliVisionModelName   = "qwen-vl-max" // 图片描述
 )
 
 func init() {
@@ -0,0 +1,134 @@
+package conf
+
+// ProviderConf OpenAI兼容的大模型服务
+type ProviderConf struct {
+	Name      string `json:"name"`
+	BaseURL   string `json:"base_url"`
+	APIKey    string `json:"api_key"`
+	APIKeyEnv string `json:"api_key_env"` // 优先使用环境变量中的key
+}
+
+func (pc *ProviderConf) Key() string {
+	return resolveKey(pc.APIKeyEnv, pc.APIKey)
+}
+
+// ModelConf 可以通过 _chat_model 选择的模型
+type ModelConf struct {
+	Name          string `json:"name"`
+	Provider      string `json:"provider"`
+	LegalName     string `json:"legal_name"` // 调用接口时的model参数
+	MaxTokens     int    `json:"max_tokens"` // 单次回答最多的tokens
+	ContextWindow int    `json:"context_window"`
+	// 模板变量超过该长度时自动分段, key: VAR | URL | FILE
+	ChunkSize map[string]int `json:"chunk_size"`
+	// Tokenizer 接口没有返回用量时本地估算使用, cl100k_base(default) | p50k_base | r50k_base
+	Tokenizer string `json:"tokenizer"`
+	// Fallbacks 超时、5xx、限流时依次尝试的模型
+	Fallbacks []string `json:"fallbacks"`
+}
+
+func defaultProviders() []*ProviderConf {
+	return []*ProviderConf{
+		{
+			Name:      "dashscope",
+			BaseURL:   AliDeepSeeKBaseUrl,
+			APIKey:    AliDeepSeekAPIKey,
+			APIKeyEnv: "DASHSCOPE_API_KEY",
+		},
+		{
+			Name:      "openai",
+			BaseURL:   "https://api.openai.com/v1",
+			APIKeyEnv: "OPENAI_API_KEY",
+		},
+	}
+}
+
+func defaultModels() []*ModelConf {
+	chunkSize := func(size int) map[string]int {
+		return map[string]int{"VAR": size, "URL": size, "FILE": size}
+	}
+	return []*ModelConf{
+		{
+			Name:          AliDeepSeekModelName,
+			Provider:      "dashscope",
+			LegalName:     AliDeepSeekModelName,
+			MaxTokens:     1500,
+			ContextWindow: 65536,
+			Tokenizer:     "cl100k_base",
+			ChunkSize:     chunkSize(2000),
+			Fallbacks:     []string{"deepseek-r1"},
+		},
+		{
+			Name:          "deepseek-r1",
+			Provider:      "dashscope",
+			LegalName:     "deepseek-r1",
+			MaxTokens:     4000,
+			ContextWindow: 65536,
+			Tokenizer:     "cl100k_base",
+			ChunkSize:     chunkSize(2000),
+		},
+		{
+			Name:          AliVisionModelName,
+			Provider:      "dashscope",
+			LegalName:     AliVisionModelName,
+			MaxTokens:     1000,
+			ContextWindow: 32768,
+			Tokenizer:     "cl100k_base",
+			ChunkSize:     chunkSize(2000),
+		},
+		{
+			Name:          "gpt-3.5-turbo-16k",
+			Provider:      "openai",
+			LegalName:     "gpt-3.5-turbo-16k",
+			MaxTokens:     6000,
+			ContextWindow: 16384,
+			Tokenizer:     "cl100k_base",
+			ChunkSize:     chunkSize(6000),
+		},
+		{
+			Name:          "gpt-4",
+			Provider:      "openai",
+			LegalName:     "gpt-4",
+			MaxTokens:     1500,
+			ContextWindow: 8192,
+			Tokenizer:     "cl100k_base",
+			ChunkSize:     chunkSize(2000),
+		},
+	}
+}
+
+func (s *SettingsConf) GetModel(name string) *ModelConf {
+	for _, m := range s.Models {
+		if m.Name == name {
+			return m
+		}
+	}
+	return nil
+}
+
+func (s *SettingsConf) GetProvider(name string) *ProviderConf {
+	for _, p := range s.Providers {
+		if p.Name == name {
+			return p
+		}
+	}
+	return nil
+}
+
+// FallbackChain 模型本身加上fallbacks，跳过重复和不存在的模型
+func (s *SettingsConf) FallbackChain(name string) []string {
+	res := make([]string, 0)
+	m := s.GetModel(name)
+	if m == nil {
+		return res
+	}
+	seen := map[string]bool{}
+	for _, n := range append([]string{name}, m.Fallbacks...) {
+		if seen[n] || s.GetModel(n) == nil {
+			continue
+		}
+		seen[n] = true
+		res = append(res, n)
+	}
+	return res
+}
@@ -0,0 +1,223 @@
+package conf
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+)
+
+// SettingsFile 默认配置文件，可以通过 -c 参数或 GWD_CONFIG 环境变量指定
+const SettingsFile = "conf/config.json"
+
+const (
+	EmbedderTypeOpenAI = "openai" // OpenAI兼容接口，包括阿里云百炼
+	EmbedderTypeOllama = "ollama"
+	EmbedderTypeHash   = "hash" // 离线测试使用
+)
+
+// EmbedderConf 向量模型配置
+type EmbedderConf struct {
+	Name      string `json:"name"`
+	Type      string `json:"type"`
+	BaseURL   string `json:"base_url"`
+	APIKey    string `json:"api_key"`
+	APIKeyEnv string `json:"api_key_env"` // 优先使用环境变量中的key
+	Model     string `json:"model"`
+	Dimension int    `json:"dimension"`
+	BatchSize int    `json:"batch_size"` // 单次请求最多的文本数量
+}
+
+// Key 返回API key
+func (ec *EmbedderConf) Key() string {
+	return resolveKey(ec.APIKeyEnv, ec.APIKey)
+}
+
+// EmbeddingCacheConf 向量缓存，redis中的缓存总是开启，DiskDir非空时再加一层本地文件缓存
+type EmbeddingCacheConf struct {
+	Enabled        bool   `json:"enabled"`
+	Bypass         bool   `json:"bypass"` // 不读写缓存，也可以设置环境变量 EMBED_CACHE_BYPASS=true
+	TTLHours       int    `json:"ttl_hours"`
+	MaxEntries     int64  `json:"max_entries"`
+	DiskDir        string `json:"disk_dir"`
+	DiskMaxEntries int    `json:"disk_max_entries"`
+}
+
+// MemoryConf 服务端保存的对话历史
+type MemoryConf struct {
+	Enabled     bool `json:"enabled"`
+	MaxTurns    int  `json:"max_turns"`    // 最多保留的轮数，一问一答为一轮
+	TokenBudget int  `json:"token_budget"` // 拼接到prompt中的历史最多的tokens
+	TTLHours    int  `json:"ttl_hours"`
+}
+
+// AnalyticsConf achat的问题统计
+type AnalyticsConf struct {
+	Enabled bool  `json:"enabled"`
+	MaxLen  int64 `json:"max_len"` // 每个集合最多保留的问题记录
+	// WeakMatchDistance 最匹配的内容distance大于该值时也算没有找到好的匹配
+	WeakMatchDistance float32 `json:"weak_match_distance"`
+}
+
+// FeedbackConf 用户对回答的评价
+type FeedbackConf struct {
+	Enabled bool `json:"enabled"`
+	// RecordTTLHours 回答保存多久，超过后不能再评价
+	RecordTTLHours int `json:"record_ttl_hours"`
+	// DemoteDownvoted 检索时降低经常出现在差评回答中的内容的排序
+	DemoteDownvoted bool `json:"demote_downvoted"`
+	// DemoteMinDownvotes 差评次数达到该值后才降低排序
+	DemoteMinDownvotes int `json:"demote_min_downvotes"`
+	// DemotePerDownvote 每次差评排序往后移的位置数，最多移动 DemoteMaxPositions
+	DemotePerDownvote  float32 `json:"demote_per_downvote"`
+	DemoteMaxPositions float32 `json:"demote_max_positions"`
+}
+
+// AnswerCacheConf achat的语义缓存，相似的问题直接返回之前的回答
+type AnswerCacheConf struct {
+	Enabled bool `json:"enabled"`
+	// Similarity 问题向量的余弦相似度不低于该值时使用缓存
+	Similarity float32 `json:"similarity"`
+	TTLHours   int     `json:"ttl_hours"`
+}
+
+// SettingsConf 配置文件内容
+type SettingsConf struct {
+	DefaultEmbedder string          `json:"default_embedder"`
+	Embedders       []*EmbedderConf `json:"embedders"`
+	// cls_name => embedder name, 没有配置的集合使用 DefaultEmbedder
+	CollectionEmbedders map[string]string   `json:"collection_embedders"`
+	EmbeddingCache      *EmbeddingCacheConf `json:"embedding_cache"`
+	// cls_name => achat的检索方式, simple(default) | multi_query | hyde
+	CollectionRetrievalModes map[string]string `json:"collection_retrieval_modes"`
+
+	DefaultChatModel string          `json:"default_chat_model"`
+	Providers        []*ProviderConf `json:"providers"`
+	Models           []*ModelConf    `json:"models"`
+	// VisionModel 图片描述使用的模型，需要在models中配置
+	VisionModel string `json:"vision_model"`
+
+	// Prices 模型名 => 价格，用于统计费用
+	Prices map[string]*PriceConf `json:"prices"`
+	// Quota 每个用户和api key的默认限额，单个用户的限额可以通过 /usage/quota 修改
+	Quota *QuotaConf `json:"quota"`
+
+	Memory *MemoryConf `json:"memory"`
+
+	Analytics *AnalyticsConf `json:"analytics"`
+
+	Feedback *FeedbackConf `json:"feedback"`
+
+	AnswerCache *AnswerCacheConf `json:"answer_cache"`
+}
+
+// Settings 没有配置文件时使用默认配置
+var Settings = defaultSettings()
+
+func defaultSettings() *SettingsConf {
+	return &SettingsConf{
+		DefaultEmbedder: "dashscope",
+		Embedders: []*EmbedderConf{
+			{
+				Name:      "dashscope",
+				Type:      EmbedderTypeOpenAI,
+				BaseURL:   AliDeepSeeKBaseUrl,
+				APIKey:    AliDeepSeekAPIKey,
+				APIKeyEnv: "DASHSCOPE_API_KEY",
+				Model:     "text-embedding-v3",
+				Dimension: 1024,
+				BatchSize: 10,
+			},
+		},
+		CollectionEmbedders:      map[string]string{},
+		CollectionRetrievalModes: map[string]string{},
+		EmbeddingCache: &EmbeddingCacheConf{
+			Enabled:        true,
+			TTLHours:       30 * 24,
+			MaxEntries:     200000,
+			DiskMaxEntries: 200000,
+		},
+		DefaultChatModel: AliDeepSeekModelName,
+		Providers:        defaultProviders(),
+		Models:           defaultModels(),
+		VisionModel:      AliVisionModelName,
+		Prices:           defaultPrices(),
+		Quota:            &QuotaConf{},
+		Memory: &MemoryConf{
+			Enabled:     false,
+			MaxTurns:    10,
+			TokenBudget: 4000,
+			TTLHours:    7 * 24,
+		},
+		Analytics: &AnalyticsConf{
+			Enabled:           true,
+			MaxLen:            100000,
+			WeakMatchDistance: 0.4,
+		},
+		Feedback: &FeedbackConf{
+			Enabled:            true,
+			RecordTTLHours:     30 * 24,
+			DemoteDownvoted:    false,
+			DemoteMinDownvotes: 3,
+			DemotePerDownvote:  0.5,
+			DemoteMaxPositions: 3,
+		},
+		AnswerCache: &AnswerCacheConf{
+			Enabled:    false,
+			Similarity: 0.95,
+			TTLHours:   24,
+		},
+	}
+}
+
+// LoadSettings 读取json配置文件，文件不存在时使用默认配置
+func LoadSettings(path string) error {
+	if len(os.Getenv("GWD_CONFIG")) > 0 {
+		path = os.Getenv("GWD_CONFIG")
+	}
+	b, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		log.Println("config file not found, use default settings:", path)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	s := defaultSettings()
+	err = json.Unmarshal(b, s)
+	if err != nil {
+		return err
+	}
+	for _, m := range s.Models {
+		if s.GetProvider(m.Provider) == nil {
+			return fmt.Errorf("model %s: provider %s is not configured", m.Name, m.Provider)
+		}
+		for _, f := range m.Fallbacks {
+			if s.GetModel(f) == nil {
+				return fmt.Errorf("model %s: fallback model %s is not configured", m.Name, f)
+			}
+		}
+	}
+	Settings = s
+	log.Println("config file loaded:", path)
+	return nil
+}
+
+func (s *SettingsConf) GetEmbedder(name string) *EmbedderConf {
+	for _, e := range s.Embedders {
+		if e.Name == name {
+			return e
+		}
+	}
+	return nil
+}
+
+func resolveKey(env, key string) string {
+	if len(env) > 0 && len(os.Getenv(env)) > 0 {
+		return os.Getenv(env)
+	}
+	return key
+}
@@ -0,0 +1,42 @@
+package conf
+
+import "strings"
+
+// PriceConf 每1K tokens的价格，单位由配置决定（默认为元）
+type PriceConf struct {
+	Input  float64 `json:"input"`
+	Output float64 `json:"output"`
+}
+
+// QuotaConf 0为不限制
+type QuotaConf struct {
+	DailyTokens   int64   `json:"daily_tokens"`
+	MonthlyTokens int64   `json:"monthly_tokens"`
+	DailyCost     float64 `json:"daily_cost"`
+	MonthlyCost   float64 `json:"monthly_cost"`
+}
+
+// IsUnlimited 没有设置任何限制
+func (q *QuotaConf) IsUnlimited() bool {
+	return q == nil || (q.DailyTokens <= 0 && q.MonthlyTokens <= 0 && q.DailyCost <= 0 && q.MonthlyCost <= 0)
+}
+
+// 阿里云百炼的价格
+func defaultPrices() map[string]*PriceConf {
+	return map[string]*PriceConf{
+		"deepseek-v3":       {Input: 0.002, Output: 0.008},
+		"deepseek-r1":       {Input: 0.004, Output: 0.016},
+		"text-embedding-v3": {Input: 0.0005},
+	}
+}
+
+// GetPrice 按模型名查找，embedder的模型名可以带类型前缀，例如 openai:text-embedding-v3
+func (s *SettingsConf) GetPrice(model string) *PriceConf {
+	if p, exists := s.Prices[model]; exists {
+		return p
+	}
+	if idx := strings.Index(model, ":"); idx >= 0 {
+		return s.Prices[model[idx+1:]]
+	}
+	return nil
+}
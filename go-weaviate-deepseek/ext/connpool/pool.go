@@ -48,6 +48,13 @@ func (p *Pool) Add(gid string, c *Client) {
 	}
 }
 
+// Size returns the number of connections and groups
+func (p *Pool) Size() (clients, groups int) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return len(p.Clients), len(p.ClientsGroup)
+}
+
 func (p *Pool) Remove(gid, sessionID string) {
 	p.lock.Lock()
 	defer p.lock.Unlock()
@@ -0,0 +1,303 @@
+package embedder
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go-weaviate-deepseek/conn"
+
+	"github.com/go-redis/redis/v8"
+)
+
+const (
+	redisEmbedCachePrefix = "embcache:"
+	redisEmbedCacheIndex  = "embcache:index" // zset, score为写入时间，用于限制数量
+)
+
+var cacheSpaceRe = regexp.MustCompile(`\s+`)
+
+// CacheStore 向量缓存存储
+type CacheStore interface {
+	Get(key string) ([]float32, bool)
+	Set(key string, vec []float32)
+}
+
+// CacheStats 命中统计，按 ModelID 分开
+type CacheStats struct {
+	Hits   int64 `json:"hits"`
+	Misses int64 `json:"misses"`
+}
+
+var (
+	cacheStats     = make(map[string]*CacheStats)
+	cacheStatsLock sync.Mutex
+)
+
+func statsFor(modelID string) *CacheStats {
+	cacheStatsLock.Lock()
+	defer cacheStatsLock.Unlock()
+	s, exists := cacheStats[modelID]
+	if !exists {
+		s = &CacheStats{}
+		cacheStats[modelID] = s
+	}
+	return s
+}
+
+// GetCacheStats 返回每个模型的命中次数
+func GetCacheStats() map[string]CacheStats {
+	cacheStatsLock.Lock()
+	defer cacheStatsLock.Unlock()
+	res := make(map[string]CacheStats, len(cacheStats))
+	for k, v := range cacheStats {
+		res[k] = CacheStats{Hits: atomic.LoadInt64(&v.Hits), Misses: atomic.LoadInt64(&v.Misses)}
+	}
+	return res
+}
+
+// Cached 在Embedder前面加一层缓存，key为模型+归一化后文本的哈希，按顺序查找stores，命中后回填前面的store
+type Cached struct {
+	Inner  Embedder
+	Stores []CacheStore
+	Bypass bool
+}
+
+func NewCached(inner Embedder, stores ...CacheStore) *Cached {
+	return &Cached{Inner: inner, Stores: stores}
+}
+
+func (c *Cached) ModelID() string {
+	return c.Inner.ModelID()
+}
+
+func (c *Cached) Dimension() int {
+	return c.Inner.Dimension()
+}
+
+func (c *Cached) Embed(text string) ([]float32, error) {
+	res, err := c.EmbedBatch([]string{text})
+	if err != nil {
+		return nil, err
+	}
+	return res[0], nil
+}
+
+func (c *Cached) EmbedBatch(texts []string) ([][]float32, error) {
+	if c.Bypass || len(c.Stores) == 0 {
+		return c.Inner.EmbedBatch(texts)
+	}
+
+	stats := statsFor(c.ModelID())
+	res := make([][]float32, len(texts))
+	keys := make([]string, len(texts))
+	missIdx := make([]int, 0)
+	missTexts := make([]string, 0)
+	for i, t := range texts {
+		keys[i] = CacheKey(c.ModelID(), t)
+		if vec, ok := c.get(keys[i]); ok {
+			res[i] = vec
+			atomic.AddInt64(&stats.Hits, 1)
+			continue
+		}
+		atomic.AddInt64(&stats.Misses, 1)
+		missIdx = append(missIdx, i)
+		missTexts = append(missTexts, t)
+	}
+	if len(missTexts) == 0 {
+		return res, nil
+	}
+
+	vecs, err := c.Inner.EmbedBatch(missTexts)
+	if err != nil {
+		return nil, err
+	}
+	for j, i := range missIdx {
+		res[i] = vecs[j]
+		for _, s := range c.Stores {
+			s.Set(keys[i], vecs[j])
+		}
+	}
+	return res, nil
+}
+
+func (c *Cached) get(key string) ([]float32, bool) {
+	for i, s := range c.Stores {
+		if vec, ok := s.Get(key); ok {
+			for _, prev := range c.Stores[:i] {
+				prev.Set(key, vec)
+			}
+			return vec, true
+		}
+	}
+	return nil, false
+}
+
+// Uncached 返回不经过缓存的embedder
+func Uncached(e Embedder) Embedder {
+	if c, ok := e.(*Cached); ok {
+		return c.Inner
+	}
+	return e
+}
+
+// CacheKey 文本去掉首尾空白并合并连续空白后计算哈希
+func CacheKey(modelID, text string) string {
+	normalized := cacheSpaceRe.ReplaceAllString(strings.TrimSpace(text), " ")
+	sum := sha256.Sum256([]byte(modelID + "\x00" + normalized))
+	return hex.EncodeToString(sum[:])
+}
+
+func encodeVector(vec []float32) []byte {
+	b := make([]byte, 4*len(vec))
+	for i, v := range vec {
+		binary.LittleEndian.PutUint32(b[i*4:], math.Float32bits(v))
+	}
+	return b
+}
+
+func decodeVector(b []byte) ([]float32, error) {
+	if len(b)%4 != 0 {
+		return nil, errors.New("invalid vector bytes")
+	}
+	vec := make([]float32, len(b)/4)
+	for i := range vec {
+		vec[i] = math.Float32frombits(binary.LittleEndian.Uint32(b[i*4:]))
+	}
+	return vec, nil
+}
+
+// RedisCacheStore 使用 conn.Redis
+type RedisCacheStore struct {
+	TTL        time.Duration
+	MaxEntries int64
+}
+
+func (rs *RedisCacheStore) Get(key string) ([]float32, bool) {
+	if conn.Redis == nil {
+		return nil, false
+	}
+	b, err := conn.Redis.Get(context.Background(), redisEmbedCachePrefix+key).Bytes()
+	if err != nil {
+		return nil, false
+	}
+	vec, err := decodeVector(b)
+	return vec, err == nil
+}
+
+func (rs *RedisCacheStore) Set(key string, vec []float32) {
+	if conn.Redis == nil {
+		return
+	}
+	ctx := context.Background()
+	pipe := conn.Redis.Pipeline()
+	pipe.Set(ctx, redisEmbedCachePrefix+key, encodeVector(vec), rs.TTL)
+	now := time.Now()
+	pipe.ZAdd(ctx, redisEmbedCacheIndex, &redis.Z{Score: float64(now.Unix()), Member: key})
+	// 向量已经过期的从索引中移除，否则会占用数量限制
+	if rs.TTL > 0 {
+		pipe.ZRemRangeByScore(ctx, redisEmbedCacheIndex, "-inf", fmt.Sprintf("(%d", now.Add(-rs.TTL).Unix()))
+	}
+	card := pipe.ZCard(ctx, redisEmbedCacheIndex)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return
+	}
+
+	// 超过数量限制时删除最早写入的
+	over := card.Val() - rs.MaxEntries
+	if rs.MaxEntries <= 0 || over <= 0 {
+		return
+	}
+	olds, err := conn.Redis.ZPopMin(ctx, redisEmbedCacheIndex, over).Result()
+	if err != nil {
+		return
+	}
+	keys := make([]string, 0, len(olds))
+	for _, o := range olds {
+		keys = append(keys, redisEmbedCachePrefix+o.Member.(string))
+	}
+	conn.Redis.Del(ctx, keys...)
+}
+
+// DiskCacheStore 每个向量一个文件，按修改时间判断过期和淘汰
+type DiskCacheStore struct {
+	Dir        string
+	TTL        time.Duration
+	MaxEntries int
+
+	sets int64
+}
+
+func (ds *DiskCacheStore) path(key string) string {
+	return filepath.Join(ds.Dir, key[:2], key+".bin")
+}
+
+func (ds *DiskCacheStore) Get(key string) ([]float32, bool) {
+	p := ds.path(key)
+	st, err := os.Stat(p)
+	if err != nil {
+		return nil, false
+	}
+	if ds.TTL > 0 && time.Since(st.ModTime()) > ds.TTL {
+		os.Remove(p)
+		return nil, false
+	}
+	b, err := os.ReadFile(p)
+	if err != nil {
+		return nil, false
+	}
+	vec, err := decodeVector(b)
+	return vec, err == nil
+}
+
+func (ds *DiskCacheStore) Set(key string, vec []float32) {
+	p := ds.path(key)
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		return
+	}
+	if err := os.WriteFile(p, encodeVector(vec), 0644); err != nil {
+		return
+	}
+	// 每写入100次检查一次数量
+	if atomic.AddInt64(&ds.sets, 1)%100 == 0 {
+		go ds.evict()
+	}
+}
+
+func (ds *DiskCacheStore) evict() {
+	if ds.MaxEntries <= 0 {
+		return
+	}
+	type entry struct {
+		path string
+		mod  time.Time
+	}
+	entries := make([]entry, 0)
+	filepath.Walk(ds.Dir, func(p string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() && strings.HasSuffix(p, ".bin") {
+			entries = append(entries, entry{p, info.ModTime()})
+		}
+		return nil
+	})
+	if len(entries) <= ds.MaxEntries {
+		return
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].mod.Before(entries[j].mod)
+	})
+	for _, e := range entries[:len(entries)-ds.MaxEntries] {
+		os.Remove(e.path)
+	}
+}
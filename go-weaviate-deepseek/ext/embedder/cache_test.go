@@ -0,0 +1,290 @@
+package embedder
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"go-weaviate-deepseek/conn"
+	"go-weaviate-deepseek/ext/redistest"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// countingEmbedder 记录每次调用的文本，向量为文本长度
+type countingEmbedder struct {
+	id    string
+	calls [][]string
+}
+
+func (c *countingEmbedder) ModelID() string { return c.id }
+func (c *countingEmbedder) Dimension() int  { return 1 }
+func (c *countingEmbedder) Embed(text string) ([]float32, error) {
+	res, err := c.EmbedBatch([]string{text})
+	if err != nil {
+		return nil, err
+	}
+	return res[0], nil
+}
+func (c *countingEmbedder) EmbedBatch(texts []string) ([][]float32, error) {
+	c.calls = append(c.calls, texts)
+	res := make([][]float32, 0, len(texts))
+	for _, t := range texts {
+		res = append(res, []float32{float32(len(t))})
+	}
+	return res, nil
+}
+
+type memoryStore map[string][]float32
+
+func (m memoryStore) Get(key string) ([]float32, bool) {
+	v, ok := m[key]
+	return v, ok
+}
+
+func (m memoryStore) Set(key string, vec []float32) {
+	m[key] = vec
+}
+
+func TestCacheKey(t *testing.T) {
+	cases := []struct {
+		desc  string
+		model string
+		a, b  string
+		same  bool
+	}{
+		{desc: "surrounding spaces", model: "m", a: "  hello world\n", b: "hello world", same: true},
+		{desc: "inner spaces", model: "m", a: "hello \t\n  world", b: "hello world", same: true},
+		{desc: "case sensitive", model: "m", a: "Hello", b: "hello", same: false},
+		{desc: "different text", model: "m", a: "hello", b: "world", same: false},
+		{desc: "no space is different", model: "m", a: "helloworld", b: "hello world", same: false},
+	}
+	for _, c := range cases {
+		if got := CacheKey(c.model, c.a) == CacheKey(c.model, c.b); got != c.same {
+			t.Errorf("%s: same key got %v, want %v", c.desc, got, c.same)
+		}
+	}
+	if CacheKey("m1", "hello") == CacheKey("m2", "hello") {
+		t.Errorf("different models should have different keys")
+	}
+	// 模型和文本之间有分隔符，拼接后相同也不会冲突
+	if CacheKey("ab", "c") == CacheKey("a", "bc") {
+		t.Errorf("model and text should be separated")
+	}
+}
+
+func TestCachedHitMiss(t *testing.T) {
+	inner := &countingEmbedder{id: "test:hit-miss"}
+	c := NewCached(inner, memoryStore{})
+
+	for _, text := range []string{"hello", " hello ", "world"} {
+		if _, err := c.Embed(text); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(inner.calls) != 2 {
+		t.Errorf("inner calls got %d, want 2", len(inner.calls))
+	}
+	got := GetCacheStats()[inner.id]
+	if got.Hits != 1 || got.Misses != 2 {
+		t.Errorf("stats got %+v, want 1 hit and 2 misses", got)
+	}
+}
+
+func TestCachedBypass(t *testing.T) {
+	cases := []struct {
+		desc string
+		c    *Cached
+	}{
+		{desc: "no stores", c: NewCached(&countingEmbedder{id: "test:no-stores"})},
+		{desc: "bypass", c: &Cached{Inner: &countingEmbedder{id: "test:bypass"}, Stores: []CacheStore{memoryStore{}}, Bypass: true}},
+	}
+	for _, c := range cases {
+		for i := 0; i < 2; i++ {
+			if _, err := c.c.Embed("hello"); err != nil {
+				t.Fatal(err)
+			}
+		}
+		inner := c.c.Inner.(*countingEmbedder)
+		if len(inner.calls) != 2 {
+			t.Errorf("%s: inner calls got %d, want 2", c.desc, len(inner.calls))
+		}
+		if s, ok := GetCacheStats()[inner.id]; ok {
+			t.Errorf("%s: stats should not be counted, got %+v", c.desc, s)
+		}
+	}
+
+	// 没有连接redis时redis缓存不生效
+	prev := conn.Redis
+	conn.Redis = nil
+	defer func() { conn.Redis = prev }()
+	rs := &RedisCacheStore{TTL: time.Hour}
+	rs.Set("k", []float32{1})
+	if _, ok := rs.Get("k"); ok {
+		t.Errorf("redis store without redis should miss")
+	}
+}
+
+func TestCachedBackfill(t *testing.T) {
+	inner := &countingEmbedder{id: "test:backfill"}
+	first, second := memoryStore{}, memoryStore{}
+	c := NewCached(inner, first, second)
+	second.Set(CacheKey(inner.id, "bb"), []float32{100})
+
+	res, err := c.EmbedBatch([]string{"a", "bb", "ccc"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := [][]float32{{1}, {100}, {3}}; !reflect.DeepEqual(res, want) {
+		t.Errorf("result got %v, want %v", res, want)
+	}
+	if want := [][]string{{"a", "ccc"}}; !reflect.DeepEqual(inner.calls, want) {
+		t.Errorf("inner calls got %v, want %v", inner.calls, want)
+	}
+	// 后面store命中的回填到前面，未命中的写入所有store
+	for _, text := range []string{"a", "bb", "ccc"} {
+		if _, ok := first.Get(CacheKey(inner.id, text)); !ok {
+			t.Errorf("%s should be cached in the first store", text)
+		}
+		if _, ok := second.Get(CacheKey(inner.id, text)); !ok {
+			t.Errorf("%s should be cached in the second store", text)
+		}
+	}
+
+	// 再次请求全部命中
+	if _, err := c.EmbedBatch([]string{"ccc", "a"}); err != nil {
+		t.Fatal(err)
+	}
+	if len(inner.calls) != 1 {
+		t.Errorf("inner calls got %d, want 1", len(inner.calls))
+	}
+}
+
+func useRedis(t *testing.T) *redistest.Server {
+	s, c := redistest.Run(t)
+	prev := conn.Redis
+	conn.Redis = c
+	t.Cleanup(func() { conn.Redis = prev })
+	return s
+}
+
+func TestRedisCacheStoreTTL(t *testing.T) {
+	s := useRedis(t)
+	rs := &RedisCacheStore{TTL: time.Hour}
+	rs.Set("k", []float32{1, 2})
+	if v, ok := rs.Get("k"); !ok || !reflect.DeepEqual(v, []float32{1, 2}) {
+		t.Fatalf("get got %v, %v", v, ok)
+	}
+	s.FastForward(time.Hour + time.Second)
+	if _, ok := rs.Get("k"); ok {
+		t.Errorf("expired vector should miss")
+	}
+}
+
+func TestRedisCacheStoreTrimExpired(t *testing.T) {
+	useRedis(t)
+	ctx := context.Background()
+	now := time.Now()
+	// 向量已经过期但索引中还有
+	conn.Redis.ZAdd(ctx, redisEmbedCacheIndex,
+		&redis.Z{Score: float64(now.Add(-2 * time.Hour).Unix()), Member: "expired"},
+		&redis.Z{Score: float64(now.Add(-30 * time.Minute).Unix()), Member: "alive"},
+	)
+
+	rs := &RedisCacheStore{TTL: time.Hour, MaxEntries: 10}
+	rs.Set("new", []float32{1})
+	members, err := conn.Redis.ZRange(ctx, redisEmbedCacheIndex, 0, -1).Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"alive", "new"}; !reflect.DeepEqual(members, want) {
+		t.Errorf("index got %v, want %v", members, want)
+	}
+}
+
+func TestRedisCacheStoreEviction(t *testing.T) {
+	useRedis(t)
+	ctx := context.Background()
+	now := time.Now()
+	for i, key := range []string{"oldest", "older"} {
+		conn.Redis.Set(ctx, redisEmbedCachePrefix+key, encodeVector([]float32{1}), 0)
+		conn.Redis.ZAdd(ctx, redisEmbedCacheIndex, &redis.Z{Score: float64(now.Add(time.Duration(i-10) * time.Second).Unix()), Member: key})
+	}
+
+	rs := &RedisCacheStore{TTL: time.Hour, MaxEntries: 2}
+	rs.Set("new", []float32{1})
+	if _, ok := rs.Get("oldest"); ok {
+		t.Errorf("oldest should be evicted")
+	}
+	for _, key := range []string{"older", "new"} {
+		if _, ok := rs.Get(key); !ok {
+			t.Errorf("%s should be kept", key)
+		}
+	}
+	if n := conn.Redis.ZCard(ctx, redisEmbedCacheIndex).Val(); n != 2 {
+		t.Errorf("index size got %d, want 2", n)
+	}
+}
+
+// diskKey 磁盘缓存按key的前两个字符分目录
+func diskKey(s string) string {
+	return CacheKey("test:disk", s)
+}
+
+func TestDiskCacheStoreTTL(t *testing.T) {
+	ds := &DiskCacheStore{Dir: t.TempDir(), TTL: time.Hour}
+	key := diskKey("hello")
+	ds.Set(key, []float32{1, 2})
+	if v, ok := ds.Get(key); !ok || !reflect.DeepEqual(v, []float32{1, 2}) {
+		t.Fatalf("get got %v, %v", v, ok)
+	}
+
+	old := time.Now().Add(-2 * time.Hour)
+	if err := os.Chtimes(ds.path(key), old, old); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := ds.Get(key); ok {
+		t.Errorf("expired vector should miss")
+	}
+	if _, err := os.Stat(ds.path(key)); !os.IsNotExist(err) {
+		t.Errorf("expired file should be removed, err: %v", err)
+	}
+}
+
+func TestDiskCacheStoreEviction(t *testing.T) {
+	ds := &DiskCacheStore{Dir: t.TempDir(), MaxEntries: 2}
+	keys := []string{diskKey("a"), diskKey("b"), diskKey("c")}
+	for i, key := range keys {
+		ds.Set(key, []float32{1})
+		mod := time.Now().Add(time.Duration(i-10) * time.Minute)
+		if err := os.Chtimes(ds.path(key), mod, mod); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ds.evict()
+
+	files := make([]string, 0)
+	filepath.Walk(ds.Dir, func(p string, info os.FileInfo, err error) error {
+		if err == nil && strings.HasSuffix(p, ".bin") {
+			files = append(files, strings.TrimSuffix(filepath.Base(p), ".bin"))
+		}
+		return nil
+	})
+	if len(files) != 2 {
+		t.Fatalf("files got %v, want 2", files)
+	}
+	if _, ok := ds.Get(keys[0]); ok {
+		t.Errorf("oldest should be evicted")
+	}
+
+	// 没有数量限制时不淘汰
+	unlimited := &DiskCacheStore{Dir: ds.Dir}
+	unlimited.evict()
+	if _, ok := unlimited.Get(keys[2]); !ok {
+		t.Errorf("newest should be kept")
+	}
+}
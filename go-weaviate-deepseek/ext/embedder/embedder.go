@@ -0,0 +1,112 @@
+package embedder
+
+import (
+	"fmt"
+	"go-weaviate-deepseek/conf"
+	"os"
+	"sync"
+	"time"
+)
+
+// Embedder 文本向量化
+type Embedder interface {
+	// ModelID 唯一标识使用的模型，例如 "openai:text-embedding-v3"
+	ModelID() string
+	// Dimension 向量维度，未知时返回0
+	Dimension() int
+	Embed(text string) ([]float32, error)
+	EmbedBatch(texts []string) ([][]float32, error)
+}
+
+var (
+	registry     = make(map[string]Embedder)
+	registryLock sync.Mutex
+)
+
+// New 根据配置创建
+func New(c *conf.EmbedderConf) (Embedder, error) {
+	switch c.Type {
+	case conf.EmbedderTypeOpenAI, "":
+		return NewOpenAI(c.BaseURL, c.Key(), c.Model, c.Dimension, c.BatchSize), nil
+	case conf.EmbedderTypeOllama:
+		return NewOllama(c.BaseURL, c.Model, c.Dimension), nil
+	case conf.EmbedderTypeHash:
+		return NewHash(c.Dimension), nil
+	}
+	return nil, fmt.Errorf("unknown embedder type: %s", c.Type)
+}
+
+// Get 按配置中的名称获取，创建后缓存
+func Get(name string) (Embedder, error) {
+	registryLock.Lock()
+	defer registryLock.Unlock()
+	if e, exists := registry[name]; exists {
+		return e, nil
+	}
+
+	c := conf.Settings.GetEmbedder(name)
+	if c == nil {
+		return nil, fmt.Errorf("embedder %s is not configured", name)
+	}
+	e, err := New(c)
+	if err != nil {
+		return nil, err
+	}
+	e = withCache(e)
+	registry[name] = e
+	return e, nil
+}
+
+// withCache hash类型不需要缓存
+func withCache(e Embedder) Embedder {
+	cc := conf.Settings.EmbeddingCache
+	if _, isHash := e.(*Hash); isHash || cc == nil || !cc.Enabled {
+		return e
+	}
+
+	ttl := time.Duration(cc.TTLHours) * time.Hour
+	stores := make([]CacheStore, 0)
+	if len(cc.DiskDir) > 0 {
+		stores = append(stores, &DiskCacheStore{Dir: cc.DiskDir, TTL: ttl, MaxEntries: cc.DiskMaxEntries})
+	}
+	stores = append(stores, &RedisCacheStore{TTL: ttl, MaxEntries: cc.MaxEntries})
+
+	c := NewCached(e, stores...)
+	c.Bypass = cc.Bypass || os.Getenv("EMBED_CACHE_BYPASS") == "true"
+	return c
+}
+
+// Register 替换或增加embedder，测试时可以注册 Hash
+func Register(name string, e Embedder) {
+	registryLock.Lock()
+	registry[name] = e
+	registryLock.Unlock()
+}
+
+func Default() (Embedder, error) {
+	return Get(conf.Settings.DefaultEmbedder)
+}
+
+// ForCollection 集合没有单独配置时使用默认的embedder
+func ForCollection(clsName string) (Embedder, error) {
+	if name, exists := conf.Settings.CollectionEmbedders[clsName]; exists {
+		return Get(name)
+	}
+	return Default()
+}
+
+// Chunk 把texts按size分组
+func Chunk(texts []string, size int) [][]string {
+	if size <= 0 {
+		size = len(texts)
+	}
+	res := make([][]string, 0)
+	for i := 0; i < len(texts); i += size {
+		end := i + size
+		if end > len(texts) {
+			end = len(texts)
+		}
+		res = append(res, texts[i:end])
+	}
+	return res
+}
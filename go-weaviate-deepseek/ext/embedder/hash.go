@@ -0,0 +1,80 @@
+package embedder
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math"
+	"regexp"
+	"strings"
+)
+
+var hashWordRe = regexp.MustCompile(`\p{Han}|\p{Hiragana}|\p{Katakana}|\p{Hangul}|[\p{L}\p{N}]+`)
+
+// Hash 不调用任何接口，按词做特征哈希生成确定的向量，相同词越多越相似，用于离线测试
+type Hash struct {
+	Dim int
+}
+
+func NewHash(dim int) *Hash {
+	if dim <= 0 {
+		dim = 256
+	}
+	return &Hash{Dim: dim}
+}
+
+func (h *Hash) ModelID() string {
+	return fmt.Sprintf("hash:%d", h.Dim)
+}
+
+func (h *Hash) Dimension() int {
+	return h.Dim
+}
+
+func (h *Hash) Embed(text string) ([]float32, error) {
+	res := make([]float32, h.Dim)
+	words := hashWordRe.FindAllString(strings.ToLower(text), -1)
+	// 单个词和相邻的两个词都作为特征
+	for i, w := range words {
+		h.add(res, w)
+		if i > 0 {
+			h.add(res, words[i-1]+" "+w)
+		}
+	}
+
+	var norm float64
+	for _, v := range res {
+		norm += float64(v) * float64(v)
+	}
+	if norm == 0 {
+		return res, nil
+	}
+	norm = math.Sqrt(norm)
+	for i := range res {
+		res[i] = float32(float64(res[i]) / norm)
+	}
+	return res, nil
+}
+
+func (h *Hash) add(vec []float32, feature string) {
+	hs := fnv.New64a()
+	hs.Write([]byte(feature))
+	sum := hs.Sum64()
+	idx := sum % uint64(h.Dim)
+	if sum>>63 == 1 {
+		vec[idx]--
+	} else {
+		vec[idx]++
+	}
+}
+
+func (h *Hash) EmbedBatch(texts []string) ([][]float32, error) {
+	res := make([][]float32, len(texts))
+	for i, t := range texts {
+		v, err := h.Embed(t)
+		if err != nil {
+			return nil, err
+		}
+		res[i] = v
+	}
+	return res, nil
+}
@@ -0,0 +1,86 @@
+package embedder
+
+import (
+	"math"
+	"testing"
+)
+
+func cosine(a, b []float32) float64 {
+	var dot float64
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+	}
+	return dot
+}
+
+func TestHashDimension(t *testing.T) {
+	cases := []struct {
+		dim  int
+		want int
+	}{
+		{0, 256},
+		{-1, 256},
+		{64, 64},
+		{1024, 1024},
+	}
+	for _, c := range cases {
+		h := NewHash(c.dim)
+		v, err := h.Embed("如何重置密码 reset password")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if h.Dimension() != c.want || len(v) != c.want {
+			t.Errorf("NewHash(%d): dimension %d, vector %d, want %d", c.dim, h.Dimension(), len(v), c.want)
+		}
+	}
+}
+
+func TestHashEmbed(t *testing.T) {
+	h := NewHash(256)
+	cases := []struct {
+		text     string
+		zeroNorm bool
+	}{
+		{"reset the password", false},
+		{"如何重置密码", false},
+		{"RESET the Password", false},
+		{"", true},
+		{"!!! ...", true},
+	}
+	for _, c := range cases {
+		v1, _ := h.Embed(c.text)
+		v2, _ := h.Embed(c.text)
+		for i := range v1 {
+			if v1[i] != v2[i] {
+				t.Fatalf("%q: embedding is not deterministic", c.text)
+			}
+		}
+		norm := math.Sqrt(cosine(v1, v1))
+		if c.zeroNorm && norm != 0 {
+			t.Errorf("%q: norm = %f, want 0", c.text, norm)
+		}
+		if !c.zeroNorm && math.Abs(norm-1) > 1e-5 {
+			t.Errorf("%q: norm = %f, want 1", c.text, norm)
+		}
+	}
+
+	// 大小写不影响，相同词越多越相似
+	a, _ := h.Embed("reset the password")
+	b, _ := h.Embed("RESET the Password")
+	c, _ := h.Embed("how to reset the password")
+	d, _ := h.Embed("weather in tokyo today")
+	if cosine(a, b) < 0.9999 {
+		t.Errorf("case should not matter, cosine = %f", cosine(a, b))
+	}
+	if cosine(a, c) <= cosine(a, d) {
+		t.Errorf("similar text cosine %f <= unrelated text cosine %f", cosine(a, c), cosine(a, d))
+	}
+
+	batch, err := h.EmbedBatch([]string{"reset the password", "weather in tokyo today"})
+	if err != nil || len(batch) != 2 || cosine(batch[0], a) < 0.9999 || cosine(batch[1], d) < 0.9999 {
+		t.Errorf("EmbedBatch differs from Embed, err: %v", err)
+	}
+	if h.ModelID() != "hash:256" {
+		t.Errorf("ModelID = %s", h.ModelID())
+	}
+}
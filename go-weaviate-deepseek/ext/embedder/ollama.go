@@ -0,0 +1,64 @@
+package embedder
+
+import (
+	"context"
+	"fmt"
+	"go-weaviate-deepseek/ext/llmhttp"
+
+	"github.com/tidwall/gjson"
+)
+
+// Ollama 本地部署的ollama, 使用 /api/embed 接口
+type Ollama struct {
+	BaseURL string
+	Model   string
+	Dim     int
+}
+
+func NewOllama(baseURL, model string, dim int) *Ollama {
+	if len(baseURL) == 0 {
+		baseURL = "http://localhost:11434"
+	}
+	return &Ollama{
+		BaseURL: baseURL,
+		Model:   model,
+		Dim:     dim,
+	}
+}
+
+func (o *Ollama) ModelID() string {
+	return "ollama:" + o.Model
+}
+
+func (o *Ollama) Dimension() int {
+	return o.Dim
+}
+
+func (o *Ollama) Embed(text string) ([]float32, error) {
+	res, err := o.EmbedBatch([]string{text})
+	if err != nil {
+		return nil, err
+	}
+	return res[0], nil
+}
+
+func (o *Ollama) EmbedBatch(texts []string) ([][]float32, error) {
+	resp, err := llmhttp.Default.Post(context.Background(), o.BaseURL+"/api/embed", "", map[string]interface{}{
+		"model": o.Model,
+		"input": texts,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	bodyDoc := gjson.ParseBytes(resp.Body())
+	embeddings := bodyDoc.Get("embeddings").Array()
+	if len(embeddings) != len(texts) {
+		return nil, fmt.Errorf("embedding size mismatch, want: %d, got: %d", len(texts), len(embeddings))
+	}
+	res := make([][]float32, len(texts))
+	for i, e := range embeddings {
+		res[i] = toFloat32s(e)
+	}
+	return res, nil
+}
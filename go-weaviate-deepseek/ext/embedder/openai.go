@@ -0,0 +1,103 @@
+package embedder
+
+import (
+	"context"
+	"fmt"
+	"go-weaviate-deepseek/ext/llmhttp"
+
+	"github.com/tidwall/gjson"
+)
+
+// OpenAI 任意OpenAI兼容的 /embeddings 接口，例如阿里云百炼 text-embedding-v3
+type OpenAI struct {
+	BaseURL   string
+	APIKey    string
+	Model     string
+	Dim       int
+	BatchSize int
+}
+
+func NewOpenAI(baseURL, apiKey, model string, dim, batchSize int) *OpenAI {
+	if batchSize <= 0 {
+		batchSize = 10
+	}
+	return &OpenAI{
+		BaseURL:   baseURL,
+		APIKey:    apiKey,
+		Model:     model,
+		Dim:       dim,
+		BatchSize: batchSize,
+	}
+}
+
+func (o *OpenAI) ModelID() string {
+	return "openai:" + o.Model
+}
+
+func (o *OpenAI) Dimension() int {
+	return o.Dim
+}
+
+func (o *OpenAI) Embed(text string) ([]float32, error) {
+	res, err := o.EmbedBatch([]string{text})
+	if err != nil {
+		return nil, err
+	}
+	return res[0], nil
+}
+
+func (o *OpenAI) EmbedBatch(texts []string) ([][]float32, error) {
+	res := make([][]float32, 0, len(texts))
+	for _, batch := range Chunk(texts, o.BatchSize) {
+		vecs, err := o.embed(batch)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, vecs...)
+	}
+	return res, nil
+}
+
+func (o *OpenAI) embed(texts []string) ([][]float32, error) {
+	requestBody := map[string]interface{}{
+		"model":           o.Model,
+		"input":           texts,
+		"encoding_format": "float",
+	}
+	if o.Dim > 0 {
+		requestBody["dimensions"] = o.Dim
+	}
+
+	resp, err := llmhttp.Default.Post(context.Background(), o.BaseURL+"/embeddings", o.APIKey, requestBody)
+	if err != nil {
+		return nil, err
+	}
+
+	bodyDoc := gjson.ParseBytes(resp.Body())
+
+	data := bodyDoc.Get("data").Array()
+	if len(data) != len(texts) {
+		return nil, fmt.Errorf("embedding size mismatch, want: %d, got: %d", len(texts), len(data))
+	}
+	res := make([][]float32, len(texts))
+	for i, d := range data {
+		idx := i
+		if d.Get("index").Exists() {
+			idx = int(d.Get("index").Int())
+		}
+		if idx < 0 || idx >= len(texts) {
+			return nil, fmt.Errorf("invalid embedding index: %d", idx)
+		}
+		res[idx] = toFloat32s(d.Get("embedding"))
+	}
+	return res, nil
+}
+
+func toFloat32s(arr gjson.Result) []float32 {
+	vals := arr.Array()
+	res := make([]float32, len(vals))
+	for i, v := range vals {
+		res[i] = float32(v.Float())
+	}
+	return res
+}
@@ -0,0 +1,112 @@
+package ext
+
+import (
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+const (
+	LangZH      = "zh"
+	LangJA      = "ja"
+	LangKO      = "ko"
+	LangEN      = "en"
+	LangFR      = "fr"
+	LangDE      = "de"
+	LangES      = "es"
+	LangRU      = "ru"
+	LangAR      = "ar"
+	LangTH      = "th"
+	LangUnknown = ""
+)
+
+// 拉丁字母语言通过常用词区分
+var latinStopwords = map[string][]string{
+	LangEN: {"the", "and", "is", "are", "of", "to", "in", "that", "it", "with", "for", "what", "how"},
+	LangFR: {"le", "la", "les", "et", "est", "des", "une", "que", "pour", "dans", "pas", "avec"},
+	LangDE: {"der", "die", "das", "und", "ist", "nicht", "ein", "eine", "mit", "für", "auf", "wie"},
+	LangES: {"el", "los", "las", "es", "una", "que", "por", "para", "con", "del", "cómo", "qué"},
+}
+
+var latinWordRe = regexp.MustCompile(`[\p{Latin}]+`)
+
+// DetectLang 按字符的书写系统判断语言，拉丁字母再按常用词区分，无法判断时返回 LangUnknown
+func DetectLang(text string) string {
+	var han, kana, hangul, cyrillic, arabic, thai, latin int
+	for _, r := range text {
+		switch {
+		case unicode.Is(unicode.Hiragana, r), unicode.Is(unicode.Katakana, r):
+			kana++
+		case unicode.Is(unicode.Han, r):
+			han++
+		case unicode.Is(unicode.Hangul, r):
+			hangul++
+		case unicode.Is(unicode.Cyrillic, r):
+			cyrillic++
+		case unicode.Is(unicode.Arabic, r):
+			arabic++
+		case unicode.Is(unicode.Thai, r):
+			thai++
+		case unicode.Is(unicode.Latin, r):
+			latin++
+		}
+	}
+
+	// 日文中也有汉字，有一定比例的假名就认为是日文
+	if kana > 0 && kana*5 >= han {
+		return LangJA
+	}
+	if hangul > 0 && hangul >= han {
+		return LangKO
+	}
+	// 一个汉字的信息量大约相当于几个字母
+	if han > 0 && han*3 >= latin {
+		return LangZH
+	}
+
+	counts := map[string]int{LangRU: cyrillic, LangAR: arabic, LangTH: thai}
+	best, bestCount := LangUnknown, 0
+	for lang, c := range counts {
+		if c > bestCount {
+			best, bestCount = lang, c
+		}
+	}
+	if bestCount > latin {
+		return best
+	}
+	if latin > 0 {
+		return detectLatinLang(text)
+	}
+	return best
+}
+
+func detectLatinLang(text string) string {
+	words := latinWordRe.FindAllString(strings.ToLower(text), 2000)
+	scores := make(map[string]int)
+	for _, w := range words {
+		for lang, stops := range latinStopwords {
+			for _, s := range stops {
+				if w == s {
+					scores[lang]++
+				}
+			}
+		}
+	}
+	best, bestScore := LangEN, 0
+	for _, lang := range []string{LangEN, LangFR, LangDE, LangES} {
+		if scores[lang] > bestScore {
+			best, bestScore = lang, scores[lang]
+		}
+	}
+	return best
+}
+
+// SentenceDelimiter 分段时补在句尾的标点
+func SentenceDelimiter(lang string) string {
+	switch lang {
+	case LangZH, LangJA:
+		return "。"
+	default:
+		return "."
+	}
+}
@@ -0,0 +1,48 @@
+package ext
+
+import "testing"
+
+func TestDetectLang(t *testing.T) {
+	cases := []struct {
+		text string
+		want string
+	}{
+		{"如何重置密码？", LangZH},
+		{"如何使用 API key 调用接口", LangZH},
+		{"パスワードをリセットするにはどうすればいいですか", LangJA},
+		{"東京の天気はどうですか", LangJA},
+		{"비밀번호를 재설정하는 방법", LangKO},
+		{"Как сбросить пароль?", LangRU},
+		{"كيف يمكنني إعادة تعيين كلمة المرور", LangAR},
+		{"วิธีรีเซ็ตรหัสผ่าน", LangTH},
+		{"How do I reset the password for my account?", LangEN},
+		{"Comment réinitialiser le mot de passe pour mon compte et les données", LangFR},
+		{"Wie kann ich das Passwort für mein Konto zurücksetzen und die Daten", LangDE},
+		{"Cómo puedo cambiar la contraseña de los usuarios para el sistema", LangES},
+		{"API", LangEN},
+		{"12345 !?", LangUnknown},
+		{"", LangUnknown},
+	}
+	for _, c := range cases {
+		if got := DetectLang(c.text); got != c.want {
+			t.Errorf("DetectLang(%q) = %q, want %q", c.text, got, c.want)
+		}
+	}
+}
+
+func TestSentenceDelimiter(t *testing.T) {
+	cases := []struct {
+		lang string
+		want string
+	}{
+		{LangZH, "。"},
+		{LangJA, "。"},
+		{LangEN, "."},
+		{LangUnknown, "."},
+	}
+	for _, c := range cases {
+		if got := SentenceDelimiter(c.lang); got != c.want {
+			t.Errorf("SentenceDelimiter(%q) = %q, want %q", c.lang, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package llmhttp
+
+import (
+	"sync"
+	"time"
+)
+
+// Breaker 连续失败Threshold次后熔断，Cooldown之后放行一个请求试探，成功则恢复
+type Breaker struct {
+	Threshold int
+	Cooldown  time.Duration
+
+	failures int
+	openedAt time.Time
+	probing  bool
+	lock     sync.Mutex
+}
+
+func NewBreaker(threshold int, cooldown time.Duration) *Breaker {
+	return &Breaker{Threshold: threshold, Cooldown: cooldown}
+}
+
+func (b *Breaker) Allow() bool {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if b.failures < b.Threshold {
+		return true
+	}
+	if time.Since(b.openedAt) < b.Cooldown || b.probing {
+		return false
+	}
+	b.probing = true
+	return true
+}
+
+func (b *Breaker) Success() {
+	b.lock.Lock()
+	b.failures = 0
+	b.probing = false
+	b.lock.Unlock()
+}
+
+// Release 结果不能说明服务是否可用，只释放试探的名额
+func (b *Breaker) Release() {
+	b.lock.Lock()
+	b.probing = false
+	b.lock.Unlock()
+}
+
+func (b *Breaker) Failure() {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.failures++
+	b.probing = false
+	if b.failures >= b.Threshold {
+		b.openedAt = time.Now()
+	}
+}
+
+// State closed | open | half-open
+func (b *Breaker) State() string {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if b.failures < b.Threshold {
+		return "closed"
+	}
+	if time.Since(b.openedAt) < b.Cooldown {
+		return "open"
+	}
+	return "half-open"
+}
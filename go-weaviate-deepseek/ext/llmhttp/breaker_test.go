@@ -0,0 +1,96 @@
+package llmhttp
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBreakerTransitions(t *testing.T) {
+	type step struct {
+		op        string // allow | success | failure | release | wait
+		wantAllow bool
+		wantState string
+	}
+	cases := []struct {
+		desc  string
+		steps []step
+	}{
+		{
+			desc: "opens after threshold failures",
+			steps: []step{
+				{op: "failure", wantState: "closed"},
+				{op: "failure", wantState: "open"},
+				{op: "allow", wantAllow: false, wantState: "open"},
+			},
+		},
+		{
+			desc: "success resets failures",
+			steps: []step{
+				{op: "failure", wantState: "closed"},
+				{op: "success", wantState: "closed"},
+				{op: "failure", wantState: "closed"},
+			},
+		},
+		{
+			desc: "half-open allows one probe and closes on success",
+			steps: []step{
+				{op: "failure"}, {op: "failure", wantState: "open"},
+				{op: "wait", wantState: "half-open"},
+				{op: "allow", wantAllow: true, wantState: "half-open"},
+				{op: "allow", wantAllow: false, wantState: "half-open"},
+				{op: "success", wantState: "closed"},
+				{op: "allow", wantAllow: true, wantState: "closed"},
+			},
+		},
+		{
+			desc: "failed probe opens again",
+			steps: []step{
+				{op: "failure"}, {op: "failure"},
+				{op: "wait"},
+				{op: "allow", wantAllow: true, wantState: "half-open"},
+				{op: "failure", wantState: "open"},
+				{op: "allow", wantAllow: false, wantState: "open"},
+			},
+		},
+		{
+			desc: "released probe stays half-open and allows another probe",
+			steps: []step{
+				{op: "failure"}, {op: "failure"},
+				{op: "wait"},
+				{op: "allow", wantAllow: true, wantState: "half-open"},
+				{op: "release", wantState: "half-open"},
+				{op: "allow", wantAllow: true, wantState: "half-open"},
+			},
+		},
+		{
+			desc: "release does not reset failures",
+			steps: []step{
+				{op: "failure", wantState: "closed"},
+				{op: "release", wantState: "closed"},
+				{op: "failure", wantState: "open"},
+			},
+		},
+	}
+	for _, c := range cases {
+		b := NewBreaker(2, 20*time.Millisecond)
+		for i, s := range c.steps {
+			switch s.op {
+			case "allow":
+				if got := b.Allow(); got != s.wantAllow {
+					t.Errorf("%s: step %d allow = %v, want %v", c.desc, i, got, s.wantAllow)
+				}
+			case "success":
+				b.Success()
+			case "failure":
+				b.Failure()
+			case "release":
+				b.Release()
+			case "wait":
+				time.Sleep(30 * time.Millisecond)
+			}
+			if len(s.wantState) > 0 && b.State() != s.wantState {
+				t.Errorf("%s: step %d state = %s, want %s", c.desc, i, b.State(), s.wantState)
+			}
+		}
+	}
+}
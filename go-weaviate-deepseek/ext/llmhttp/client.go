@@ -0,0 +1,213 @@
+package llmhttp
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/tidwall/gjson"
+	"gopkg.in/resty.v1"
+)
+
+// Client 调用大模型接口的http client，带重试、限流和熔断，按host分别熔断
+type Client struct {
+	Timeout       time.Duration // 非流式请求的超时
+	StreamTimeout time.Duration // 流式请求整体的超时
+	MaxRetries    int
+	BaseDelay     time.Duration
+	MaxDelay      time.Duration
+	Limiter       *TokenBucket
+
+	BreakerThreshold int
+	BreakerCooldown  time.Duration
+
+	breakers    map[string]*Breaker
+	breakerLock sync.Mutex
+}
+
+// Default 全局共享
+var Default = New()
+
+func New() *Client {
+	return &Client{
+		Timeout:          3 * time.Minute,
+		StreamTimeout:    10 * time.Minute,
+		MaxRetries:       3,
+		BaseDelay:        500 * time.Millisecond,
+		MaxDelay:         20 * time.Second,
+		Limiter:          NewTokenBucket(20, 40),
+		BreakerThreshold: 5,
+		BreakerCooldown:  30 * time.Second,
+		breakers:         make(map[string]*Breaker),
+	}
+}
+
+// Post 发送json请求，返回的body中有error字段时也作为错误返回
+func (c *Client) Post(ctx context.Context, urlStr, apiKey string, body interface{}) (*resty.Response, error) {
+	var res *resty.Response
+	err := c.do(ctx, urlStr, func(ctx context.Context) error {
+		rsp, err := c.request(ctx, c.Timeout, apiKey, body).Post(urlStr)
+		if err != nil {
+			return err
+		}
+		if err := checkResponse(rsp.StatusCode(), rsp.Header(), rsp.Body()); err != nil {
+			return err
+		}
+		res = rsp
+		return nil
+	})
+	return res, err
+}
+
+// PostStream 流式请求，只在收到响应之前重试，调用方需要关闭返回的body
+func (c *Client) PostStream(ctx context.Context, urlStr, apiKey string, body interface{}) (io.ReadCloser, error) {
+	var res io.ReadCloser
+	err := c.do(ctx, urlStr, func(ctx context.Context) error {
+		rsp, err := c.request(ctx, c.StreamTimeout, apiKey, body).
+			SetDoNotParseResponse(true).
+			Post(urlStr)
+		if err != nil {
+			return err
+		}
+		if rsp.StatusCode() < 200 || rsp.StatusCode() >= 300 {
+			defer rsp.RawBody().Close()
+			b, _ := io.ReadAll(io.LimitReader(rsp.RawBody(), 64<<10))
+			return checkResponse(rsp.StatusCode(), rsp.Header(), b)
+		}
+		res = rsp.RawBody()
+		return nil
+	})
+	return res, err
+}
+
+// BreakerStates 每个host的熔断状态
+func (c *Client) BreakerStates() map[string]string {
+	c.breakerLock.Lock()
+	defer c.breakerLock.Unlock()
+	res := make(map[string]string, len(c.breakers))
+	for host, b := range c.breakers {
+		res[host] = b.State()
+	}
+	return res
+}
+
+func (c *Client) request(ctx context.Context, timeout time.Duration, apiKey string, body interface{}) *resty.Request {
+	r := resty.New().
+		SetTimeout(timeout).
+		R().
+		SetContext(ctx).
+		SetHeader("Content-Type", "application/json").
+		SetBody(body)
+	if len(apiKey) > 0 {
+		r.SetHeader("Authorization", "Bearer "+apiKey)
+	}
+	return r
+}
+
+func (c *Client) do(ctx context.Context, urlStr string, fn func(ctx context.Context) error) error {
+	breaker := c.breaker(urlStr)
+	var err error
+	for attempt := 0; attempt <= c.MaxRetries; attempt++ {
+		if attempt > 0 {
+			delay := c.backoff(attempt, err)
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(delay):
+			}
+		}
+
+		if !breaker.Allow() {
+			return ErrCircuitOpen
+		}
+		if c.Limiter != nil {
+			if werr := c.Limiter.Wait(ctx); werr != nil {
+				return werr
+			}
+		}
+
+		err = fn(ctx)
+		if err == nil {
+			breaker.Success()
+			return nil
+		}
+		if !IsRetryable(err) || ctx.Err() != nil {
+			// 调用方取消、4xx等请求本身的错误既不算成功也不算服务故障
+			breaker.Release()
+			return err
+		}
+		breaker.Failure()
+	}
+	return err
+}
+
+// backoff 指数退避加随机抖动，服务端返回Retry-After时以它为准
+func (c *Client) backoff(attempt int, err error) time.Duration {
+	if apiErr, ok := err.(*APIError); ok && apiErr.RetryAfter > 0 {
+		if apiErr.RetryAfter > c.MaxDelay {
+			return c.MaxDelay
+		}
+		return apiErr.RetryAfter
+	}
+	d := c.BaseDelay << uint(attempt-1)
+	if d > c.MaxDelay || d <= 0 {
+		d = c.MaxDelay
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+func (c *Client) breaker(urlStr string) *Breaker {
+	host := urlStr
+	if u, err := url.Parse(urlStr); err == nil {
+		host = u.Host
+	}
+	c.breakerLock.Lock()
+	defer c.breakerLock.Unlock()
+	b, exists := c.breakers[host]
+	if !exists {
+		b = NewBreaker(c.BreakerThreshold, c.BreakerCooldown)
+		c.breakers[host] = b
+	}
+	return b
+}
+
+func checkResponse(status int, header http.Header, body []byte) error {
+	doc := gjson.ParseBytes(body)
+	if status >= 200 && status < 300 && !doc.Get("error").Exists() {
+		return nil
+	}
+
+	apiErr := &APIError{
+		StatusCode: status,
+		Code:       doc.Get("error.code").String(),
+		Message:    doc.Get("error.message").String(),
+		RetryAfter: parseRetryAfter(header.Get("Retry-After")),
+	}
+	if len(apiErr.Message) == 0 {
+		apiErr.Message = doc.Get("error").String()
+	}
+	if len(apiErr.Message) == 0 {
+		apiErr.Message = fmt.Sprintf("%.200s", string(body))
+	}
+	return apiErr
+}
+
+// parseRetryAfter 秒数或者http时间
+func parseRetryAfter(v string) time.Duration {
+	if len(v) == 0 {
+		return 0
+	}
+	if secs, err := strconv.Atoi(v); err == nil {
+		return time.Duration(secs) * time.Second
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		return time.Until(t)
+	}
+	return 0
+}
@@ -0,0 +1,136 @@
+package llmhttp
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestBackoff(t *testing.T) {
+	c := New()
+	c.BaseDelay = 100 * time.Millisecond
+	c.MaxDelay = time.Second
+	cases := []struct {
+		attempt int
+		err     error
+		min     time.Duration
+		max     time.Duration
+	}{
+		{attempt: 1, min: 50 * time.Millisecond, max: 100 * time.Millisecond},
+		{attempt: 2, min: 100 * time.Millisecond, max: 200 * time.Millisecond},
+		{attempt: 3, min: 200 * time.Millisecond, max: 400 * time.Millisecond},
+		{attempt: 10, min: 500 * time.Millisecond, max: time.Second},
+		{attempt: 70, min: 500 * time.Millisecond, max: time.Second},
+		{attempt: 1, err: &APIError{StatusCode: 429, RetryAfter: 300 * time.Millisecond}, min: 300 * time.Millisecond, max: 300 * time.Millisecond},
+		{attempt: 1, err: &APIError{StatusCode: 429, RetryAfter: time.Minute}, min: time.Second, max: time.Second},
+	}
+	for _, cs := range cases {
+		for i := 0; i < 20; i++ {
+			d := c.backoff(cs.attempt, cs.err)
+			if d < cs.min || d > cs.max {
+				t.Errorf("backoff(%d, %v) = %s, want [%s, %s]", cs.attempt, cs.err, d, cs.min, cs.max)
+				break
+			}
+		}
+	}
+}
+
+func TestIsRetryable(t *testing.T) {
+	cases := []struct {
+		err  error
+		want bool
+	}{
+		{nil, false},
+		{context.Canceled, false},
+		{context.DeadlineExceeded, true},
+		{ErrCircuitOpen, true},
+		{&APIError{StatusCode: 429}, true},
+		{&APIError{StatusCode: 503}, true},
+		{&APIError{StatusCode: 400}, false},
+		{&APIError{StatusCode: 401}, false},
+		{errors.New("invalid json"), false},
+	}
+	for _, c := range cases {
+		if got := IsRetryable(c.err); got != c.want {
+			t.Errorf("IsRetryable(%v) = %v, want %v", c.err, got, c.want)
+		}
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	cases := []struct {
+		v    string
+		want time.Duration
+	}{
+		{"", 0},
+		{"3", 3 * time.Second},
+		{"abc", 0},
+	}
+	for _, c := range cases {
+		if got := parseRetryAfter(c.v); got != c.want {
+			t.Errorf("parseRetryAfter(%q) = %s, want %s", c.v, got, c.want)
+		}
+	}
+}
+
+func TestPostBreaker(t *testing.T) {
+	cases := []struct {
+		desc      string
+		status    int
+		cancel    bool
+		wantErr   bool
+		wantCalls int32
+		wantState string
+	}{
+		{desc: "2xx", status: 200, wantCalls: 1, wantState: "closed"},
+		{desc: "4xx is neutral and not retried", status: 400, wantErr: true, wantCalls: 1, wantState: "closed"},
+		{desc: "5xx opens the breaker before the retry", status: 503, wantErr: true, wantCalls: 1, wantState: "open"},
+		{desc: "canceled is neutral", status: 200, cancel: true, wantErr: true, wantCalls: 0, wantState: "closed"},
+	}
+	for _, c := range cases {
+		var calls int32
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt32(&calls, 1)
+			w.WriteHeader(c.status)
+			_, _ = w.Write([]byte(`{"ok": true}`))
+		}))
+
+		client := New()
+		client.MaxRetries = 1
+		client.BaseDelay = time.Millisecond
+		client.BreakerThreshold = 2
+		client.BreakerCooldown = time.Minute
+		// 先记一次失败，成功会清零，中性的结果不影响
+		client.breaker(srv.URL).Failure()
+
+		ctx, cancel := context.WithCancel(context.Background())
+		if c.cancel {
+			cancel()
+		}
+		_, err := client.Post(ctx, srv.URL, "", map[string]string{})
+		cancel()
+		srv.Close()
+
+		if (err != nil) != c.wantErr {
+			t.Errorf("%s: err = %v", c.desc, err)
+		}
+		if calls != c.wantCalls {
+			t.Errorf("%s: calls = %d, want %d", c.desc, calls, c.wantCalls)
+		}
+		state := client.breaker(srv.URL)
+		if state.State() != c.wantState {
+			t.Errorf("%s: breaker state = %s, want %s", c.desc, state.State(), c.wantState)
+		}
+		if c.desc == "4xx is neutral and not retried" || c.cancel {
+			// 之前的失败没有被清零
+			state.Failure()
+			if state.State() != "open" {
+				t.Errorf("%s: neutral result should keep previous failures", c.desc)
+			}
+		}
+	}
+}
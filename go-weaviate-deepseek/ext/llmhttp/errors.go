@@ -0,0 +1,57 @@
+package llmhttp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+)
+
+// ErrCircuitOpen 连续失败太多次，暂停请求该服务
+var ErrCircuitOpen = errors.New("llm circuit breaker is open")
+
+// APIError 服务端返回的错误
+type APIError struct {
+	StatusCode int
+	Code       string
+	Message    string
+	RetryAfter time.Duration
+}
+
+func (e *APIError) Error() string {
+	if len(e.Code) > 0 {
+		return fmt.Sprintf("llm api error, status: %d, code: %s, message: %s", e.StatusCode, e.Code, e.Message)
+	}
+	return fmt.Sprintf("llm api error, status: %d, message: %s", e.StatusCode, e.Message)
+}
+
+// Retryable 429 和 5xx 可以重试
+func (e *APIError) Retryable() bool {
+	return e.StatusCode == 429 || e.StatusCode >= 500
+}
+
+// IsRetryable 超时、连接失败、429、5xx 和熔断都认为是服务暂时不可用
+func IsRetryable(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) {
+		return false
+	}
+	if errors.Is(err, ErrCircuitOpen) || errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.Retryable()
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return true
+	}
+	return false
+}
+
+// IsRateLimited 429
+func IsRateLimited(err error) bool {
+	var apiErr *APIError
+	return errors.As(err, &apiErr) && apiErr.StatusCode == 429
+}
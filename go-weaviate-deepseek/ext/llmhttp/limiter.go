@@ -0,0 +1,58 @@
+package llmhttp
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// TokenBucket 客户端限流，每秒补充Rate个令牌，最多Burst个
+type TokenBucket struct {
+	Rate  float64
+	Burst float64
+
+	tokens float64
+	last   time.Time
+	lock   sync.Mutex
+}
+
+func NewTokenBucket(rate, burst float64) *TokenBucket {
+	return &TokenBucket{
+		Rate:   rate,
+		Burst:  burst,
+		tokens: burst,
+		last:   time.Now(),
+	}
+}
+
+// Wait 等待获取一个令牌
+func (tb *TokenBucket) Wait(ctx context.Context) error {
+	for {
+		wait := tb.reserve()
+		if wait <= 0 {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(wait):
+		}
+	}
+}
+
+func (tb *TokenBucket) reserve() time.Duration {
+	tb.lock.Lock()
+	defer tb.lock.Unlock()
+
+	now := time.Now()
+	tb.tokens += now.Sub(tb.last).Seconds() * tb.Rate
+	if tb.tokens > tb.Burst {
+		tb.tokens = tb.Burst
+	}
+	tb.last = now
+	if tb.tokens >= 1 {
+		tb.tokens--
+		return 0
+	}
+	return time.Duration((1 - tb.tokens) / tb.Rate * float64(time.Second))
+}
@@ -0,0 +1,703 @@
+// Package redistest 测试用的内存redis，只实现了项目中用到的命令
+package redistest
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// Server 所有连接共用一份数据，过期时间按 Now 计算，可以用 FastForward 快进
+type Server struct {
+	mu      sync.Mutex
+	ln      net.Listener
+	offset  time.Duration
+	strs    map[string]string
+	hashes  map[string]map[string]string
+	zsets   map[string]map[string]float64
+	lists   map[string][]string
+	sets    map[string]map[string]bool
+	expires map[string]time.Time
+	// 每个key的修改次数，用于WATCH
+	versions map[string]int64
+}
+
+// Run 启动服务并返回连接到该服务的客户端，测试结束时关闭
+func Run(t testing.TB) (*Server, *redis.Client) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &Server{
+		ln:       ln,
+		strs:     make(map[string]string),
+		hashes:   make(map[string]map[string]string),
+		zsets:    make(map[string]map[string]float64),
+		lists:    make(map[string][]string),
+		sets:     make(map[string]map[string]bool),
+		expires:  make(map[string]time.Time),
+		versions: make(map[string]int64),
+	}
+	go s.serve()
+	c := redis.NewClient(&redis.Options{Addr: ln.Addr().String(), MaxRetries: -1})
+	t.Cleanup(func() {
+		c.Close()
+		ln.Close()
+	})
+	return s, c
+}
+
+// Now 快进后的当前时间
+func (s *Server) Now() time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.now()
+}
+
+// FastForward 让过期时间提前d
+func (s *Server) FastForward(d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.offset += d
+}
+
+func (s *Server) now() time.Time {
+	return time.Now().Add(s.offset)
+}
+
+func (s *Server) serve() {
+	for {
+		c, err := s.ln.Accept()
+		if err != nil {
+			return
+		}
+		go s.handle(c)
+	}
+}
+
+type session struct {
+	watched map[string]int64
+	queue   [][]string
+	inMulti bool
+}
+
+func (s *Server) handle(c net.Conn) {
+	defer c.Close()
+	r := bufio.NewReader(c)
+	w := bufio.NewWriter(c)
+	sess := &session{}
+	for {
+		args, err := readCommand(r)
+		if err != nil {
+			return
+		}
+		s.dispatch(sess, w, args)
+		if r.Buffered() == 0 {
+			if err := w.Flush(); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func readCommand(r *bufio.Reader) ([]string, error) {
+	line, err := readLine(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(line) == 0 || line[0] != '*' {
+		return strings.Fields(line), nil
+	}
+	n, err := strconv.Atoi(line[1:])
+	if err != nil {
+		return nil, err
+	}
+	args := make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		line, err := readLine(r)
+		if err != nil {
+			return nil, err
+		}
+		size, err := strconv.Atoi(strings.TrimPrefix(line, "$"))
+		if err != nil {
+			return nil, err
+		}
+		b := make([]byte, size+2)
+		if _, err := io.ReadFull(r, b); err != nil {
+			return nil, err
+		}
+		args = append(args, string(b[:size]))
+	}
+	return args, nil
+}
+
+func readLine(r *bufio.Reader) (string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// reply 命令的返回值：nil、string(简单字符串用status)、[]byte(bulk)、int64、error、[]interface{}
+type status string
+
+func writeReply(w *bufio.Writer, v interface{}) {
+	switch v := v.(type) {
+	case nil:
+		w.WriteString("$-1\r\n")
+	case status:
+		w.WriteString("+" + string(v) + "\r\n")
+	case error:
+		w.WriteString("-" + v.Error() + "\r\n")
+	case int64:
+		w.WriteString(":" + strconv.FormatInt(v, 10) + "\r\n")
+	case int:
+		w.WriteString(":" + strconv.Itoa(v) + "\r\n")
+	case string:
+		w.WriteString("$" + strconv.Itoa(len(v)) + "\r\n" + v + "\r\n")
+	case []interface{}:
+		if v == nil {
+			w.WriteString("*-1\r\n")
+			return
+		}
+		w.WriteString("*" + strconv.Itoa(len(v)) + "\r\n")
+		for _, e := range v {
+			writeReply(w, e)
+		}
+	default:
+		panic(fmt.Sprintf("redistest: unsupported reply %T", v))
+	}
+}
+
+var errSyntax = errors.New("ERR syntax error")
+
+func (s *Server) dispatch(sess *session, w *bufio.Writer, args []string) {
+	if len(args) == 0 {
+		return
+	}
+	cmd := strings.ToUpper(args[0])
+	switch cmd {
+	case "MULTI":
+		sess.inMulti = true
+		sess.queue = nil
+		writeReply(w, status("OK"))
+		return
+	case "EXEC":
+		s.mu.Lock()
+		aborted := false
+		for k, v := range sess.watched {
+			if s.versions[k] != v {
+				aborted = true
+			}
+		}
+		res := make([]interface{}, 0, len(sess.queue))
+		if !aborted {
+			for _, q := range sess.queue {
+				res = append(res, s.exec(q))
+			}
+		}
+		s.mu.Unlock()
+		sess.inMulti = false
+		sess.queue = nil
+		sess.watched = nil
+		if aborted {
+			writeReply(w, []interface{}(nil))
+			return
+		}
+		writeReply(w, res)
+		return
+	case "DISCARD":
+		sess.inMulti = false
+		sess.queue = nil
+		sess.watched = nil
+		writeReply(w, status("OK"))
+		return
+	case "WATCH":
+		s.mu.Lock()
+		if sess.watched == nil {
+			sess.watched = make(map[string]int64)
+		}
+		for _, k := range args[1:] {
+			s.expire(k)
+			sess.watched[k] = s.versions[k]
+		}
+		s.mu.Unlock()
+		writeReply(w, status("OK"))
+		return
+	case "UNWATCH":
+		sess.watched = nil
+		writeReply(w, status("OK"))
+		return
+	}
+	if sess.inMulti {
+		sess.queue = append(sess.queue, args)
+		writeReply(w, status("QUEUED"))
+		return
+	}
+	s.mu.Lock()
+	res := s.exec(args)
+	s.mu.Unlock()
+	writeReply(w, res)
+}
+
+// expire 删除已经过期的key
+func (s *Server) expire(key string) {
+	if t, ok := s.expires[key]; ok && !s.now().Before(t) {
+		s.del(key)
+	}
+}
+
+func (s *Server) del(key string) bool {
+	exists := s.exists(key)
+	delete(s.strs, key)
+	delete(s.hashes, key)
+	delete(s.zsets, key)
+	delete(s.lists, key)
+	delete(s.sets, key)
+	delete(s.expires, key)
+	if exists {
+		s.touch(key)
+	}
+	return exists
+}
+
+func (s *Server) touch(key string) {
+	s.versions[key]++
+}
+
+func (s *Server) exists(key string) bool {
+	_, a := s.strs[key]
+	_, b := s.hashes[key]
+	_, c := s.zsets[key]
+	_, d := s.lists[key]
+	_, e := s.sets[key]
+	return a || b || c || d || e
+}
+
+func formatScore(f float64) string {
+	if math.IsInf(f, 1) {
+		return "inf"
+	}
+	if math.IsInf(f, -1) {
+		return "-inf"
+	}
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+// parseRange 解析 -inf、+inf 和 (1 这样的不包含边界
+func parseRange(v string) (float64, bool, error) {
+	exclusive := strings.HasPrefix(v, "(")
+	v = strings.TrimPrefix(v, "(")
+	switch v {
+	case "-inf":
+		return math.Inf(-1), exclusive, nil
+	case "+inf", "inf":
+		return math.Inf(1), exclusive, nil
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	return f, exclusive, err
+}
+
+type zmember struct {
+	member string
+	score  float64
+}
+
+func (s *Server) sorted(key string) []zmember {
+	res := make([]zmember, 0, len(s.zsets[key]))
+	for m, sc := range s.zsets[key] {
+		res = append(res, zmember{m, sc})
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].score != res[j].score {
+			return res[i].score < res[j].score
+		}
+		return res[i].member < res[j].member
+	})
+	return res
+}
+
+// rangeIndex 按redis的规则处理负数下标
+func rangeIndex(start, stop, size int) (int, int) {
+	if start < 0 {
+		start += size
+	}
+	if stop < 0 {
+		stop += size
+	}
+	if start < 0 {
+		start = 0
+	}
+	if stop >= size {
+		stop = size - 1
+	}
+	return start, stop
+}
+
+func (s *Server) exec(args []string) interface{} {
+	cmd := strings.ToUpper(args[0])
+	for _, k := range keysOf(cmd, args) {
+		s.expire(k)
+	}
+	switch cmd {
+	case "PING":
+		return status("PONG")
+	case "GET":
+		v, ok := s.strs[args[1]]
+		if !ok {
+			return nil
+		}
+		return v
+	case "SET":
+		key := args[1]
+		var ttl time.Duration
+		nx := false
+		for i := 3; i < len(args); i++ {
+			switch strings.ToUpper(args[i]) {
+			case "EX", "PX":
+				if i+1 >= len(args) {
+					return errSyntax
+				}
+				n, err := strconv.ParseInt(args[i+1], 10, 64)
+				if err != nil {
+					return errSyntax
+				}
+				ttl = time.Duration(n) * time.Millisecond
+				if strings.ToUpper(args[i]) == "EX" {
+					ttl = time.Duration(n) * time.Second
+				}
+				i++
+			case "NX":
+				nx = true
+			}
+		}
+		if nx && s.exists(key) {
+			return nil
+		}
+		s.del(key)
+		s.strs[key] = args[2]
+		if ttl > 0 {
+			s.expires[key] = s.now().Add(ttl)
+		}
+		s.touch(key)
+		return status("OK")
+	case "DEL":
+		n := 0
+		for _, k := range args[1:] {
+			if s.del(k) {
+				n++
+			}
+		}
+		return n
+	case "EXISTS":
+		n := 0
+		for _, k := range args[1:] {
+			if s.exists(k) {
+				n++
+			}
+		}
+		return n
+	case "EXPIRE":
+		if !s.exists(args[1]) {
+			return 0
+		}
+		n, _ := strconv.ParseInt(args[2], 10, 64)
+		s.expires[args[1]] = s.now().Add(time.Duration(n) * time.Second)
+		s.touch(args[1])
+		return 1
+	case "TTL", "PTTL":
+		if !s.exists(args[1]) {
+			return -2
+		}
+		t, ok := s.expires[args[1]]
+		if !ok {
+			return -1
+		}
+		if cmd == "PTTL" {
+			return int64(t.Sub(s.now()) / time.Millisecond)
+		}
+		return int64(math.Ceil(t.Sub(s.now()).Seconds()))
+	case "HSET":
+		h, ok := s.hashes[args[1]]
+		if !ok {
+			h = make(map[string]string)
+			s.hashes[args[1]] = h
+		}
+		n := 0
+		for i := 2; i+1 < len(args); i += 2 {
+			if _, exists := h[args[i]]; !exists {
+				n++
+			}
+			h[args[i]] = args[i+1]
+		}
+		s.touch(args[1])
+		return n
+	case "INCR":
+		n, err := strconv.ParseInt(s.strs[args[1]], 10, 64)
+		if _, ok := s.strs[args[1]]; ok && err != nil {
+			return errors.New("ERR value is not an integer or out of range")
+		}
+		n++
+		s.strs[args[1]] = strconv.FormatInt(n, 10)
+		s.touch(args[1])
+		return n
+	case "SADD":
+		set, ok := s.sets[args[1]]
+		if !ok {
+			set = make(map[string]bool)
+			s.sets[args[1]] = set
+		}
+		n := 0
+		for _, m := range args[2:] {
+			if !set[m] {
+				set[m] = true
+				n++
+			}
+		}
+		s.touch(args[1])
+		return n
+	case "SREM":
+		n := 0
+		for _, m := range args[2:] {
+			if s.sets[args[1]][m] {
+				delete(s.sets[args[1]], m)
+				n++
+			}
+		}
+		if len(s.sets[args[1]]) == 0 {
+			delete(s.sets, args[1])
+		}
+		s.touch(args[1])
+		return n
+	case "SMEMBERS":
+		res := make([]interface{}, 0, len(s.sets[args[1]]))
+		for m := range s.sets[args[1]] {
+			res = append(res, m)
+		}
+		return res
+	case "HSETNX":
+		h, ok := s.hashes[args[1]]
+		if !ok {
+			h = make(map[string]string)
+			s.hashes[args[1]] = h
+		}
+		if _, exists := h[args[2]]; exists {
+			return 0
+		}
+		h[args[2]] = args[3]
+		s.touch(args[1])
+		return 1
+	case "RPUSH":
+		s.lists[args[1]] = append(s.lists[args[1]], args[2:]...)
+		s.touch(args[1])
+		return len(s.lists[args[1]])
+	case "LLEN":
+		return len(s.lists[args[1]])
+	case "LRANGE", "LTRIM":
+		l := s.lists[args[1]]
+		start, _ := strconv.Atoi(args[2])
+		stop, _ := strconv.Atoi(args[3])
+		start, stop = rangeIndex(start, stop, len(l))
+		res := make([]interface{}, 0)
+		kept := make([]string, 0)
+		for i := start; i <= stop; i++ {
+			res = append(res, l[i])
+			kept = append(kept, l[i])
+		}
+		if cmd == "LRANGE" {
+			return res
+		}
+		if len(kept) == 0 {
+			delete(s.lists, args[1])
+		} else {
+			s.lists[args[1]] = kept
+		}
+		s.touch(args[1])
+		return status("OK")
+	case "HGET":
+		v, ok := s.hashes[args[1]][args[2]]
+		if !ok {
+			return nil
+		}
+		return v
+	case "HGETALL":
+		res := make([]interface{}, 0)
+		for k, v := range s.hashes[args[1]] {
+			res = append(res, k, v)
+		}
+		return res
+	case "HDEL":
+		n := 0
+		for _, f := range args[2:] {
+			if _, ok := s.hashes[args[1]][f]; ok {
+				delete(s.hashes[args[1]], f)
+				n++
+			}
+		}
+		if len(s.hashes[args[1]]) == 0 {
+			delete(s.hashes, args[1])
+		}
+		s.touch(args[1])
+		return n
+	case "HINCRBY", "HINCRBYFLOAT":
+		h, ok := s.hashes[args[1]]
+		if !ok {
+			h = make(map[string]string)
+			s.hashes[args[1]] = h
+		}
+		s.touch(args[1])
+		if cmd == "HINCRBY" {
+			cur, _ := strconv.ParseInt(h[args[2]], 10, 64)
+			by, _ := strconv.ParseInt(args[3], 10, 64)
+			h[args[2]] = strconv.FormatInt(cur+by, 10)
+			return cur + by
+		}
+		cur, _ := strconv.ParseFloat(h[args[2]], 64)
+		by, _ := strconv.ParseFloat(args[3], 64)
+		h[args[2]] = formatScore(cur + by)
+		return h[args[2]]
+	case "ZADD":
+		z, ok := s.zsets[args[1]]
+		if !ok {
+			z = make(map[string]float64)
+			s.zsets[args[1]] = z
+		}
+		n := 0
+		for i := 2; i+1 < len(args); i += 2 {
+			f, err := strconv.ParseFloat(args[i], 64)
+			if err != nil {
+				return errors.New("ERR value is not a valid float")
+			}
+			if _, exists := z[args[i+1]]; !exists {
+				n++
+			}
+			z[args[i+1]] = f
+		}
+		s.touch(args[1])
+		return n
+	case "ZINCRBY":
+		z, ok := s.zsets[args[1]]
+		if !ok {
+			z = make(map[string]float64)
+			s.zsets[args[1]] = z
+		}
+		by, _ := strconv.ParseFloat(args[2], 64)
+		z[args[3]] += by
+		s.touch(args[1])
+		return formatScore(z[args[3]])
+	case "ZCARD":
+		return len(s.zsets[args[1]])
+	case "ZSCORE":
+		f, ok := s.zsets[args[1]][args[2]]
+		if !ok {
+			return nil
+		}
+		return formatScore(f)
+	case "ZMSCORE":
+		res := make([]interface{}, 0, len(args)-2)
+		for _, m := range args[2:] {
+			if f, ok := s.zsets[args[1]][m]; ok {
+				res = append(res, formatScore(f))
+			} else {
+				res = append(res, nil)
+			}
+		}
+		return res
+	case "ZREM":
+		n := 0
+		for _, m := range args[2:] {
+			if _, ok := s.zsets[args[1]][m]; ok {
+				delete(s.zsets[args[1]], m)
+				n++
+			}
+		}
+		if len(s.zsets[args[1]]) == 0 {
+			delete(s.zsets, args[1])
+		}
+		s.touch(args[1])
+		return n
+	case "ZRANGE", "ZREVRANGE":
+		members := s.sorted(args[1])
+		if cmd == "ZREVRANGE" {
+			for i, j := 0, len(members)-1; i < j; i, j = i+1, j-1 {
+				members[i], members[j] = members[j], members[i]
+			}
+		}
+		start, _ := strconv.Atoi(args[2])
+		stop, _ := strconv.Atoi(args[3])
+		withScores := len(args) > 4 && strings.ToUpper(args[4]) == "WITHSCORES"
+		start, stop = rangeIndex(start, stop, len(members))
+		res := make([]interface{}, 0)
+		for i := start; i <= stop; i++ {
+			res = append(res, members[i].member)
+			if withScores {
+				res = append(res, formatScore(members[i].score))
+			}
+		}
+		return res
+	case "ZREMRANGEBYSCORE":
+		min, minEx, err := parseRange(args[2])
+		if err != nil {
+			return errors.New("ERR min or max is not a float")
+		}
+		max, maxEx, err := parseRange(args[3])
+		if err != nil {
+			return errors.New("ERR min or max is not a float")
+		}
+		n := 0
+		for m, f := range s.zsets[args[1]] {
+			if (f > min || (!minEx && f == min)) && (f < max || (!maxEx && f == max)) {
+				delete(s.zsets[args[1]], m)
+				n++
+			}
+		}
+		if len(s.zsets[args[1]]) == 0 {
+			delete(s.zsets, args[1])
+		}
+		s.touch(args[1])
+		return n
+	case "ZPOPMIN":
+		count := 1
+		if len(args) > 2 {
+			count, _ = strconv.Atoi(args[2])
+		}
+		members := s.sorted(args[1])
+		res := make([]interface{}, 0)
+		for i := 0; i < count && i < len(members); i++ {
+			delete(s.zsets[args[1]], members[i].member)
+			res = append(res, members[i].member, formatScore(members[i].score))
+		}
+		if len(s.zsets[args[1]]) == 0 {
+			delete(s.zsets, args[1])
+		}
+		s.touch(args[1])
+		return res
+	}
+	return fmt.Errorf("ERR unknown command '%s'", args[0])
+}
+
+// keysOf 命令中的key，访问前先删除已经过期的
+func keysOf(cmd string, args []string) []string {
+	switch cmd {
+	case "PING":
+		return nil
+	case "DEL", "EXISTS":
+		return args[1:]
+	}
+	if len(args) > 1 {
+		return args[1:2]
+	}
+	return nil
+}
@@ -1,9 +1,18 @@
 package ext
 
-import "github.com/tiktoken-go/tokenizer"
+import (
+	"sync"
+
+	"github.com/tiktoken-go/tokenizer"
+)
 
 var TokenCodec tokenizer.Codec
 
+var (
+	tokenCodecs     = make(map[string]tokenizer.Codec)
+	tokenCodecsLock sync.Mutex
+)
+
 func init() {
 	var err error
 	TokenCodec, err = tokenizer.Get(tokenizer.Cl100kBase)
@@ -16,3 +25,27 @@ func TokenLen(s string) int {
 	toS, _, _ := TokenCodec.Encode(s)
 	return len(toS)
 }
+
+// TokenLenFor 使用指定的encoding计算，encoding为空或不支持时使用 cl100k_base
+func TokenLenFor(encoding, s string) int {
+	codec := getTokenCodec(encoding)
+	toS, _, _ := codec.Encode(s)
+	return len(toS)
+}
+
+func getTokenCodec(encoding string) tokenizer.Codec {
+	if len(encoding) == 0 || encoding == string(tokenizer.Cl100kBase) {
+		return TokenCodec
+	}
+	tokenCodecsLock.Lock()
+	defer tokenCodecsLock.Unlock()
+	if codec, exists := tokenCodecs[encoding]; exists {
+		return codec
+	}
+	codec, err := tokenizer.Get(tokenizer.Encoding(encoding))
+	if err != nil {
+		codec = TokenCodec
+	}
+	tokenCodecs[encoding] = codec
+	return codec
+}
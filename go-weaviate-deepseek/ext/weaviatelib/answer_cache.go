@@ -0,0 +1,116 @@
+package weaviatelib
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+
+	"github.com/tidwall/gjson"
+	"github.com/weaviate/weaviate-go-client/v4/weaviate/filters"
+	"github.com/weaviate/weaviate-go-client/v4/weaviate/graphql"
+)
+
+// AnswerCacheSchema 语义缓存集合的属性，向量为问题的向量
+const AnswerCacheSchema = `[
+	{"name": "question", "dataType": ["text"]},
+	{"name": "answer", "dataType": ["text"]},
+	{"name": "source_ids", "dataType": ["text[]"], "tokenization": "field"},
+	{"name": "db_source", "dataType": ["text"]},
+	{"name": "chat_model", "dataType": ["text"]},
+	{"name": "lang", "dataType": ["text"], "tokenization": "field"},
+	{"name": "generation", "dataType": ["int"]},
+	{"name": "expire_at", "dataType": ["int"]},
+	{"name": "created_at", "dataType": ["int"]}
+]`
+
+// 已经创建的缓存集合
+var answerCacheReady sync.Map
+
+// AnswerCacheClsName 每个集合对应一个缓存集合
+func AnswerCacheClsName(clsName string) string {
+	return clsName + "AnswerCache"
+}
+
+// EnsureAnswerCache 第一次使用时创建缓存集合
+func EnsureAnswerCache(clsName string) error {
+	cacheCls := AnswerCacheClsName(clsName)
+	if _, ok := answerCacheReady.Load(cacheCls); ok {
+		return nil
+	}
+	exists, err := GetClient().Schema().ClassExistenceChecker().
+		WithClassName(GetClsName(cacheCls)).
+		Do(context.Background())
+	if err != nil {
+		return err
+	}
+	if !exists {
+		err = DefineTextSchema(cacheCls, AnswerCacheSchema, "semantic answer cache of "+clsName)
+		if err != nil {
+			return err
+		}
+		L.Printf("answer cache created: %s", cacheCls)
+	}
+	answerCacheReady.Store(cacheCls, true)
+	return nil
+}
+
+// RemoveAnswerCache 删除集合时一起删除
+func RemoveAnswerCache(clsName string) error {
+	cacheCls := AnswerCacheClsName(clsName)
+	answerCacheReady.Delete(cacheCls)
+	return RemoveSchema(cacheCls)
+}
+
+// DeleteExpiredAnswers 删除过期的缓存
+func DeleteExpiredAnswers(clsName string, now int64) error {
+	_, err := GetClient().Batch().
+		ObjectsBatchDeleter().
+		WithClassName(GetClsName(AnswerCacheClsName(clsName))).
+		WithOutput("minimal").
+		WithWhere(filters.Where().
+			WithPath([]string{"expire_at"}).
+			WithOperator(filters.LessThanEqual).
+			WithValueInt(now)).
+		Do(context.Background())
+	return err
+}
+
+// FindCachedAnswer 最相似的一条没有过期的缓存，lang为空时不过滤，没有时返回nil
+func FindCachedAnswer(clsName string, vector []float32, maxDistance float32, lang string, generation, now int64) (map[string]interface{}, error) {
+	cacheCls := GetClsName(AnswerCacheClsName(clsName))
+	client := GetClient()
+	operands := []*filters.WhereBuilder{
+		filters.Where().WithPath([]string{"expire_at"}).WithOperator(filters.GreaterThan).WithValueInt(now),
+		filters.Where().WithPath([]string{"generation"}).WithOperator(filters.Equal).WithValueInt(generation),
+	}
+	if len(lang) > 0 {
+		operands = append(operands, filters.Where().WithPath([]string{"lang"}).WithOperator(filters.Equal).WithValueText(lang))
+	}
+	rsp, err := client.GraphQL().Get().
+		WithClassName(cacheCls).
+		WithFields(
+			graphql.Field{Name: "question"},
+			graphql.Field{Name: "answer"},
+			graphql.Field{Name: "source_ids"},
+			graphql.Field{Name: "db_source"},
+			graphql.Field{Name: "chat_model"},
+			graphql.Field{Name: "_additional", Fields: []graphql.Field{{Name: "id"}, {Name: "distance"}}},
+		).
+		WithNearVector(client.GraphQL().NearVectorArgBuilder().WithVector(vector).WithDistance(maxDistance)).
+		WithWhere(filters.Where().WithOperator(filters.And).WithOperands(operands)).
+		WithLimit(1).
+		Do(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	if len(rsp.Errors) > 0 {
+		return nil, graphQLErr(rsp.Errors)
+	}
+	b, _ := json.Marshal(rsp.Data["Get"])
+	row := gjson.GetBytes(b, cacheCls+".0")
+	if !row.Exists() {
+		return nil, nil
+	}
+	res, _ := row.Value().(map[string]interface{})
+	return res, nil
+}
@@ -5,7 +5,13 @@ import (
 	"encoding/json"
 	"fmt"
 	"go-weaviate-deepseek/ext"
+	"math"
+	"sort"
+	"strings"
 
+	"github.com/spf13/cast"
+
+	"github.com/go-openapi/strfmt"
 	"github.com/tidwall/gjson"
 	"github.com/weaviate/weaviate-go-client/v4/weaviate/data"
 	"github.com/weaviate/weaviate-go-client/v4/weaviate/data/replication"
@@ -53,6 +59,45 @@ func Create(clsName string, id string, attrs map[string]interface{}, vector []fl
 	return created, nil
 }
 
+// BatchObject 向量已经计算好的对象
+type BatchObject struct {
+	ID         string
+	Properties map[string]interface{}
+	Vector     []float32
+}
+
+// BatchCreate 使用batcher批量保存，相同ID的对象会被覆盖
+func BatchCreate(clsName string, objs []*BatchObject) error {
+	if len(objs) == 0 {
+		return nil
+	}
+	clsName = GetClsName(clsName)
+	client := GetClient()
+	objects := make([]*models.Object, 0, len(objs))
+	for _, o := range objs {
+		objects = append(objects, &models.Object{
+			Class:      clsName,
+			ID:         strfmt.UUID(o.ID),
+			Properties: o.Properties,
+			Vector:     o.Vector,
+		})
+	}
+
+	res, err := client.Batch().ObjectsBatcher().WithObjects(objects...).
+		WithConsistencyLevel(replication.ConsistencyLevel.ALL).
+		Do(context.Background())
+	if err != nil {
+		return err
+	}
+	for _, r := range res {
+		if r.Result != nil && r.Result.Errors != nil {
+			errB, _ := r.Result.Errors.MarshalBinary()
+			return fmt.Errorf("batch create err, id: %s, err: %s", r.ID, string(errB))
+		}
+	}
+	return nil
+}
+
 func BatchImport(jsonB []byte) {
 	client := GetClient()
 	objects := make([]*models.Object, 0)
@@ -112,11 +157,75 @@ func Scan(clsName string) (ext.M, error) {
 	return res, nil
 }
 
+// Each 用cursor遍历集合中的全部对象，每次batch个，fn返回错误时停止
+func Each(clsName string, batch int, fn func(objs []*models.Object) error) error {
+	clsName = GetClsName(clsName)
+	client := GetClient()
+	after := ""
+	for {
+		getter := client.Data().ObjectsGetter().
+			WithClassName(clsName).
+			WithLimit(batch)
+		if len(after) > 0 {
+			getter = getter.WithAfter(after)
+		}
+		objs, err := getter.Do(context.Background())
+		if err != nil {
+			return err
+		}
+		if len(objs) == 0 {
+			return nil
+		}
+		if err := fn(objs); err != nil {
+			return err
+		}
+		after = objs[len(objs)-1].ID.String()
+	}
+}
+
+// LangBoostWeight LangModeBoost 时同语言结果的distance减去该值
+const LangBoostWeight float32 = 0.1
+
+const (
+	LangModeFilter = "filter"
+	LangModeBoost  = "boost"
+)
+
+const (
+	// MediaTypeQuestion 假设问题对象，captions为原chunk，FieldChunkID 指向原chunk的id
+	MediaTypeQuestion = "question"
+	FieldChunkID      = "chunk_id"
+)
+
+// QueryOpts 集合没有lang属性时忽略Lang
+type QueryOpts struct {
+	Distance float32 // range: 0-2, 越小越匹配
+	Limit    int
+	Lang     string
+	LangMode string // LangModeFilter | LangModeBoost(default)
+	// Alpha 在0和1之间时使用混合检索（向量+BM25），越大越偏向向量，其他值只用向量检索。
+	// 混合检索按结果的向量计算distance，超过Distance的结果被丢弃
+	Alpha float32
+}
+
 // Query
 // opts[0]: distance, range: 0-2, 越小越匹配, https://weaviate.io/developers/weaviate/config-refs/distances#distance-fields-in-the-apis
 func Query(clsName string, phase string, opts ...float32) ([]byte, error) {
+	o := QueryOpts{Distance: 0.5}
+	if len(opts) > 0 {
+		o.Distance = opts[0]
+	}
+	return QueryWith(clsName, phase, o)
+}
+
+// QueryWith 返回 {"ClsName": [...]}，假设问题对象合并到原chunk，每个chunk只出现一次
+func QueryWith(clsName string, phase string, o QueryOpts) ([]byte, error) {
+	vectorizer := VectorizerFor(clsName)
 	clsName = GetClsName(clsName)
 	client := GetClient()
+	if o.Limit <= 0 {
+		o.Limit = 3
+	}
 
 	// field1 := graphql.Field{Name: "id"}
 	_additional := graphql.Field{
@@ -124,8 +233,14 @@ func Query(clsName string, phase string, opts ...float32) ([]byte, error) {
 			{Name: "id"},
 			{Name: "certainty"}, // only supported if distance==cosine
 			{Name: "distance"},  // always supported
+			{Name: "score"},     // hybrid
 		},
 	}
+	hybrid := o.Alpha > 0 && o.Alpha < 1
+	if hybrid {
+		// 混合检索不返回distance，取回向量在本地计算
+		_additional.Fields = append(_additional.Fields, graphql.Field{Name: "vector"})
+	}
 	fields := make([]graphql.Field, 0)
 	if clsName == ClsRubyGPT {
 		fields = []graphql.Field{
@@ -142,40 +257,57 @@ func Query(clsName string, phase string, opts ...float32) ([]byte, error) {
 			_additional,
 		}
 	}
+	if len(o.Lang) > 0 && !hasProperty(clsName, "lang") {
+		// 旧集合没有lang属性，不按语言过滤和加权
+		L.Printf("cls %s has no lang property, ignore lang: %s", clsName, o.Lang)
+		o.Lang = ""
+	}
+	if len(o.Lang) > 0 {
+		fields = append(fields, graphql.Field{Name: "lang"})
+	}
+	collapse := hasProperty(clsName, FieldChunkID)
+	if collapse {
+		fields = append(fields, graphql.Field{Name: FieldChunkID})
+	}
 
 	L.Println("calculate vector for:", phase)
-	textVector, err := VectorizerFunc(phase)
+	textVector, err := vectorizer(phase)
 	if err != nil {
 		return nil, err
 	}
 	L.Println("vector size:", len(textVector))
 
-	var distanceFloat float32 = 0.5
-	if len(opts) > 0 {
-		distanceFloat = opts[0]
-	}
-
-	L.Println("distanceFloat:", distanceFloat)
-	nearVector := client.GraphQL().NearVectorArgBuilder().
-		WithVector(textVector).WithDistance(distanceFloat)
-
-	// where := filters.Where().
-	// 	WithPath([]string{"title"}).
-	// 	WithOperator(filters.Equal).
-	// 	WithValueString("体育")
-	rsp, err := client.GraphQL().Get().
+	get := client.GraphQL().Get().
 		WithClassName(clsName).
 		WithFields(fields...).
-		// WithWhere(where).
-		WithNearVector(nearVector).
-		WithLimit(3).
-		// WithSort()
-		// WithOffset()
-		Do(context.Background())
+		WithLimit(o.Limit)
+	if hybrid {
+		L.Println("hybrid alpha:", o.Alpha)
+		get = get.WithHybrid(client.GraphQL().HybridArgumentBuilder().
+			WithQuery(phase).WithVector(textVector).WithAlpha(o.Alpha))
+	} else {
+		L.Println("distanceFloat:", o.Distance)
+		get = get.WithNearVector(client.GraphQL().NearVectorArgBuilder().
+			WithVector(textVector).WithDistance(o.Distance))
+	}
+	boost := len(o.Lang) > 0 && o.LangMode != LangModeFilter
+	if boost || collapse {
+		// 多取一些再按语言重新排序、合并同一个chunk
+		get = get.WithLimit(o.Limit * 3)
+	}
+	if len(o.Lang) > 0 && !boost {
+		get = get.WithWhere(filters.Where().
+			WithPath([]string{"lang"}).
+			WithOperator(filters.Equal).
+			WithValueString(o.Lang))
+	}
+	rsp, err := get.Do(context.Background())
 	if err != nil {
-		fmt.Printf("111, result: %s", err)
 		return nil, err
 	}
+	if len(rsp.Errors) > 0 {
+		return nil, graphQLErr(rsp.Errors)
+	}
 
 	// 应该只有一组key/value
 	res := make([]byte, 0)
@@ -184,9 +316,135 @@ func Query(clsName string, phase string, opts ...float32) ([]byte, error) {
 		size := len(gjson.ParseBytes(res).Get(clsName).Array())
 		L.Printf("db query, key: %s, size: %d", k, size)
 	}
+	if boost || collapse || hybrid {
+		rows := make([]map[string]interface{}, 0)
+		_ = json.Unmarshal([]byte(gjson.ParseBytes(res).Get(clsName).Raw), &rows)
+		if boost {
+			boostByLang(rows, o.Lang)
+		}
+		if collapse {
+			rows = collapseQuestions(rows)
+		}
+		if hybrid {
+			rows = cutoffByDistance(rows, textVector, o.Distance)
+		}
+		if len(rows) > o.Limit {
+			rows = rows[:o.Limit]
+		}
+		res, _ = json.Marshal(map[string]interface{}{clsName: rows})
+	}
 	return res, nil
 }
 
+// rowScore 越小越匹配，混合检索没有distance，score越大越匹配
+func rowScore(row map[string]interface{}) float32 {
+	b, _ := json.Marshal(row)
+	doc := gjson.ParseBytes(b)
+	if doc.Get("_additional.distance").Type == gjson.Null {
+		return -float32(doc.Get("_additional.score").Float())
+	}
+	return float32(doc.Get("_additional.distance").Float())
+}
+
+func boostByLang(rows []map[string]interface{}, lang string) {
+	score := func(row map[string]interface{}) float32 {
+		d := rowScore(row)
+		if row["lang"] == lang {
+			d -= LangBoostWeight
+		}
+		return d
+	}
+	sort.SliceStable(rows, func(i, j int) bool {
+		return score(rows[i]) < score(rows[j])
+	})
+}
+
+// cutoffByDistance 混合检索的结果按向量计算cosine distance，保持原来的排序，distance<=0时不过滤
+func cutoffByDistance(rows []map[string]interface{}, vector []float32, distance float32) []map[string]interface{} {
+	res := make([]map[string]interface{}, 0, len(rows))
+	for _, row := range rows {
+		additional, _ := row["_additional"].(map[string]interface{})
+		if additional == nil {
+			continue
+		}
+		raw, _ := additional["vector"].([]interface{})
+		delete(additional, "vector")
+		vec := make([]float64, 0, len(raw))
+		for _, v := range raw {
+			vec = append(vec, cast.ToFloat64(v))
+		}
+		if len(vec) != len(vector) {
+			continue
+		}
+		d := cosineDistance(vector, vec)
+		additional["distance"] = d
+		if distance > 0 && d > distance {
+			continue
+		}
+		res = append(res, row)
+	}
+	return res
+}
+
+func cosineDistance(a []float32, b []float64) float32 {
+	var dot, na, nb float64
+	for i := range a {
+		dot += float64(a[i]) * b[i]
+		na += float64(a[i]) * float64(a[i])
+		nb += b[i] * b[i]
+	}
+	if na == 0 || nb == 0 {
+		return 1
+	}
+	return float32(1 - dot/(math.Sqrt(na)*math.Sqrt(nb)))
+}
+
+// collapseQuestions 假设问题对象的id换成原chunk的id，同一个chunk只保留排序最靠前的一条，
+// 原chunk也在结果中时使用原chunk的属性
+func collapseQuestions(rows []map[string]interface{}) []map[string]interface{} {
+	res := make([]map[string]interface{}, 0, len(rows))
+	index := make(map[string]int)
+	for _, row := range rows {
+		additional, _ := row["_additional"].(map[string]interface{})
+		if additional == nil {
+			res = append(res, row)
+			continue
+		}
+		id := cast.ToString(additional["id"])
+		chunkID := cast.ToString(row[FieldChunkID])
+		isQuestion := len(chunkID) > 0
+		if isQuestion {
+			id = chunkID
+		}
+		delete(row, FieldChunkID)
+
+		i, exists := index[id]
+		if !exists {
+			if isQuestion {
+				additional["id"] = id
+			}
+			index[id] = len(res)
+			res = append(res, row)
+			continue
+		}
+		if !isQuestion {
+			// 保留排序靠前的匹配程度
+			row["_additional"] = res[i]["_additional"]
+			res[i] = row
+		}
+	}
+	return res
+}
+
+// graphQLErr 查询返回的GraphQL错误，例如集合不存在、缺少模块
+func graphQLErr(errs []*models.GraphQLError) error {
+	msgs := make([]string, 0, len(errs))
+	for _, e := range errs {
+		msgs = append(msgs, e.Message)
+	}
+	return fmt.Errorf("graphql err: %s", strings.Join(msgs, "; "))
+}
+
 func FindByID(clsName string, id string) (*models.Object, error) {
 	clsName = GetClsName(clsName)
 	client := GetClient()
@@ -0,0 +1,109 @@
+package weaviatelib
+
+import (
+	"testing"
+)
+
+func row(id, chunkID string, distance float64, captions string) map[string]interface{} {
+	r := map[string]interface{}{
+		"captions":    captions,
+		"_additional": map[string]interface{}{"id": id, "distance": distance},
+	}
+	if len(chunkID) > 0 {
+		r["media_type"] = MediaTypeQuestion
+		r[FieldChunkID] = chunkID
+	}
+	return r
+}
+
+func ids(rows []map[string]interface{}) []string {
+	res := make([]string, 0, len(rows))
+	for _, r := range rows {
+		res = append(res, r["_additional"].(map[string]interface{})["id"].(string))
+	}
+	return res
+}
+
+func TestCollapseQuestions(t *testing.T) {
+	cases := []struct {
+		desc         string
+		rows         []map[string]interface{}
+		wantIDs      []string
+		wantDistance []float64
+	}{
+		{
+			desc:         "no questions",
+			rows:         []map[string]interface{}{row("a", "", 0.1, "A"), row("b", "", 0.2, "B")},
+			wantIDs:      []string{"a", "b"},
+			wantDistance: []float64{0.1, 0.2},
+		},
+		{
+			desc:         "question maps to chunk id",
+			rows:         []map[string]interface{}{row("q1", "a", 0.05, "A"), row("b", "", 0.2, "B")},
+			wantIDs:      []string{"a", "b"},
+			wantDistance: []float64{0.05, 0.2},
+		},
+		{
+			desc: "questions of the same chunk collapse",
+			rows: []map[string]interface{}{
+				row("q1", "a", 0.05, "A"), row("q2", "a", 0.1, "A"), row("b", "", 0.2, "B"), row("a", "", 0.3, "A"),
+			},
+			wantIDs:      []string{"a", "b"},
+			wantDistance: []float64{0.05, 0.2},
+		},
+	}
+	for _, c := range cases {
+		got := collapseQuestions(c.rows)
+		gotIDs := ids(got)
+		if len(gotIDs) != len(c.wantIDs) {
+			t.Errorf("%s: ids = %v, want %v", c.desc, gotIDs, c.wantIDs)
+			continue
+		}
+		for i := range gotIDs {
+			d := got[i]["_additional"].(map[string]interface{})["distance"].(float64)
+			if gotIDs[i] != c.wantIDs[i] || d != c.wantDistance[i] {
+				t.Errorf("%s: row %d = %s/%v, want %s/%v", c.desc, i, gotIDs[i], d, c.wantIDs[i], c.wantDistance[i])
+			}
+			if _, exists := got[i][FieldChunkID]; exists {
+				t.Errorf("%s: row %d still has %s", c.desc, i, FieldChunkID)
+			}
+		}
+	}
+}
+
+func TestCutoffByDistance(t *testing.T) {
+	vec := []float32{1, 0}
+	hrow := func(id string, v ...interface{}) map[string]interface{} {
+		return map[string]interface{}{"_additional": map[string]interface{}{"id": id, "score": 0.5, "vector": v}}
+	}
+	cases := []struct {
+		desc     string
+		rows     []map[string]interface{}
+		distance float32
+		want     []string
+	}{
+		{desc: "keeps order and drops far rows", rows: []map[string]interface{}{hrow("a", 0.0, 1.0), hrow("b", 1.0, 0.0), hrow("c", 1.0, 0.1)}, distance: 0.5, want: []string{"b", "c"}},
+		{desc: "no cutoff", rows: []map[string]interface{}{hrow("a", 0.0, 1.0), hrow("b", 1.0, 0.0)}, distance: 0, want: []string{"a", "b"}},
+		{desc: "missing vector", rows: []map[string]interface{}{hrow("a")}, distance: 2, want: []string{}},
+	}
+	for _, c := range cases {
+		got := cutoffByDistance(c.rows, vec, c.distance)
+		gotIDs := ids(got)
+		if len(gotIDs) != len(c.want) {
+			t.Errorf("%s: ids = %v, want %v", c.desc, gotIDs, c.want)
+			continue
+		}
+		for i, r := range got {
+			additional := r["_additional"].(map[string]interface{})
+			if gotIDs[i] != c.want[i] {
+				t.Errorf("%s: ids = %v, want %v", c.desc, gotIDs, c.want)
+			}
+			if _, exists := additional["vector"]; exists {
+				t.Errorf("%s: vector should be removed", c.desc)
+			}
+			if _, ok := additional["distance"].(float32); !ok {
+				t.Errorf("%s: distance is not set", c.desc)
+			}
+		}
+	}
+}
@@ -0,0 +1,158 @@
+package weaviatelib
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"math"
+	"os"
+	"strings"
+
+	"github.com/weaviate/weaviate-go-client/v4/weaviate/graphql"
+)
+
+const (
+	ImageModuleNeural = "img2vec-neural"
+	ImageModuleNone   = "none"
+
+	// DefaultImageSchema create_image_db没有传schema时使用，image为base64
+	DefaultImageSchema = `[{"name":"image","dataType":["blob"]},{"name":"title","dataType":["string"]},{"name":"url","dataType":["string"]},{"name":"captions","dataType":["text"]},{"name":"media_type","dataType":["string"]}]`
+)
+
+// ImageVectorizer 图片向量化
+type ImageVectorizer interface {
+	// Module weaviate中的vectorizer模块，ImageModuleNone 表示在客户端计算向量
+	Module() string
+	// Vectorize 返回nil时由weaviate模块计算向量
+	Vectorize(b64 string) ([]float32, error)
+}
+
+// NeuralImageVectorizer 使用weaviate的img2vec-neural模块
+type NeuralImageVectorizer struct{}
+
+func (NeuralImageVectorizer) Module() string {
+	return ImageModuleNeural
+}
+
+func (NeuralImageVectorizer) Vectorize(b64 string) ([]float32, error) {
+	return nil, nil
+}
+
+// HashImageVectorizer 不依赖img2vec-neural模块，按图片内容哈希生成确定的向量，只能匹配相同的图片，用于测试
+type HashImageVectorizer struct {
+	Dim int
+}
+
+func (HashImageVectorizer) Module() string {
+	return ImageModuleNone
+}
+
+func (h HashImageVectorizer) Vectorize(b64 string) ([]float32, error) {
+	b, err := base64.StdEncoding.DecodeString(trimDataURL(b64))
+	if err != nil {
+		return nil, err
+	}
+	dim := h.Dim
+	if dim <= 0 {
+		dim = 64
+	}
+	res := make([]float32, dim)
+	seed := sha256.Sum256(b)
+	var norm float64
+	for i := 0; i < dim; i++ {
+		blk := sha256.Sum256(append(seed[:], byte(i), byte(i>>8)))
+		v := float64(binary.BigEndian.Uint32(blk[:4]))/math.MaxUint32*2 - 1
+		res[i] = float32(v)
+		norm += v * v
+	}
+	norm = math.Sqrt(norm)
+	for i := range res {
+		res[i] = float32(float64(res[i]) / norm)
+	}
+	return res, nil
+}
+
+// ImageVectorizerImpl 通过环境变量 IMAGE_VECTORIZER=hash 切换
+var ImageVectorizerImpl ImageVectorizer = NeuralImageVectorizer{}
+
+func init() {
+	if os.Getenv("IMAGE_VECTORIZER") == "hash" {
+		ImageVectorizerImpl = HashImageVectorizer{}
+	}
+}
+
+// CreateImage 保存图片对象，b64 存在 image 属性中
+func CreateImage(clsName string, id string, attrs map[string]interface{}, b64 string) error {
+	b64 = trimDataURL(b64)
+	vector, err := ImageVectorizerImpl.Vectorize(b64)
+	if err != nil {
+		return err
+	}
+	attrs["image"] = b64
+	_, err = Create(clsName, id, attrs, vector)
+	return err
+}
+
+// QueryImage 以图搜图
+// opts[0]: distance
+func QueryImage(clsName string, b64 string, opts ...float32) ([]byte, error) {
+	clsName = GetClsName(clsName)
+	client := GetClient()
+	b64 = trimDataURL(b64)
+
+	var distanceFloat float32 = 0.5
+	if len(opts) > 0 {
+		distanceFloat = opts[0]
+	}
+
+	fields := []graphql.Field{
+		{Name: "title"},
+		{Name: "url"},
+		{Name: "media_type"},
+		{Name: "captions"},
+		{Name: "_additional", Fields: []graphql.Field{
+			{Name: "id"},
+			{Name: "distance"},
+		}},
+	}
+	get := client.GraphQL().Get().
+		WithClassName(clsName).
+		WithFields(fields...).
+		WithLimit(3)
+
+	vector, err := ImageVectorizerImpl.Vectorize(b64)
+	if err != nil {
+		return nil, err
+	}
+	if vector == nil {
+		get = get.WithNearImage(client.GraphQL().NearImageArgBuilder().
+			WithImage(b64).WithDistance(distanceFloat))
+	} else {
+		get = get.WithNearVector(client.GraphQL().NearVectorArgBuilder().
+			WithVector(vector).WithDistance(distanceFloat))
+	}
+
+	rsp, err := get.Do(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	if len(rsp.Errors) > 0 {
+		return nil, graphQLErr(rsp.Errors)
+	}
+
+	res := make([]byte, 0)
+	for _, v := range rsp.Data {
+		res, _ = json.Marshal(v)
+	}
+	return res, nil
+}
+
+// trimDataURL 去掉 data:image/png;base64, 前缀
+func trimDataURL(b64 string) string {
+	if idx := strings.Index(b64, ","); idx >= 0 && strings.HasPrefix(b64, "data:") {
+		return b64[idx+1:]
+	}
+	return b64
+}
@@ -4,6 +4,8 @@ import (
 	"context"
 	"encoding/json"
 	"os"
+	"sync"
+	"time"
 
 	"github.com/sirupsen/logrus"
 	"github.com/weaviate/weaviate-go-client/v4/weaviate"
@@ -21,6 +23,17 @@ type VectorizerFuncDef func(string) ([]float32, error)
 
 var VectorizerFunc VectorizerFuncDef
 
+// ClsVectorizerFunc 按集合选择向量模型，没有设置时使用 VectorizerFunc
+var ClsVectorizerFunc func(clsName string) VectorizerFuncDef
+
+// VectorizerFor clsName 为调用 GetClsName 之前的名称
+func VectorizerFor(clsName string) VectorizerFuncDef {
+	if ClsVectorizerFunc != nil {
+		return ClsVectorizerFunc(clsName)
+	}
+	return VectorizerFunc
+}
+
 func init() {
 	if len(os.Getenv("HOST_IP")) == 0 {
 		WeaviateURI = "localhost:8070"
@@ -96,24 +109,33 @@ func DefineTextSchema(clsName, schemaStr, desp string) error {
 
 // DefineImageSchema
 // https://weaviate.io/blog/how-to-build-an-image-search-application-with-weaviate
+// schemaStr 为空时使用 DefaultImageSchema，vectorizer由 ImageVectorizerImpl 决定
 func DefineImageSchema(clsName, schemaStr, desp string) error {
 	clsName = GetClsName(clsName)
 	client := GetClient()
 	creator := client.Schema().ClassCreator()
+	if len(schemaStr) == 0 {
+		schemaStr = DefaultImageSchema
+	}
 	properties := make([]*models.Property, 0)
 	err := json.Unmarshal([]byte(schemaStr), &properties)
 	if err != nil {
 		return err
 	}
-	creator = creator.WithClass(&models.Class{
-		Class:       clsName,
-		Description: desp,
-		ModuleConfig: map[string]interface{}{
-			"img2vec-neural": map[string]interface{}{
+	module := ImageVectorizerImpl.Module()
+	var moduleConfig map[string]interface{}
+	if module == ImageModuleNeural {
+		moduleConfig = map[string]interface{}{
+			ImageModuleNeural: map[string]interface{}{
 				"imageFields": []string{"image"},
 			},
-		},
-		Vectorizer:      "img2vec-neural",
+		}
+	}
+	creator = creator.WithClass(&models.Class{
+		Class:           clsName,
+		Description:     desp,
+		ModuleConfig:    moduleConfig,
+		Vectorizer:      module,
 		VectorIndexType: "hnsw",
 		Properties:      properties,
 	})
@@ -149,6 +171,38 @@ func GetClient() *weaviate.Client {
 	return client
 }
 
+// classPropsTTL 自动schema会在导入时增加属性，缓存过期后重新获取
+const classPropsTTL = time.Minute
+
+type classProps struct {
+	names map[string]bool
+	at    time.Time
+}
+
+var classPropsCache sync.Map
+
+// hasProperty clsName 为调用 GetClsName 之后的名称，获取schema失败时当作没有该属性
+func hasProperty(clsName, prop string) bool {
+	if v, ok := classPropsCache.Load(clsName); ok {
+		if cp := v.(*classProps); time.Since(cp.at) < classPropsTTL {
+			return cp.names[prop]
+		}
+	}
+	cls, err := GetClient().Schema().ClassGetter().
+		WithClassName(clsName).
+		Do(context.Background())
+	if err != nil {
+		L.Warnf("get class schema err: %s, cls_name: %s", err, clsName)
+		return false
+	}
+	cp := &classProps{names: make(map[string]bool, len(cls.Properties)), at: time.Now()}
+	for _, p := range cls.Properties {
+		cp.names[p.Name] = true
+	}
+	classPropsCache.Store(clsName, cp)
+	return cp.names[prop]
+}
+
 // GetClsName weaviate 会自动把clsname首字母转换成大写，所以这里统一处理
 func GetClsName(clsName string) string {
 	// RubyChat需要特殊处理
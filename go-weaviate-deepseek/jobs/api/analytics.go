@@ -0,0 +1,58 @@
+package api
+
+import (
+	"strings"
+	"time"
+
+	"go-weaviate-deepseek/ext"
+	"go-weaviate-deepseek/services"
+
+	"github.com/spf13/cast"
+)
+
+// analyticsDoneCb 回答完成后记录问题、检索到的内容、模型和耗时，chunks为检索到的全部内容
+func analyticsDoneCb(stringOpts map[string]string, profile *services.ProjectProfile, retrieval *Retrieval,
+	query string, start time.Time, doneCb func(string, ext.M)) func(string, ext.M) {
+
+	chunkIDs := make([]string, 0, len(retrieval.Chunks))
+	distances := make([]float32, 0, len(retrieval.Chunks))
+	for _, c := range retrieval.Chunks {
+		chunkIDs = append(chunkIDs, c.Additional.ID)
+		distances = append(distances, c.Additional.Distance)
+	}
+	q := &services.QueryLog{
+		ClsName:       stringOpts["clsName"],
+		Question:      stringOpts["oriPrompt"],
+		Query:         query,
+		UserUUID:      stringOpts["userUUID"],
+		ChatUUID:      stringOpts["chatUUID"],
+		RetrievalMode: retrieval.Mode,
+		ChunkIDs:      chunkIDs,
+		Distances:     distances,
+		NoMatch:       services.IsWeakMatch(distances),
+	}
+	return func(url string, res ext.M) {
+		doneCb(url, res)
+
+		data, ok := res["data"].(ext.M)
+		if !ok || res["status"] != "ok" || !cast.ToBool(data["is_finished"]) {
+			return
+		}
+		q.ChatModel = cast.ToString(data["chat_model"])
+		q.LatencyMs = time.Since(start).Milliseconds()
+		q.Refused = isRefusal(profile, cast.ToString(data["reason"]), cast.ToString(data["content"]))
+		go func() {
+			if err := services.RecordQuery(q); err != nil {
+				ppml().Warnf("record query analytics err: %s", err)
+			}
+		}()
+	}
+}
+
+// isRefusal 没有内容时直接拒绝或转人工，或者大模型回复了配置的拒绝回答的内容
+func isRefusal(profile *services.ProjectProfile, reason, content string) bool {
+	if reason == "no_context" {
+		return profile.NoContextPolicy != services.NoContextGeneral
+	}
+	return len(profile.RefusalMessage) > 0 && strings.Contains(content, profile.RefusalMessage)
+}
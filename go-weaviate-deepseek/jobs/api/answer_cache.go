@@ -0,0 +1,90 @@
+package api
+
+import (
+	"encoding/json"
+	"time"
+
+	"go-weaviate-deepseek/ext"
+	"go-weaviate-deepseek/models"
+	"go-weaviate-deepseek/services"
+
+	"github.com/spf13/cast"
+)
+
+// answerCacheUsable 有历史但没有改写成独立问题时，问题可能依赖上下文，不使用缓存
+func answerCacheUsable(stringOpts map[string]string, hasHistory bool) bool {
+	if !services.AnswerCacheEnabled() || stringOpts["semanticCache"] == "false" {
+		return false
+	}
+	return !hasHistory || stringOpts["condenseQuery"] == "true"
+}
+
+// cachedChunks 缓存中保存的检索结果
+func cachedChunks(a *services.CachedAnswer) []*models.SourceChunk {
+	chunks := make([]*models.SourceChunk, 0)
+	if err := json.Unmarshal([]byte(a.DBSource), &chunks); err != nil {
+		ppml().Warnf("unmarshal cached db_source err: %s", err)
+	}
+	return chunks
+}
+
+// answerCacheDoneCb 正常完成的回答保存到语义缓存，拒绝回答和没有检索到内容的不保存，vector为空时不保存
+func answerCacheDoneCb(stringOpts map[string]string, profile *services.ProjectProfile, query, lang string,
+	vector []float32, chunks []*models.SourceChunk, doneCb func(string, ext.M)) func(string, ext.M) {
+
+	if len(vector) == 0 || len(chunks) == 0 {
+		return doneCb
+	}
+	clsName := stringOpts["clsName"]
+	return func(url string, res ext.M) {
+		doneCb(url, res)
+
+		data, ok := res["data"].(ext.M)
+		if !ok || res["status"] != "ok" || !cast.ToBool(data["is_finished"]) {
+			return
+		}
+		reason := cast.ToString(data["reason"])
+		content := cast.ToString(data["content"])
+		if reason != "stop" || len(content) == 0 || isRefusal(profile, reason, content) {
+			return
+		}
+		sourceIDs := make([]string, 0, len(chunks))
+		for _, c := range chunks {
+			sourceIDs = append(sourceIDs, c.Additional.ID)
+		}
+		b, _ := json.Marshal(chunks)
+		a := &services.CachedAnswer{
+			Question:  query,
+			Answer:    content,
+			SourceIDs: sourceIDs,
+			DBSource:  string(b),
+			ChatModel: cast.ToString(data["chat_model"]),
+			Lang:      lang,
+		}
+		go func() {
+			if err := services.SaveAnswer(clsName, vector, a); err != nil {
+				ppml().Warnf("save answer cache err, cls_name: %s, err: %s", clsName, err)
+			}
+		}()
+	}
+}
+
+// replyFromCache 分段推送缓存的回答，引用按缓存的检索结果重新解析
+func replyFromCache(stringOpts map[string]string, profile *services.ProjectProfile, a *services.CachedAnswer,
+	query string, start time.Time, msgCb func(res ext.M), doneCb func(string, ext.M)) {
+
+	chunks := cachedChunks(a)
+	dbSource := ext.M{
+		"cls_name":        stringOpts["clsName"],
+		"chunks":          chunks,
+		"retrieval_mode":  "cache",
+		"cached_question": a.Question,
+		"cache_distance":  a.Distance,
+	}
+	if query != stringOpts["oriPrompt"] {
+		dbSource["condensed_query"] = query
+	}
+	doneCb = analyticsDoneCb(stringOpts, profile, &Retrieval{Mode: "cache", Chunks: chunks}, query, start, doneCb)
+	content, citations := parseCitations(a.Answer, chunks)
+	replyWithoutLLM(stringOpts, content, "cache", a.ChatModel, dbSource, citations, msgCb, doneCb)
+}
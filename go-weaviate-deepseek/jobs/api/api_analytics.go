@@ -0,0 +1,58 @@
+package api
+
+import (
+	"net/http"
+
+	"go-weaviate-deepseek/ext"
+	"go-weaviate-deepseek/services"
+
+	"github.com/gin-gonic/gin"
+	"github.com/spf13/cast"
+)
+
+func apiAnalytics(r *gin.Engine) {
+	// achat的问答记录，按时间倒序, /analytics/queries?pid=xx&count=50&before=<上一页最后一条的id>
+	r.GET("/analytics/queries", func(ctx *gin.Context) {
+		qs, err := services.ListQueries(ctx.Query("pid"), ctx.Query("before"), queryLimit(ctx, "count", 50, 500))
+		if ok := checkErr(err, ctx); !ok {
+			return
+		}
+		ctx.JSON(http.StatusOK, ext.M{"status": "ok", "data": qs})
+	})
+
+	// 次数最多的问题, /analytics/top_queries?pid=xx&limit=20
+	r.GET("/analytics/top_queries", func(ctx *gin.Context) {
+		qs, err := services.TopQueries(ctx.Query("pid"), queryLimit(ctx, "limit", 20, 1000))
+		if ok := checkErr(err, ctx); !ok {
+			return
+		}
+		ctx.JSON(http.StatusOK, ext.M{"status": "ok", "data": qs})
+	})
+
+	// 没有找到好的匹配或者拒绝回答的问题, /analytics/no_match_queries?pid=xx&limit=20
+	r.GET("/analytics/no_match_queries", func(ctx *gin.Context) {
+		qs, err := services.NoMatchQueries(ctx.Query("pid"), queryLimit(ctx, "limit", 20, 1000))
+		if ok := checkErr(err, ctx); !ok {
+			return
+		}
+		ctx.JSON(http.StatusOK, ext.M{"status": "ok", "data": qs})
+	})
+
+	// 从未被检索到的内容，需要遍历整个集合, /analytics/unretrieved_chunks?pid=xx&limit=100
+	r.GET("/analytics/unretrieved_chunks", func(ctx *gin.Context) {
+		chunks, err := services.UnretrievedChunks(ctx.Query("pid"), int(queryLimit(ctx, "limit", 100, 10000)))
+		if ok := checkErr(err, ctx); !ok {
+			return
+		}
+		ctx.JSON(http.StatusOK, ext.M{"status": "ok", "data": chunks})
+	})
+}
+
+// queryLimit 超出范围时使用默认值
+func queryLimit(ctx *gin.Context, key string, def, max int64) int64 {
+	n := cast.ToInt64(ctx.DefaultQuery(key, cast.ToString(def)))
+	if n < 1 || n > max {
+		return def
+	}
+	return n
+}
@@ -0,0 +1,53 @@
+package api
+
+import (
+	"net/http"
+
+	"go-weaviate-deepseek/ext"
+	"go-weaviate-deepseek/services"
+
+	"github.com/gin-gonic/gin"
+	"github.com/spf13/cast"
+	"github.com/tidwall/gjson"
+)
+
+func apiConversation(r *gin.Engine) {
+	// 用户的对话列表，按更新时间倒序, /conversations?user_uuid=xx&page=1&per=20
+	r.GET("/conversations", func(ctx *gin.Context) {
+		page := cast.ToInt64(ctx.DefaultQuery("page", "1"))
+		if page < 1 {
+			page = 1
+		}
+		per := cast.ToInt64(ctx.DefaultQuery("per", "20"))
+		if per < 1 || per > 100 {
+			per = 20
+		}
+		convs, total, err := services.ListConversations(ctx.Query("user_uuid"), (page-1)*per, per)
+		if ok := checkErr(err, ctx); !ok {
+			return
+		}
+		ctx.JSON(http.StatusOK, ext.M{"status": "ok", "data": ext.M{
+			"total":         total,
+			"conversations": convs,
+		}})
+	})
+
+	// 对话的全部消息，id为第一条消息的chat_uuid，只能获取自己的对话, /conversation?id=xx&user_uuid=xx
+	r.GET("/conversation", func(ctx *gin.Context) {
+		c, err := services.GetConversation(ctx.Query("id"), ctx.Query("user_uuid"), true)
+		if ok := checkErr(err, ctx); !ok {
+			return
+		}
+		ctx.JSON(http.StatusOK, ext.M{"status": "ok", "data": c})
+	})
+
+	// 只能删除自己的对话, {"id": "xx", "user_uuid": "xx"}
+	r.POST("/conversation/delete", func(ctx *gin.Context) {
+		doc := gjson.Parse(readBody(ctx))
+		err := services.DeleteConversation(doc.Get("id").String(), doc.Get("user_uuid").String())
+		if ok := checkErr(err, ctx); !ok {
+			return
+		}
+		ctx.JSON(http.StatusOK, ext.M{"status": "ok"})
+	})
+}
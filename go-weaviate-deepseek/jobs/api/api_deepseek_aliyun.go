@@ -1,11 +1,16 @@
 package api
 
 import (
+	"context"
 	"crypto/tls"
 	"encoding/json"
 	"errors"
 	"go-weaviate-deepseek/conf"
 	"go-weaviate-deepseek/ext"
+	"go-weaviate-deepseek/ext/embedder"
+	"go-weaviate-deepseek/ext/llmhttp"
+	"go-weaviate-deepseek/ext/weaviatelib"
+	"go-weaviate-deepseek/services"
 	"net/http"
 	"time"
 
@@ -21,47 +26,6 @@ const (
 	redisChatSSETicketPrefix = "chat:ticket:"
 )
 
-/*
-目前我们的账号tokens限额是
-gpt-3.5-turbo-16k	180,000
-gpt-4	10,000
-
-Price:
-
-	INPUT					OUTPUT
-
-gpt-3.5-turbo-16k $0.003 / 1K tokens	$0.004 / 1K tokens
-*/
-var chatModelConfigs = map[string]map[string]interface{}{
-	"gpt-3.5-turbo-16k": {
-		"legal_name": openai.GPT3Dot5Turbo16K,
-		"max_tokens": 6000,
-		"chunk_size": ext.M{
-			"VAR":  6000,
-			"URL":  6000,
-			"FILE": 6000,
-		},
-	},
-	"gpt-4": {
-		"legal_name": openai.GPT4,
-		"max_tokens": 1500,
-		"chunk_size": ext.M{
-			"VAR":  2000,
-			"URL":  2000,
-			"FILE": 2000,
-		},
-	},
-	conf.AliDeepSeekModelName: {
-		"legal_name": conf.AliDeepSeekModelName,
-		"max_tokens": 1500,
-		"chunk_size": ext.M{
-			"VAR":  2000,
-			"URL":  2000,
-			"FILE": 2000,
-		},
-	},
-}
-
 func lada() *logrus.Entry {
 	return ext.LF("deepseek_aliyun")
 }
@@ -75,11 +39,18 @@ func apiDeepSeekAliyun(r *gin.Engine) {
 		pm := doc.Get("prompt").String()
 		userUUID := doc.Get("user_uuid").String()
 		hasContext := doc.Get("has_context").Bool()
+		// 为空时使用配置中的 default_chat_model
+		chatModel := doc.Get("chat_model").String()
 
-		lada().Printf("chat_now, prompt: %s, user_uuid: %s", pm, userUUID)
+		lada().Printf("chat_now, prompt: %s, user_uuid: %s, chat_model: %s", pm, userUUID, chatModel)
 
+		apiKey := ctx.GetHeader(conf.APIKeyHeader)
 		jobUUID := ext.GenUUID()
-		rsp, err := ChatNow(jobUUID, pm, userUUID, hasContext)
+		err := services.CheckQuota(userUUID, apiKey)
+		if ok := checkErr(err, ctx); !ok {
+			return
+		}
+		rsp, usedModel, err := ChatNow(ctx.Request.Context(), jobUUID, pm, userUUID, chatModel, hasContext)
 		if err != nil {
 			lada().Printf("err, /deep_seek_ali/chat_now: %v", err)
 			ctx.JSON(http.StatusOK, ext.M{
@@ -91,6 +62,15 @@ func apiDeepSeekAliyun(r *gin.Engine) {
 		}
 		usageB, _ := json.Marshal(rsp.Usage)
 		lada().Printf("token usage: %s", string(usageB))
+		// fallback时按实际使用的模型计费
+		services.RecordUsage(&services.UsageRecord{
+			Kind:             services.UsageKindChat,
+			UserUUID:         userUUID,
+			APIKey:           apiKey,
+			Model:            usedModel.Name,
+			PromptTokens:     rsp.Usage.PromptTokens,
+			CompletionTokens: rsp.Usage.CompletionTokens,
+		})
 		ctx.JSON(http.StatusOK, ext.M{"status": "ok", "data": ext.M{
 			"job_uuid": jobUUID,
 			"prompt":   pm,
@@ -123,17 +103,30 @@ func apiDeepSeekAliyun(r *gin.Engine) {
 			"rsp":    rsp,
 		}})
 	})
+
+	// 大模型接口的熔断状态, closed | open | half-open
+	r.GET("/deep_seek_ali/llm_status", func(ctx *gin.Context) {
+		ctx.JSON(http.StatusOK, ext.M{"status": "ok", "data": ext.M{
+			"breakers": llmhttp.Default.BreakerStates(),
+		}})
+	})
+
+	// 向量缓存命中统计
+	r.GET("/deep_seek_ali/embedding_cache", func(ctx *gin.Context) {
+		ctx.JSON(http.StatusOK, ext.M{"status": "ok", "data": embedder.GetCacheStats()})
+	})
 }
 
-// ChatNow wait until getting all response from openai
-func ChatNow(jobUUID, prompt, userUUID string, hasContext bool) (*openai.ChatCompletionResponse, error) {
+// ChatNow wait until getting all response from the model's provider, chatModel为空时使用默认模型，
+// 同时返回fallback之后实际使用的模型
+func ChatNow(ctx context.Context, jobUUID, prompt, userUUID, chatModel string, hasContext bool) (*openai.ChatCompletionResponse, *conf.ModelConf, error) {
 	lada().Printf("chat now, handling, prompt: %s, hasContext: %v", prompt, hasContext)
 	messageRows := make([]openai.ChatCompletionMessage, 0)
 	if hasContext {
 		err := json.Unmarshal([]byte(prompt), &messageRows)
 		if err != nil {
 			lada().Warn("unmarshal prompt json err:", err)
-			return nil, err
+			return nil, nil, err
 		}
 	} else {
 		messageRows = []openai.ChatCompletionMessage{
@@ -144,35 +137,43 @@ func ChatNow(jobUUID, prompt, userUUID string, hasContext bool) (*openai.ChatCom
 		}
 	}
 
-	requestBody := map[string]interface{}{
-		"model":       conf.AliDeepSeekModelName,
-		"max_tokens":  2000,
-		"temperature": 0.7,
-		"top_p":       1,
-		// "frequency_penalty": 0,
-		"presence_penalty": 0,
-		"messages":         messageRows,
+	var resp *resty.Response
+	usedModel, err := withFallback(chatModel, func(m *conf.ModelConf, cp *ChatProvider) error {
+		requestBody := map[string]interface{}{
+			"model":       m.LegalName,
+			"max_tokens":  m.MaxTokens,
+			"temperature": 0.7,
+			"top_p":       1,
+			// "frequency_penalty": 0,
+			"presence_penalty": 0,
+			"messages":         messageRows,
+		}
+		var err error
+		resp, err = cp.Complete(ctx, requestBody)
+		return err
+	})
+	if err != nil {
+		lada().Errorf("Error /chat/completions request: %v", err)
+		return nil, nil, err
 	}
 
-	resp, err := resty.SetTimeout(time.Duration(3*time.Minute)).R().
-		SetHeader("Authorization", "Bearer "+conf.AliDeepSeekAPIKey).
-		SetHeader("Content-Type", "application/json").
-		SetBody(requestBody).
-		Post(conf.AliDeepSeeKBaseUrl + "/chat/completions")
+	var d openai.ChatCompletionResponse
+	json.Unmarshal(resp.Body(), &d)
+	return &d, usedModel, nil
+}
 
+// ChatText 单轮对话，只返回回答内容，用于services中的内部调用
+func ChatText(ctx context.Context, prompt string) (string, error) {
+	rsp, m, err := ChatNow(ctx, ext.GenUUID(), prompt, "", "", false)
 	if err != nil {
-		lada().Fatalf("Error /chat/completions request: %v", err)
+		return "", err
 	}
-
-	bodyDoc := gjson.ParseBytes(resp.Body())
-	if bodyDoc.Get("error").Exists() {
-		lada().Errorf("Error /chat/completions request: %v", bodyDoc.Get("error").String())
-		return nil, errors.New(bodyDoc.Get("error").String())
+	// 按context中的调用方统计，降级时按实际使用的模型计费
+	services.RecordCallerUsage(ctx, "", m.Name, rsp.Usage.PromptTokens, rsp.Usage.CompletionTokens)
+	if len(rsp.Choices) == 0 {
+		return "", errors.New("empty chat choices")
 	}
-
-	var d openai.ChatCompletionResponse
-	json.Unmarshal(resp.Body(), &d)
-	return &d, nil
+	return rsp.Choices[0].Message.Content, nil
 }
 
 func Embedding(prompt string) (*openai.EmbeddingResponse, error) {
@@ -184,21 +185,11 @@ func Embedding(prompt string) (*openai.EmbeddingResponse, error) {
 		"encoding_format": "float",
 	}
 
-	resp, err := resty.SetTimeout(time.Duration(3*time.Minute)).R().
-		SetHeader("Authorization", "Bearer "+conf.AliDeepSeekAPIKey).
-		SetHeader("Content-Type", "application/json").
-		SetBody(requestBody).
-		Post(conf.AliDeepSeeKBaseUrl + "/embeddings")
-
+	resp, err := llmhttp.Default.Post(context.Background(), conf.AliDeepSeeKBaseUrl+"/embeddings",
+		conf.AliDeepSeekAPIKey, requestBody)
 	if err != nil {
-		lada().Fatalf("Error embeddings request: %v", err)
-	}
-
-	bodyDoc := gjson.ParseBytes(resp.Body())
-	// lada().Info("Embedding: ", bodyDoc)
-	if bodyDoc.Get("error").Exists() {
-		lada().Errorf("Error embeddings request: %v", bodyDoc.Get("error").String())
-		return nil, errors.New(bodyDoc.Get("error").String())
+		lada().Errorf("Error embeddings request: %v", err)
+		return nil, err
 	}
 
 	var d openai.EmbeddingResponse
@@ -206,16 +197,24 @@ func Embedding(prompt string) (*openai.EmbeddingResponse, error) {
 	return &d, nil
 }
 
-// Vectorizer 用于直接计算
+// Vectorizer 用于直接计算，使用配置中默认的embedder
 func Vectorizer(prompt string) ([]float32, error) {
-	rsp, err := Embedding(prompt)
+	e, err := embedder.Default()
 	if err != nil {
 		return []float32{}, err
 	}
-	if len(rsp.Data) > 0 {
-		return rsp.Data[0].Embedding, nil
+	return e.Embed(prompt)
+}
+
+// ClsVectorizer 使用集合配置的embedder
+func ClsVectorizer(clsName string) weaviatelib.VectorizerFuncDef {
+	return func(prompt string) ([]float32, error) {
+		e, err := embedder.ForCollection(clsName)
+		if err != nil {
+			return []float32{}, err
+		}
+		return e.Embed(prompt)
 	}
-	return []float32{}, errors.New("empty embedding")
 }
 
 func doNotify(url string, jobRes ext.M) (*resty.Response, error) {
@@ -0,0 +1,71 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"go-weaviate-deepseek/ext"
+	"go-weaviate-deepseek/services"
+
+	"github.com/gin-gonic/gin"
+	"github.com/spf13/cast"
+	"github.com/tidwall/gjson"
+)
+
+func apiFeedback(r *gin.Engine) {
+	// 评价回答, {"chat_uuid": "xx", "rating": "up|down", "comment": "", "user_uuid": ""}
+	r.POST("/feedback", func(ctx *gin.Context) {
+		doc := gjson.Parse(readBody(ctx))
+		f, err := services.SubmitFeedback(doc.Get("chat_uuid").String(), doc.Get("rating").String(),
+			doc.Get("comment").String(), doc.Get("user_uuid").String())
+		if ok := checkErr(err, ctx); !ok {
+			return
+		}
+		ctx.JSON(http.StatusOK, ext.M{"status": "ok", "data": f})
+	})
+
+	// 评价列表，按评价时间倒序, /feedbacks?pid=xx&rating=down&page=1&per=20，pid为空时返回所有集合
+	r.GET("/feedbacks", func(ctx *gin.Context) {
+		page := cast.ToInt64(ctx.DefaultQuery("page", "1"))
+		if page < 1 {
+			page = 1
+		}
+		per := queryLimit(ctx, "per", 20, 100)
+		items, err := services.ListFeedback(ctx.Query("pid"), ctx.Query("rating"), (page-1)*per, per)
+		if ok := checkErr(err, ctx); !ok {
+			return
+		}
+		ctx.JSON(http.StatusOK, ext.M{"status": "ok", "data": items})
+	})
+
+	// 导出为jsonl, /feedback/export?pid=xx&rating=down
+	r.GET("/feedback/export", func(ctx *gin.Context) {
+		pid := ctx.Query("pid")
+		if len(pid) == 0 {
+			pid = "all"
+		}
+		ctx.Header("Content-Type", "application/x-ndjson")
+		ctx.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="feedback-%s-%s.jsonl"`,
+			pid, time.Now().Format("20060102")))
+		err := services.ExportFeedback(ctx.Query("pid"), ctx.Query("rating"), ctx.Writer)
+		if err != nil {
+			l().Warnf("export feedback err: %s", err)
+		}
+	})
+}
+
+// wsFeedback websocket的 feedback 命令
+func wsFeedback(data map[string]string) ext.M {
+	f, err := services.SubmitFeedback(data["chat_uuid"], data["rating"], data["comment"], data["user_uuid"])
+	if err != nil {
+		return ext.M{"cmd": "error", "data": err.Error()}
+	}
+	return ext.M{
+		"cmd": "feedback-back",
+		"data": ext.M{
+			"chat_uuid": f.ChatUUID,
+			"rating":    f.Rating,
+		},
+	}
+}
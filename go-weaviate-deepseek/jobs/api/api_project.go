@@ -0,0 +1,114 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"go-weaviate-deepseek/conf"
+	"go-weaviate-deepseek/ext"
+	"go-weaviate-deepseek/services"
+
+	"github.com/gin-gonic/gin"
+	"github.com/spf13/cast"
+	"github.com/tidwall/gjson"
+)
+
+func apiProject(r *gin.Engine) {
+	// 项目的助手配置，没有保存过时返回默认配置, /project/profile?pid=xx
+	r.GET("/project/profile", func(ctx *gin.Context) {
+		p, err := services.GetProfile(ctx.Query("pid"))
+		if ok := checkErr(err, ctx); !ok {
+			return
+		}
+		ctx.JSON(http.StatusOK, ext.M{"status": "ok", "data": p})
+	})
+
+	r.GET("/project/profiles", func(ctx *gin.Context) {
+		ps, err := services.ListProfiles()
+		if ok := checkErr(err, ctx); !ok {
+			return
+		}
+		ctx.JSON(http.StatusOK, ext.M{"status": "ok", "data": ps})
+	})
+
+	// 创建或更新，只修改传入的字段, {"pid": "xx", "persona_name": "小助手", "top_k": 5, ...}
+	r.POST("/project/profile", func(ctx *gin.Context) {
+		body := readBody(ctx)
+		p, err := services.GetProfile(gjson.Get(body, "pid").String())
+		if ok := checkErr(err, ctx); !ok {
+			return
+		}
+		err = json.Unmarshal([]byte(body), p)
+		if ok := checkErr(err, ctx); !ok {
+			return
+		}
+		err = validateProfile(p)
+		if ok := checkErr(err, ctx); !ok {
+			return
+		}
+		err = services.SaveProfile(p)
+		if ok := checkErr(err, ctx); !ok {
+			return
+		}
+		ctx.JSON(http.StatusOK, ext.M{"status": "ok", "data": p})
+	})
+
+	// 检索不到相关内容的问题，按时间倒序, /project/unanswered?pid=xx&count=50&before=<上一页最后一条的id>
+	r.GET("/project/unanswered", func(ctx *gin.Context) {
+		count := cast.ToInt64(ctx.DefaultQuery("count", "50"))
+		if count < 1 || count > 500 {
+			count = 50
+		}
+		qs, err := services.ListUnanswered(ctx.Query("pid"), ctx.Query("before"), count)
+		if ok := checkErr(err, ctx); !ok {
+			return
+		}
+		ctx.JSON(http.StatusOK, ext.M{"status": "ok", "data": qs})
+	})
+
+	// {"pid": "xx"}，删除后恢复默认配置
+	r.POST("/project/profile/delete", func(ctx *gin.Context) {
+		doc := gjson.Parse(readBody(ctx))
+		err := services.DeleteProfile(doc.Get("pid").String())
+		if ok := checkErr(err, ctx); !ok {
+			return
+		}
+		ctx.JSON(http.StatusOK, ext.M{"status": "ok"})
+	})
+}
+
+func validateProfile(p *services.ProjectProfile) error {
+	if len(p.PID) == 0 {
+		return errors.New("pid is empty")
+	}
+	if p.HybridAlpha < 0 || p.HybridAlpha > 1 {
+		return errors.New("hybrid_alpha should be between 0 and 1")
+	}
+	if p.TopK < 1 || p.TopK > services.MaxTopK {
+		return fmt.Errorf("top_k should be between 1 and %d", services.MaxTopK)
+	}
+	// cosine distance 的范围是 [0, 2]
+	if p.Distance <= 0 || p.Distance > 2 {
+		return errors.New("distance should be greater than 0 and at most 2")
+	}
+	switch p.RetrievalMode {
+	case "", RetrievalModeSimple, RetrievalModeMultiQuery, RetrievalModeHyDE:
+	default:
+		return fmt.Errorf("unknown retrieval_mode: %s", p.RetrievalMode)
+	}
+	switch p.NoContextPolicy {
+	case services.NoContextRefuse, services.NoContextGeneral:
+	case services.NoContextEscalate:
+		if len(p.EscalationWebhook) == 0 {
+			return errors.New("escalation_webhook is required when no_context_policy is escalate")
+		}
+	default:
+		return fmt.Errorf("unknown no_context_policy: %s", p.NoContextPolicy)
+	}
+	if len(p.ChatModel) > 0 && conf.Settings.GetModel(p.ChatModel) == nil {
+		return fmt.Errorf("unknown chat_model: %s", p.ChatModel)
+	}
+	return nil
+}
@@ -0,0 +1,34 @@
+package api
+
+import (
+	"testing"
+
+	"go-weaviate-deepseek/services"
+)
+
+func TestValidateProfile(t *testing.T) {
+	cases := []struct {
+		desc    string
+		edit    func(p *services.ProjectProfile)
+		wantErr bool
+	}{
+		{desc: "default", edit: func(p *services.ProjectProfile) {}},
+		{desc: "max top_k", edit: func(p *services.ProjectProfile) { p.TopK = services.MaxTopK }},
+		{desc: "top_k too large", edit: func(p *services.ProjectProfile) { p.TopK = services.MaxTopK + 1 }, wantErr: true},
+		{desc: "zero top_k", edit: func(p *services.ProjectProfile) { p.TopK = 0 }, wantErr: true},
+		{desc: "negative top_k", edit: func(p *services.ProjectProfile) { p.TopK = -1 }, wantErr: true},
+		{desc: "max distance", edit: func(p *services.ProjectProfile) { p.Distance = 2 }},
+		{desc: "distance too large", edit: func(p *services.ProjectProfile) { p.Distance = 2.5 }, wantErr: true},
+		{desc: "zero distance", edit: func(p *services.ProjectProfile) { p.Distance = 0 }, wantErr: true},
+		{desc: "negative distance", edit: func(p *services.ProjectProfile) { p.Distance = -0.1 }, wantErr: true},
+		{desc: "hybrid_alpha too large", edit: func(p *services.ProjectProfile) { p.HybridAlpha = 1.5 }, wantErr: true},
+		{desc: "escalate without webhook", edit: func(p *services.ProjectProfile) { p.NoContextPolicy = services.NoContextEscalate }, wantErr: true},
+	}
+	for _, c := range cases {
+		p := services.DefaultProfile("pid")
+		c.edit(p)
+		if err := validateProfile(p); (err != nil) != c.wantErr {
+			t.Errorf("%s: err = %v", c.desc, err)
+		}
+	}
+}
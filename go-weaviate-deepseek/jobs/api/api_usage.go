@@ -0,0 +1,100 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"go-weaviate-deepseek/conf"
+	"go-weaviate-deepseek/ext"
+	"go-weaviate-deepseek/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+func apiUsage(r *gin.Engine) {
+	// 查询用量，可以同时传多个维度
+	// /usage?user_uuid=xx&api_key=xx&cls_name=xx&model=deepseek-v3&period=day|month&date=2025-03-06
+	r.GET("/usage", func(ctx *gin.Context) {
+		date := time.Now()
+		if len(ctx.Query("date")) > 0 {
+			var err error
+			date, err = time.ParseInLocation("2006-01-02", ctx.Query("date"), time.Local)
+			if ok := checkErr(err, ctx); !ok {
+				return
+			}
+		}
+		res := ext.M{}
+		for dim, param := range map[string]string{
+			services.UsageDimUser:   "user_uuid",
+			services.UsageDimAPIKey: "api_key",
+			services.UsageDimCls:    "cls_name",
+			services.UsageDimModel:  "model",
+		} {
+			id := ctx.Query(param)
+			if len(id) == 0 {
+				continue
+			}
+			u, err := services.GetUsage(dim, id, ctx.Query("period"), date)
+			if ok := checkErr(err, ctx); !ok {
+				return
+			}
+			res[param] = u
+		}
+		ctx.JSON(http.StatusOK, ext.M{"status": "ok", "data": res})
+	})
+
+	// 查询用户或api key的限额, /usage/quota?user_uuid=xx 或 /usage/quota?api_key=xx
+	r.GET("/usage/quota", func(ctx *gin.Context) {
+		dim, id := quotaTarget(ctx.Query("user_uuid"), ctx.Query("api_key"))
+		q, err := services.GetQuota(dim, id)
+		if ok := checkErr(err, ctx); !ok {
+			return
+		}
+		ctx.JSON(http.StatusOK, ext.M{"status": "ok", "data": q})
+	})
+
+	// 设置单个用户或api key的限额，0为不限制
+	// {
+	// 	"user_uuid": "xx",
+	// 	"daily_tokens": 100000,
+	// 	"monthly_tokens": 2000000,
+	// 	"daily_cost": 0,
+	// 	"monthly_cost": 50
+	// }
+	r.POST("/usage/quota", func(ctx *gin.Context) {
+		d := struct {
+			UserUUID string `json:"user_uuid"`
+			APIKey   string `json:"api_key"`
+			conf.QuotaConf
+		}{}
+		err := json.Unmarshal([]byte(readBody(ctx)), &d)
+		if ok := checkErr(err, ctx); !ok {
+			return
+		}
+		dim, id := quotaTarget(d.UserUUID, d.APIKey)
+		err = services.SetQuota(dim, id, &d.QuotaConf)
+		if ok := checkErr(err, ctx); !ok {
+			return
+		}
+		ctx.JSON(http.StatusOK, ext.M{"status": "ok"})
+	})
+}
+
+// withUsageCaller 改写问题、生成摘要、向量化等内部调用也按本次对话的调用方统计用量
+func withUsageCaller(ctx context.Context, stringOpts map[string]string) context.Context {
+	return services.WithUsageCaller(ctx, &services.UsageCaller{
+		Kind:     services.UsageKindChat,
+		UserUUID: stringOpts["userUUID"],
+		APIKey:   stringOpts["apiKey"],
+		ClsName:  stringOpts["clsName"],
+	})
+}
+
+func quotaTarget(userUUID, apiKey string) (string, string) {
+	if len(userUUID) > 0 {
+		return services.UsageDimUser, userUUID
+	}
+	return services.UsageDimAPIKey, apiKey
+}
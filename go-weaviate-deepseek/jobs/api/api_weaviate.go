@@ -1,8 +1,10 @@
 package api
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
+	"go-weaviate-deepseek/conf"
 	"go-weaviate-deepseek/ext"
 	"go-weaviate-deepseek/ext/weaviatelib"
 	"go-weaviate-deepseek/services"
@@ -48,6 +50,11 @@ func apiWeaviate(r *gin.Engine) {
 		if ok := checkErr(err, ctx); !ok {
 			return
 		}
+		if services.AnswerCacheEnabled() {
+			if err := weaviatelib.RemoveAnswerCache(clsName); err != nil {
+				lwea().Warnf("remove answer cache err, cls_name: %s, err: %s", clsName, err)
+			}
+		}
 		ctx.JSON(http.StatusOK, ext.M{"status": "ok"})
 	})
 
@@ -72,10 +79,19 @@ func apiWeaviate(r *gin.Engine) {
 		prompt := doc.Get("prompt").String()
 		distance := doc.Get("distance").Float()
 		clsName := doc.Get("cls_name").String()
+		// lang: 按语言过滤或加权，auto为自动识别prompt的语言, lang_mode: filter | boost(default)
+		lang := doc.Get("lang").String()
+		if lang == "auto" {
+			lang = ext.DetectLang(prompt)
+		}
 
-		lwea().Printf("weaviate/search, clsName: %s, prompt: %s, distance: %f", clsName, prompt, distance)
+		lwea().Printf("weaviate/search, clsName: %s, prompt: %s, distance: %f, lang: %s", clsName, prompt, distance, lang)
 
-		b, err := weaviatelib.Query(clsName, prompt, float32(distance))
+		b, err := weaviatelib.QueryWith(clsName, prompt, weaviatelib.QueryOpts{
+			Distance: float32(distance),
+			Lang:     lang,
+			LangMode: doc.Get("lang_mode").String(),
+		})
 		if ok := checkErr(err, ctx); !ok {
 			return
 		}
@@ -120,7 +136,14 @@ func apiWeaviate(r *gin.Engine) {
 	// {
 	// 	"cls_name": "aabbcc",
 	// 	"type": "image",
-	// 	"data": "{\"base64\":\"xxxxxx\",\"url\":\"https://eggman.tv/a.png\"\"title\":\"a image desp\"}"
+	// 	"data": "{\"base64\":\"xxxxxx\",\"url\":\"https://eggman.tv/a.png\"\"title\":\"a image desp\",\"caption\":true}"
+	// }
+	// caption为true时会调用视觉模型生成图片描述，和OCR文字合并后保存
+	// type archive(zip/tar.gz), pdf/office/图片/markdown混合:
+	// {
+	// 	"cls_name": "aabbcc",
+	// 	"type": "archive",
+	// 	"data": "{\"url\":\"https://eggman.tv/docs.zip\",\"name\":\"docs.zip\"}"
 	// }
 	r.POST("/weaviate/create", func(ctx *gin.Context) {
 		str := readBody(ctx)
@@ -129,20 +152,125 @@ func apiWeaviate(r *gin.Engine) {
 		if ok := checkErr(err, ctx); !ok {
 			return
 		}
+		i.APIKey = ctx.GetHeader(conf.APIKeyHeader)
+		err = services.CheckQuota(i.UserUUID, i.APIKey)
+		if ok := checkErr(err, ctx); !ok {
+			return
+		}
+		i.JobID = ext.GenGlobalID()
 		go func() {
-			trackid := ext.GenGlobalID()
-			lwea().Printf("import start, %s, source type: %s, cls_name: %s", trackid, i.Type, i.ClsName)
+			lwea().Printf("import start, %s, source type: %s, cls_name: %s", i.JobID, i.Type, i.ClsName)
 			err := i.Do()
 			if err != nil {
 				lwea().Warn("weaviate create err:", err)
 				return
 			}
-			lwea().Printf("import done, %s, source type: %s, cls_name: %s", trackid, i.Type, i.ClsName)
+			lwea().Printf("import done, %s, source type: %s, cls_name: %s", i.JobID, i.Type, i.ClsName)
 		}()
 
+		ctx.JSON(http.StatusOK, ext.M{"status": "ok", "data": ext.M{"job_id": i.JobID}})
+	})
+
+	// get import job status, archive job has per-file results in children
+	r.GET("/weaviate/import_job", func(ctx *gin.Context) {
+		j, err := services.GetImportJob(ctx.Query("job_id"))
+		if ok := checkErr(err, ctx); !ok {
+			return
+		}
+		ctx.JSON(http.StatusOK, ext.M{"status": "ok", "data": j})
+	})
+
+	// create image db(img2vec-neural), schema is optional
+	// {
+	// 	"cls_name": "xxccc",
+	// 	"desp": "desp",
+	// 	"user_uuid": "xx"
+	// }
+	r.POST("/weaviate/create_image_db", func(ctx *gin.Context) {
+		str := readBody(ctx)
+		doc := gjson.Parse(str)
+		clsName := doc.Get("cls_name").String()
+		desp := doc.Get("desp").String()
+		schema := doc.Get("schema").String()
+
+		err := services.CheckQuota(doc.Get("user_uuid").String(), ctx.GetHeader(conf.APIKeyHeader))
+		if ok := checkErr(err, ctx); !ok {
+			return
+		}
+		err = weaviatelib.DefineImageSchema(clsName, schema, desp)
+		if ok := checkErr(err, ctx); !ok {
+			return
+		}
 		ctx.JSON(http.StatusOK, ext.M{"status": "ok"})
 	})
 
+	// insert image to image db, base64 or url
+	// {
+	// 	"cls_name": "xxccc",
+	// 	"base64": "xxxx",
+	// 	"url": "https://eggman.tv/a.png",
+	// 	"title": "a image desp",
+	// 	"caption": true,
+	// 	"user_uuid": "xx"
+	// }
+	r.POST("/weaviate/create_image", func(ctx *gin.Context) {
+		str := readBody(ctx)
+		doc := gjson.Parse(str)
+		clsName := doc.Get("cls_name").String()
+		userUUID := doc.Get("user_uuid").String()
+		apiKey := ctx.GetHeader(conf.APIKeyHeader)
+
+		err := services.CheckQuota(userUUID, apiKey)
+		if ok := checkErr(err, ctx); !ok {
+			return
+		}
+		// 图片描述的用量按调用方统计
+		usageCtx := services.WithUsageCaller(context.Background(), &services.UsageCaller{
+			Kind:     services.UsageKindImport,
+			UserUUID: userUUID,
+			APIKey:   apiKey,
+			ClsName:  clsName,
+		})
+		id, err := services.ImportImage(usageCtx, clsName, doc.Get("base64").String(),
+			doc.Get("url").String(), doc.Get("title").String(), doc.Get("caption").Bool())
+		if ok := checkErr(err, ctx); !ok {
+			return
+		}
+		ctx.JSON(http.StatusOK, ext.M{"status": "ok", "data": ext.M{"id": id}})
+	})
+
+	// near image search, base64 or url
+	r.POST("/weaviate/search_image", func(ctx *gin.Context) {
+		str := readBody(ctx)
+		doc := gjson.Parse(str)
+		clsName := doc.Get("cls_name").String()
+		distance := doc.Get("distance").Float()
+		b64 := doc.Get("base64").String()
+		err := services.CheckQuota(doc.Get("user_uuid").String(), ctx.GetHeader(conf.APIKeyHeader))
+		if ok := checkErr(err, ctx); !ok {
+			return
+		}
+		if len(b64) == 0 {
+			b64, err = services.ReadImageURLTo64(doc.Get("url").String())
+			if ok := checkErr(err, ctx); !ok {
+				return
+			}
+		}
+
+		lwea().Printf("weaviate/search_image, clsName: %s, distance: %f", clsName, distance)
+
+		b, err := weaviatelib.QueryImage(clsName, b64, float32(distance))
+		if ok := checkErr(err, ctx); !ok {
+			return
+		}
+		images := make([]gjson.Result, 0)
+		gjson.ParseBytes(b).Get(weaviatelib.GetClsName(clsName)).ForEach(func(k, v gjson.Result) bool {
+			images = append(images, v)
+			return true
+		})
+		ctx.JSON(http.StatusOK, ext.M{"status": "ok", "data": images})
+	})
+
 	r.POST("/weaviate/delete", func(ctx *gin.Context) {
 		str := readBody(ctx)
 		doc := gjson.Parse(str)
@@ -156,6 +284,8 @@ func apiWeaviate(r *gin.Engine) {
 		if ok := checkErr(err, ctx); !ok {
 			return
 		}
+		// 引用了该内容的缓存回答失效
+		services.InvalidateAnswers(clsName, id)
 
 		ctx.JSON(http.StatusOK, ext.M{"status": "ok"})
 	})
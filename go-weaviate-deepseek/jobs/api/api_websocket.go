@@ -13,6 +13,7 @@ import (
 	"time"
 
 	"go-weaviate-deepseek/ext/connpool"
+	"go-weaviate-deepseek/services"
 
 	"github.com/gin-gonic/gin"
 	"github.com/gorilla/websocket"
@@ -41,6 +42,12 @@ var (
 	wsSpace   = []byte{' '}
 )
 
+// wsIdentity 建立连接时的调用方，create命令中的 api_key 和 user_uuid 不能覆盖
+type wsIdentity struct {
+	APIKey   string
+	UserUUID string
+}
+
 type wsData struct {
 	GID  string            `json:"gid"` // 目前没用，只有再发送广播消息时才需要客户端发送，目前没有使用广播消息
 	Cmd  string            `json:"cmd"`
@@ -81,12 +88,13 @@ func apiWS(r *gin.Engine) {
 
 	// 获取连接数量
 	r.POST("/ws/runtime", func(ctx *gin.Context) {
+		clients, groups := wsConnPool.Size()
 		ctx.JSON(http.StatusOK, ext.M{
 			"status": "ok",
 			"data": ext.M{
 				"max_connection":      wsMaxConnection,
-				"current_groups":      len(wsConnPool.ClientsGroup),
-				"current_connections": len(wsConnPool.Clients),
+				"current_groups":      groups,
+				"current_connections": clients,
 			},
 		})
 	})
@@ -95,7 +103,7 @@ func apiWS(r *gin.Engine) {
 	// ws://host:port/ws?ref=gidSecret
 	r.GET("/ds-ws", func(ctx *gin.Context) {
 		// reach max connection limit
-		if len(wsConnPool.Clients) >= wsMaxConnection {
+		if clients, _ := wsConnPool.Size(); clients >= wsMaxConnection {
 			ctx.AbortWithStatusJSON(402, ext.M{
 				"status": "error",
 				"error":  "ws reach max connection limit",
@@ -110,15 +118,26 @@ func apiWS(r *gin.Engine) {
 		// 	return
 		// }
 
+		// 配置了限额时api key必须已经登记
+		identity := newWSIdentity(ctx.Request)
+		if err := services.CheckAPIKey(identity.APIKey); err != nil {
+			ctx.AbortWithStatusJSON(http.StatusUnauthorized, ext.M{
+				"status": "error",
+				"error":  err.Error(),
+			})
+			return
+		}
+
 		gid := ext.GenUUID()
-		wshandler(ctx.Writer, ctx.Request, gid)
+		wshandler(ctx.Writer, ctx.Request, gid, identity)
 	})
 
 	// 监控连接池的大小
 	if !conf.IsPrd() {
 		go func() {
 			for {
-				wsl().Printf("conn group size: %d, conn size: %d", len(wsConnPool.ClientsGroup), len(wsConnPool.Clients))
+				clients, groups := wsConnPool.Size()
+				wsl().Printf("conn group size: %d, conn size: %d", groups, clients)
 				time.Sleep(2 * time.Second)
 			}
 		}()
@@ -131,7 +150,19 @@ var wsGrader = websocket.Upgrader{
 	},
 }
 
-func wshandler(w http.ResponseWriter, r *http.Request, gid string) {
+// newWSIdentity 浏览器不能设置websocket的请求头，也可以通过query传递
+func newWSIdentity(r *http.Request) *wsIdentity {
+	identity := &wsIdentity{
+		APIKey:   r.Header.Get(conf.APIKeyHeader),
+		UserUUID: r.URL.Query().Get("user_uuid"),
+	}
+	if len(identity.APIKey) == 0 {
+		identity.APIKey = r.URL.Query().Get("api_key")
+	}
+	return identity
+}
+
+func wshandler(w http.ResponseWriter, r *http.Request, gid string, identity *wsIdentity) {
 	conn, err := wsGrader.Upgrade(w, r, nil)
 	if err != nil {
 		wsl().Warnln("failed to set websocket upgrade:", err)
@@ -139,7 +170,7 @@ func wshandler(w http.ResponseWriter, r *http.Request, gid string) {
 	}
 
 	sid := ext.GenUUID()
-	c := connpool.NewClient(gid, sid, nil)
+	c := connpool.NewClient(gid, sid, identity)
 	chatCtx, cancel := context.WithCancel(context.Background())
 	c.ChatCtx = chatCtx
 	c.ChatCancelFn = cancel
@@ -153,6 +184,10 @@ func wshandler(w http.ResponseWriter, r *http.Request, gid string) {
 func wsRead(client *connpool.Client) {
 	conn := client.Conn
 	defer func() {
+		// 连接断开后取消进行中的对话和重试
+		if client.ChatCancelFn != nil {
+			client.ChatCancelFn()
+		}
 		conn.Close()
 		wsConnPool.Remove(client.GID, client.ConnID)
 	}()
@@ -245,6 +280,7 @@ func wsOnMessage(msg string, client *connpool.Client) {
 			"data": d.Data,
 		}))
 	case "create":
+		applyWSIdentity(client, d.Data)
 		go ChatStreamWithCallback(client.ChatCtx, d.Data, func(msg ext.M) {
 			wsSend(client.GID, ext.ToB(msg))
 		}, func(url string, res ext.M) {
@@ -269,6 +305,10 @@ func wsOnMessage(msg string, client *connpool.Client) {
 				}
 			}
 		})
+	case "feedback":
+		// 只能评价连接的用户自己的回答
+		applyWSIdentity(client, d.Data)
+		go wsSend(client.GID, ext.ToB(wsFeedback(d.Data)))
 	case "stop":
 		if client.ChatCancelFn != nil {
 			client.ChatCancelFn()
@@ -276,6 +316,21 @@ func wsOnMessage(msg string, client *connpool.Client) {
 	}
 }
 
+// applyWSIdentity 用量和限额按连接时的调用方统计，忽略消息中的 api_key
+func applyWSIdentity(client *connpool.Client, data map[string]string) {
+	if data == nil {
+		return
+	}
+	identity, _ := client.Params.(*wsIdentity)
+	if identity == nil {
+		identity = &wsIdentity{}
+	}
+	data["api_key"] = identity.APIKey
+	if len(identity.UserUUID) > 0 {
+		data["user_uuid"] = identity.UserUUID
+	}
+}
+
 func wsSend(gid string, msg []byte) {
 	// wsl().Printf("ws send msg: %s, gid: %s\n", msg, gid)
 
@@ -0,0 +1,97 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"go-weaviate-deepseek/conf"
+	"go-weaviate-deepseek/ext/connpool"
+	"go-weaviate-deepseek/services"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+)
+
+func TestApplyWSIdentity(t *testing.T) {
+	cases := []struct {
+		desc     string
+		identity *wsIdentity
+		data     map[string]string
+		wantKey  string
+		wantUser string
+	}{
+		{
+			desc:     "message api_key is ignored",
+			identity: &wsIdentity{APIKey: "conn-key"},
+			data:     map[string]string{"api_key": "other-key", "user_uuid": "u1"},
+			wantKey:  "conn-key",
+			wantUser: "u1",
+		},
+		{
+			desc:     "connection user_uuid wins",
+			identity: &wsIdentity{APIKey: "conn-key", UserUUID: "u0"},
+			data:     map[string]string{"user_uuid": "u1"},
+			wantKey:  "conn-key",
+			wantUser: "u0",
+		},
+		{
+			desc:     "no identity",
+			data:     map[string]string{"api_key": "other-key", "user_uuid": "u1"},
+			wantKey:  "",
+			wantUser: "u1",
+		},
+	}
+	for _, c := range cases {
+		client := connpool.NewClient("gid", "sid", nil)
+		if c.identity != nil {
+			client.Params = c.identity
+		}
+		applyWSIdentity(client, c.data)
+		if c.data["api_key"] != c.wantKey || c.data["user_uuid"] != c.wantUser {
+			t.Errorf("%s: api_key = %q, user_uuid = %q", c.desc, c.data["api_key"], c.data["user_uuid"])
+		}
+	}
+}
+
+func TestWSHandshakeAPIKey(t *testing.T) {
+	orig := conf.Settings.Quota
+	defer func() { conf.Settings.Quota = orig }()
+	useRedis(t)
+	conf.Settings.Quota = &conf.QuotaConf{DailyTokens: 1000}
+	if err := services.SetQuota(services.UsageDimAPIKey, "issued", &conf.QuotaConf{DailyTokens: 1000}); err != nil {
+		t.Fatal(err)
+	}
+
+	r := gin.New()
+	apiWS(r)
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+	wsURL := "ws" + strings.TrimPrefix(srv.URL, "http") + "/ds-ws"
+
+	cases := []struct {
+		desc   string
+		query  string
+		header http.Header
+		status int
+	}{
+		{desc: "unknown key", query: "?api_key=made-up", status: http.StatusUnauthorized},
+		{desc: "no key", query: "", status: http.StatusUnauthorized},
+		{desc: "issued key in query", query: "?api_key=issued", status: http.StatusSwitchingProtocols},
+		{desc: "issued key in header", header: http.Header{conf.APIKeyHeader: []string{"issued"}}, status: http.StatusSwitchingProtocols},
+	}
+	for _, c := range cases {
+		conn, rsp, err := websocket.DefaultDialer.Dial(wsURL+c.query, c.header)
+		if conn != nil {
+			conn.Close()
+		}
+		if rsp == nil {
+			t.Errorf("%s: no response, err: %v", c.desc, err)
+			continue
+		}
+		if rsp.StatusCode != c.status {
+			t.Errorf("%s: status got %d, want %d", c.desc, rsp.StatusCode, c.status)
+		}
+	}
+}
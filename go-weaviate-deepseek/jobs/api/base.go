@@ -46,6 +46,11 @@ func RunWebAPI(c chan string) {
 	apiDeepSeekAliyun(r)
 	apiWeaviate(r)
 	apiWS(r)
+	apiUsage(r)
+	apiConversation(r)
+	apiProject(r)
+	apiAnalytics(r)
+	apiFeedback(r)
 
 	r.GET("/", func(ctx *gin.Context) {
 		ctx.String(http.StatusOK, "hi, please access https://eggman.tv to start:)")
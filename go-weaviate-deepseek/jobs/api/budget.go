@@ -0,0 +1,193 @@
+package api
+
+import (
+	"context"
+	"math"
+
+	"go-weaviate-deepseek/conf"
+	"go-weaviate-deepseek/ext"
+	"go-weaviate-deepseek/models"
+	"go-weaviate-deepseek/services"
+
+	"github.com/sashabaranov/go-openai"
+	"github.com/spf13/cast"
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
+)
+
+const (
+	// 消息格式、模板和tokenizer误差预留的tokens
+	budgetReservedTokens = 200
+	// 每条消息的role等格式占用
+	messageOverheadTokens = 4
+	// 历史压缩成摘要时给摘要预留的tokens
+	summaryReservedTokens = 500
+	// 历史最多占用可用tokens的比例，剩下的留给检索到的内容
+	historyBudgetRatio = 0.4
+)
+
+// chatModelFor 模板参数 _chat_model 指定的模型，没有指定时使用默认模型
+func chatModelFor(stringOpts map[string]string) *conf.ModelConf {
+	name := getOptionValue(gjson.Parse(stringOpts["tmplOptionValues"]), "_chat_model", "value")
+	if m := conf.Settings.GetModel(name); m != nil {
+		return m
+	}
+	return conf.Settings.GetModel(conf.Settings.DefaultChatModel)
+}
+
+// applyProfileModel 请求中没有通过 _chat_model 指定模型时使用项目配置的模型
+func applyProfileModel(stringOpts map[string]string, profile *services.ProjectProfile) {
+	if len(profile.ChatModel) == 0 || conf.Settings.GetModel(profile.ChatModel) == nil {
+		return
+	}
+	values := stringOpts["tmplOptionValues"]
+	if len(getOptionValue(gjson.Parse(values), "_chat_model", "value")) > 0 {
+		return
+	}
+	if !gjson.Parse(values).IsArray() {
+		values = "[]"
+	}
+	res, err := sjson.Set(values, "-1", ext.M{"name": "_chat_model", "value": profile.ChatModel})
+	if err != nil {
+		ppml().Warnf("set profile chat model err: %s", err)
+		return
+	}
+	stringOpts["tmplOptionValues"] = res
+}
+
+// promptBudget 按降级链中最小的上下文窗口计算，降级到其他模型时同样的prompt也能放下
+func promptBudget(m *conf.ModelConf) int {
+	if m == nil {
+		return math.MaxInt32
+	}
+	budget := modelBudget(m)
+	for _, name := range conf.Settings.FallbackChain(m.Name) {
+		if b := modelBudget(conf.Settings.GetModel(name)); b < budget {
+			budget = b
+		}
+	}
+	return budget
+}
+
+// modelBudget 上下文窗口减去为回答预留的max_tokens，没有配置上下文窗口时不限制
+func modelBudget(m *conf.ModelConf) int {
+	if m == nil || m.ContextWindow <= 0 {
+		return math.MaxInt32
+	}
+	return m.ContextWindow - m.MaxTokens - budgetReservedTokens
+}
+
+// clampBudget 系统提示和问题已经超过上下文窗口时不再放入历史和检索内容
+func clampBudget(budget int) int {
+	if budget < 0 {
+		ppml().Warnf("prompt is over the context window by %d tokens, no history or context will be added", -budget)
+		return 0
+	}
+	return budget
+}
+
+func modelTokenizer(m *conf.ModelConf) string {
+	if m == nil {
+		return ""
+	}
+	return m.Tokenizer
+}
+
+func messagesTokenLen(tokenizer string, msgs []ext.M) int {
+	n := 0
+	for _, m := range msgs {
+		n += ext.TokenLenFor(tokenizer, cast.ToString(m["content"])) + messageOverheadTokens
+	}
+	return n
+}
+
+// fitHistory 历史超过budget时保留尽量多的最近消息，较早的消息压缩成一条摘要，生成摘要失败时直接丢弃
+func fitHistory(ctx context.Context, tokenizer string, history []ext.M, budget int) []ext.M {
+	if len(history) == 0 || messagesTokenLen(tokenizer, history) <= budget {
+		return history
+	}
+	if budget <= 0 {
+		return []ext.M{}
+	}
+
+	keepBudget := budget - summaryReservedTokens
+	start := len(history)
+	tokens := 0
+	for i := len(history) - 1; i >= 0; i-- {
+		t := messagesTokenLen(tokenizer, history[i:i+1])
+		if tokens+t > keepBudget {
+			break
+		}
+		tokens += t
+		start = i
+	}
+	// 摘要之后第一条为用户的问题
+	for start < len(history) && cast.ToString(history[start]["role"]) != openai.ChatMessageRoleUser {
+		tokens -= messagesTokenLen(tokenizer, history[start:start+1])
+		start++
+	}
+	recent := history[start:]
+
+	turns := make([]*services.ChatTurn, 0, start)
+	for _, m := range history[:start] {
+		turns = append(turns, &services.ChatTurn{
+			Role:    cast.ToString(m["role"]),
+			Content: cast.ToString(m["content"]),
+		})
+	}
+	summary, err := services.SummarizeHistory(ctx, turns)
+	if err != nil {
+		ppml().Warnf("summarize history err, drop %d messages, err: %s", start, err)
+		return recent
+	}
+	summaryMsg := services.SummaryMessage(summary)
+	if tokens+messagesTokenLen(tokenizer, []ext.M{summaryMsg}) > budget {
+		ppml().Warnf("history summary is too long, drop %d messages", start)
+		return recent
+	}
+	ppml().Printf("history is over budget, %d messages summarized, %d kept", start, len(recent))
+	return append([]ext.M{summaryMsg}, recent...)
+}
+
+// fitChunks chunks已按相关度排序，依次加入，放不下的丢弃
+func fitChunks(tokenizer string, chunks []*models.SourceChunk, budget int) []*models.SourceChunk {
+	res := make([]*models.SourceChunk, 0, len(chunks))
+	used := 0
+	for _, c := range chunks {
+		t := ext.TokenLenFor(tokenizer, c.Captions) + 1
+		if used+t > budget {
+			continue
+		}
+		used += t
+		res = append(res, c)
+	}
+	if len(res) < len(chunks) {
+		ppml().Printf("retrieved context is over budget, %d of %d chunks kept", len(res), len(chunks))
+	}
+	return res
+}
+
+// fitMessageRows 普通对话中客户端传的历史，最后一条消息不变，maxLastTokens为模板展开后最长的一条
+func fitMessageRows(ctx context.Context, m *conf.ModelConf, rows []openai.ChatCompletionMessage, maxLastTokens int) []openai.ChatCompletionMessage {
+	if len(rows) < 2 {
+		return rows
+	}
+	history := make([]ext.M, 0, len(rows)-1)
+	for _, r := range rows[:len(rows)-1] {
+		history = append(history, ext.M{"role": r.Role, "content": r.Content})
+	}
+	budget := clampBudget(promptBudget(m) - maxLastTokens - messageOverheadTokens)
+	if messagesTokenLen(modelTokenizer(m), history) <= budget {
+		return rows
+	}
+	fitted := fitHistory(ctx, modelTokenizer(m), history, budget)
+
+	res := make([]openai.ChatCompletionMessage, 0, len(fitted)+1)
+	for _, h := range fitted {
+		res = append(res, openai.ChatCompletionMessage{
+			Role:    cast.ToString(h["role"]),
+			Content: cast.ToString(h["content"]),
+		})
+	}
+	return append(res, rows[len(rows)-1])
+}
@@ -0,0 +1,135 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"math"
+	"strings"
+	"testing"
+
+	"go-weaviate-deepseek/conf"
+	"go-weaviate-deepseek/ext"
+	"go-weaviate-deepseek/models"
+	"go-weaviate-deepseek/services"
+
+	"github.com/spf13/cast"
+)
+
+func chunk(id, captions string) *models.SourceChunk {
+	c := &models.SourceChunk{Captions: captions}
+	c.Additional.ID = id
+	return c
+}
+
+func TestFitChunks(t *testing.T) {
+	long := strings.Repeat("word ", 100)
+	cases := []struct {
+		desc   string
+		chunks []*models.SourceChunk
+		budget int
+		want   []string
+	}{
+		{desc: "all fit", chunks: []*models.SourceChunk{chunk("a", "hello"), chunk("b", "world")}, budget: 100, want: []string{"a", "b"}},
+		{desc: "skip the one over budget", chunks: []*models.SourceChunk{chunk("a", "hello"), chunk("b", long), chunk("c", "world")}, budget: 50, want: []string{"a", "c"}},
+		{desc: "zero budget", chunks: []*models.SourceChunk{chunk("a", "hello")}, budget: 0, want: []string{}},
+		{desc: "negative budget", chunks: []*models.SourceChunk{chunk("a", "hello")}, budget: -10, want: []string{}},
+		{desc: "empty", chunks: []*models.SourceChunk{}, budget: 100, want: []string{}},
+	}
+	for _, c := range cases {
+		got := fitChunks("", c.chunks, c.budget)
+		ids := make([]string, 0, len(got))
+		for _, g := range got {
+			ids = append(ids, g.Additional.ID)
+		}
+		if strings.Join(ids, ",") != strings.Join(c.want, ",") {
+			t.Errorf("%s: got %v, want %v", c.desc, ids, c.want)
+		}
+	}
+}
+
+func TestFitHistory(t *testing.T) {
+	orig := services.ChatFunc
+	defer func() { services.ChatFunc = orig }()
+
+	msg := func(role, content string) ext.M {
+		return ext.M{"role": role, "content": content}
+	}
+	long := strings.Repeat("很长的一段话。", 100)
+	history := []ext.M{
+		msg("user", long), msg("assistant", long),
+		msg("user", long), msg("assistant", long),
+		msg("user", "最近的问题"), msg("assistant", "最近的回答"),
+	}
+	recentTokens := messagesTokenLen("", history[4:])
+
+	cases := []struct {
+		desc        string
+		history     []ext.M
+		budget      int
+		summary     string
+		summaryErr  error
+		wantLen     int
+		wantSummary bool
+	}{
+		{desc: "under budget", history: history, budget: 100000, wantLen: len(history)},
+		{desc: "summarized", history: history, budget: summaryReservedTokens + recentTokens + 10, summary: "摘要", wantLen: 3, wantSummary: true},
+		{desc: "summary err drops old messages", history: history, budget: summaryReservedTokens + recentTokens + 10, summaryErr: errors.New("timeout"), wantLen: 2},
+		{desc: "zero budget", history: history, budget: 0, wantLen: 0},
+		{desc: "empty", history: []ext.M{}, budget: 0, wantLen: 0},
+	}
+	for _, c := range cases {
+		calls := 0
+		services.ChatFunc = func(ctx context.Context, prompt string) (string, error) {
+			calls++
+			return c.summary, c.summaryErr
+		}
+		got := fitHistory(context.Background(), "", c.history, c.budget)
+		if len(got) != c.wantLen {
+			t.Errorf("%s: len = %d, want %d", c.desc, len(got), c.wantLen)
+			continue
+		}
+		if c.wantSummary {
+			if !strings.Contains(cast.ToString(got[0]["content"]), c.summary) || got[1]["content"] != "最近的问题" {
+				t.Errorf("%s: got %v", c.desc, got)
+			}
+		}
+		if !c.wantSummary && c.summaryErr == nil && calls > 0 {
+			t.Errorf("%s: summary should not be generated", c.desc)
+		}
+		// 历史之后第一条是用户的问题
+		if len(got) > 0 && !c.wantSummary && got[0]["role"] != "user" {
+			t.Errorf("%s: first message role = %v", c.desc, got[0]["role"])
+		}
+	}
+}
+
+func TestPromptBudget(t *testing.T) {
+	orig := conf.Settings
+	defer func() { conf.Settings = orig }()
+	settings := *orig
+	settings.Models = []*conf.ModelConf{
+		{Name: "big", ContextWindow: 65536, MaxTokens: 2000, Fallbacks: []string{"small", "missing"}},
+		{Name: "small", ContextWindow: 8192, MaxTokens: 1000},
+		{Name: "unlimited"},
+		{Name: "limited-fallback", Fallbacks: []string{"small"}},
+	}
+	conf.Settings = &settings
+
+	cases := []struct {
+		name string
+		want int
+	}{
+		{"big", 8192 - 1000 - budgetReservedTokens},
+		{"small", 8192 - 1000 - budgetReservedTokens},
+		{"unlimited", math.MaxInt32},
+		{"limited-fallback", 8192 - 1000 - budgetReservedTokens},
+	}
+	for _, c := range cases {
+		if got := promptBudget(conf.Settings.GetModel(c.name)); got != c.want {
+			t.Errorf("%s: got %d, want %d", c.name, got, c.want)
+		}
+	}
+	if got := promptBudget(nil); got != math.MaxInt32 {
+		t.Errorf("nil: got %d", got)
+	}
+}
@@ -0,0 +1,91 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"go-weaviate-deepseek/conf"
+	"go-weaviate-deepseek/ext/llmhttp"
+
+	"gopkg.in/resty.v1"
+)
+
+// ChatProvider 按模型注册表中的provider调用对应的 /chat/completions
+type ChatProvider struct {
+	Name    string
+	BaseURL string
+	APIKey  string
+}
+
+// getChatModel 模型名为空时使用默认模型
+func getChatModel(name string) (*conf.ModelConf, *ChatProvider, error) {
+	if len(name) == 0 {
+		name = conf.Settings.DefaultChatModel
+	}
+	m := conf.Settings.GetModel(name)
+	if m == nil {
+		return nil, nil, fmt.Errorf("chat_model %s is invalid", name)
+	}
+	p := conf.Settings.GetProvider(m.Provider)
+	if p == nil {
+		return nil, nil, fmt.Errorf("provider %s of chat_model %s is not configured", m.Provider, name)
+	}
+	return m, &ChatProvider{
+		Name:    p.Name,
+		BaseURL: p.BaseURL,
+		APIKey:  p.Key(),
+	}, nil
+}
+
+func (cp *ChatProvider) Complete(ctx context.Context, body interface{}) (*resty.Response, error) {
+	return llmhttp.Default.Post(ctx, cp.BaseURL+"/chat/completions", cp.APIKey, body)
+}
+
+func (cp *ChatProvider) Stream(ctx context.Context, body interface{}) (io.ReadCloser, error) {
+	return llmhttp.Default.PostStream(ctx, cp.BaseURL+"/chat/completions", cp.APIKey, body)
+}
+
+// withFallback 按模型的fallback链依次调用fn，只有超时、5xx、限流、熔断时才切换到下一个模型，
+// 返回实际使用的模型
+func withFallback(chatModel string, fn func(m *conf.ModelConf, cp *ChatProvider) error) (*conf.ModelConf, error) {
+	if len(chatModel) == 0 {
+		chatModel = conf.Settings.DefaultChatModel
+	}
+	chain := conf.Settings.FallbackChain(chatModel)
+	if len(chain) == 0 {
+		return nil, fmt.Errorf("chat_model %s is invalid", chatModel)
+	}
+
+	var lastErr error
+	for i, name := range chain {
+		m, cp, err := getChatModel(name)
+		if err != nil {
+			return nil, err
+		}
+		if i > 0 {
+			lada().Warnf("chat model %s unavailable, fallback to %s, err: %s", chain[i-1], name, lastErr)
+		}
+		lastErr = fn(m, cp)
+		if lastErr == nil {
+			return m, nil
+		}
+		if !llmhttp.IsRetryable(lastErr) {
+			return m, lastErr
+		}
+	}
+	return nil, lastErr
+}
+
+// streamWithFallback 流式请求，连接建立后还没有返回内容之前才会切换模型，buildBody 按模型生成请求参数
+func streamWithFallback(ctx context.Context, chatModel string,
+	buildBody func(m *conf.ModelConf) interface{}) (io.ReadCloser, *conf.ModelConf, error) {
+
+	var body io.ReadCloser
+	m, err := withFallback(chatModel, func(m *conf.ModelConf, cp *ChatProvider) error {
+		var err error
+		body, err = cp.Stream(ctx, buildBody(m))
+		return err
+	})
+	return body, m, err
+}
@@ -0,0 +1,102 @@
+package api
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"go-weaviate-deepseek/models"
+)
+
+// 匹配 [1]、[1,2]、[1, 3]
+var citationMatcher = regexp.MustCompile(`\[(\d+(?:\s*[,，]\s*\d+)*)\]`)
+
+var citationNumSplitter = regexp.MustCompile(`\s*[,，]\s*`)
+
+// Citation 回答中引用的上下文，Span为被引用的句子在回答中的位置（按字符计算，左闭右开）
+type Citation struct {
+	Index   int    `json:"index"`
+	ChunkID string `json:"chunk_id"`
+	Title   string `json:"title"`
+	URL     string `json:"url"`
+	Span    [2]int `json:"span"`
+	Text    string `json:"text"`
+}
+
+// numberedContext 每个chunk前加上编号，回答中用 [n] 引用
+func numberedContext(chunks []*models.SourceChunk) string {
+	lines := make([]string, 0, len(chunks))
+	for i, c := range chunks {
+		lines = append(lines, fmt.Sprintf("[%d] %s", i+1, c.Captions))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// parseCitations 解析回答中的 [n]，编号不存在的引用会从回答中删除，返回处理后的回答和引用列表
+func parseCitations(content string, chunks []*models.SourceChunk) (string, []*Citation) {
+	// 先删除无效的编号，保证span对应最终的回答
+	content = citationMatcher.ReplaceAllStringFunc(content, func(ma string) string {
+		valid := validCitationNums(ma, len(chunks))
+		if len(valid) == 0 {
+			return ""
+		}
+		nums := make([]string, 0, len(valid))
+		for _, idx := range valid {
+			nums = append(nums, strconv.Itoa(idx))
+		}
+		return "[" + strings.Join(nums, ",") + "]"
+	})
+
+	citations := make([]*Citation, 0)
+	runes := []rune(content)
+	for _, loc := range citationMatcher.FindAllStringSubmatchIndex(content, -1) {
+		start := len([]rune(content[:loc[0]]))
+		end := len([]rune(content[:loc[1]]))
+		spanStart := sentenceStart(runes, start)
+		for _, idx := range validCitationNums(content[loc[2]:loc[3]], len(chunks)) {
+			c := chunks[idx-1]
+			citations = append(citations, &Citation{
+				Index:   idx,
+				ChunkID: c.Additional.ID,
+				Title:   c.Title,
+				URL:     c.URL,
+				Span:    [2]int{spanStart, end},
+				Text:    strings.TrimSpace(citationMatcher.ReplaceAllString(string(runes[spanStart:start]), "")),
+			})
+		}
+	}
+	return content, citations
+}
+
+// validCitationNums 按十进制解析，[010] 为第10段
+func validCitationNums(ma string, size int) []int {
+	ma = strings.Trim(ma, "[]")
+	res := make([]int, 0)
+	for _, n := range citationNumSplitter.Split(ma, -1) {
+		idx, err := strconv.Atoi(n)
+		if err == nil && idx >= 1 && idx <= size {
+			res = append(res, idx)
+		}
+	}
+	return res
+}
+
+// sentenceStart 从引用标记往前找到句子的开头，紧挨着标记的标点和其他引用标记不算
+func sentenceStart(runes []rune, pos int) int {
+	i := pos - 1
+	for i >= 0 && (strings.ContainsRune("。！？.!?\n ", runes[i]) || runes[i] == ']') {
+		if runes[i] == ']' {
+			for i >= 0 && runes[i] != '[' {
+				i--
+			}
+		}
+		i--
+	}
+	for ; i >= 0; i-- {
+		if strings.ContainsRune("。！？.!?\n", runes[i]) || runes[i] == ']' {
+			return i + 1
+		}
+	}
+	return 0
+}
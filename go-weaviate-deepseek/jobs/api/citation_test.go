@@ -0,0 +1,106 @@
+package api
+
+import (
+	"fmt"
+	"testing"
+
+	"go-weaviate-deepseek/models"
+)
+
+func TestNumberedContext(t *testing.T) {
+	cases := []struct {
+		chunks []*models.SourceChunk
+		want   string
+	}{
+		{chunks: []*models.SourceChunk{}, want: ""},
+		{chunks: []*models.SourceChunk{chunk("a", "第一段")}, want: "[1] 第一段"},
+		{chunks: []*models.SourceChunk{chunk("a", "第一段"), chunk("b", "second")}, want: "[1] 第一段\n[2] second"},
+	}
+	for _, c := range cases {
+		if got := numberedContext(c.chunks); got != c.want {
+			t.Errorf("numberedContext = %q, want %q", got, c.want)
+		}
+	}
+}
+
+func TestParseCitations(t *testing.T) {
+	chunks := []*models.SourceChunk{chunk("a", "A"), chunk("b", "B")}
+	cases := []struct {
+		desc        string
+		content     string
+		wantContent string
+		// index:chunk_id:span:text
+		want []string
+	}{
+		{
+			desc:        "no citations",
+			content:     "没有引用。",
+			wantContent: "没有引用。",
+			want:        []string{},
+		},
+		{
+			desc:        "one per sentence",
+			content:     "A句子[1]。B句子[2]。",
+			wantContent: "A句子[1]。B句子[2]。",
+			want:        []string{"1:a:[0 6]:A句子", "2:b:[7 13]:B句子"},
+		},
+		{
+			desc:        "multiple numbers are normalized",
+			content:     "Both are true [1, 2].",
+			wantContent: "Both are true [1,2].",
+			want:        []string{"1:a:[0 19]:Both are true", "2:b:[0 19]:Both are true"},
+		},
+		{
+			desc:        "chinese comma is normalized",
+			content:     "都对[1，2]",
+			wantContent: "都对[1,2]",
+			want:        []string{"1:a:[0 7]:都对", "2:b:[0 7]:都对"},
+		},
+		{
+			desc:        "invalid number is removed",
+			content:     "不存在[3]。存在[2]",
+			wantContent: "不存在。存在[2]",
+			want:        []string{"2:b:[4 9]:存在"},
+		},
+		{
+			desc:        "invalid number in a group",
+			content:     "部分[1,5]",
+			wantContent: "部分[1]",
+			want:        []string{"1:a:[0 5]:部分"},
+		},
+		{
+			desc:        "adjacent markers share the sentence",
+			content:     "First. Second[1][2]",
+			wantContent: "First. Second[1][2]",
+			want:        []string{"1:a:[6 16]:Second", "2:b:[6 19]:Second"},
+		},
+	}
+	for _, c := range cases {
+		content, citations := parseCitations(c.content, chunks)
+		if content != c.wantContent {
+			t.Errorf("%s: content = %q, want %q", c.desc, content, c.wantContent)
+		}
+		got := make([]string, 0, len(citations))
+		for _, ci := range citations {
+			got = append(got, fmt.Sprintf("%d:%s:%v:%s", ci.Index, ci.ChunkID, ci.Span, ci.Text))
+		}
+		if fmt.Sprint(got) != fmt.Sprint(c.want) {
+			t.Errorf("%s: citations = %v, want %v", c.desc, got, c.want)
+		}
+	}
+}
+
+func TestParseCitationsLeadingZero(t *testing.T) {
+	chunks := make([]*models.SourceChunk, 0, 10)
+	for _, id := range "abcdefghij" {
+		chunks = append(chunks, chunk(string(id), "内容"))
+	}
+	// 前导0按十进制解析，不能当成八进制
+	content, citations := parseCitations("第十段[010]，第一段[01]", chunks)
+	if content != "第十段[10]，第一段[1]" {
+		t.Errorf("content = %q", content)
+	}
+	if len(citations) != 2 || citations[0].ChunkID != "j" || citations[1].ChunkID != "a" {
+		t.Errorf("citations = %+v", citations)
+	}
+}
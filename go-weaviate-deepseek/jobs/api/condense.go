@@ -0,0 +1,54 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"go-weaviate-deepseek/ext"
+
+	"github.com/spf13/cast"
+)
+
+const (
+	condensePrompt = `根据下面的对话历史，把用户最后的问题改写成一个不依赖上下文、可以独立理解的检索问题，补全其中省略的主语和指代。保持问题原来的语言，只输出改写后的问题。
+
+对话历史：
+%s
+
+最后的问题：%s`
+
+	// 改写时使用的最近消息数量和每条消息的最大长度
+	condenseHistorySize   = 6
+	condenseMessageLength = 500
+)
+
+// condenseQuery 结合对话历史把追问改写成独立的检索问题，没有历史或改写失败时返回原问题
+func condenseQuery(ctx context.Context, history []ext.M, question string) string {
+	if len(history) == 0 {
+		return question
+	}
+	if len(history) > condenseHistorySize {
+		history = history[len(history)-condenseHistorySize:]
+	}
+	lines := make([]string, 0, len(history))
+	for _, m := range history {
+		content := []rune(cast.ToString(m["content"]))
+		if len(content) > condenseMessageLength {
+			content = content[:condenseMessageLength]
+		}
+		lines = append(lines, cast.ToString(m["role"])+": "+string(content))
+	}
+
+	res, err := ChatText(ctx, fmt.Sprintf(condensePrompt, strings.Join(lines, "\n"), question))
+	if err != nil {
+		ppml().Warnf("condense query err: %s", err)
+		return question
+	}
+	res = strings.TrimSpace(ext.Oneline(res))
+	if len(res) == 0 {
+		return question
+	}
+	ppml().Printf("query condensed, from: %s, to: %s", question, res)
+	return res
+}
@@ -0,0 +1,123 @@
+package api
+
+import (
+	"sort"
+
+	"go-weaviate-deepseek/conf"
+	"go-weaviate-deepseek/ext"
+	"go-weaviate-deepseek/models"
+	"go-weaviate-deepseek/services"
+
+	"github.com/spf13/cast"
+)
+
+// recordChatDoneCb 回答全部完成后保存问题、回答和检索到的内容，用于之后的评价
+func recordChatDoneCb(stringOpts map[string]string, doneCb func(string, ext.M)) func(string, ext.M) {
+	if len(stringOpts["chatUUID"]) == 0 {
+		return doneCb
+	}
+	var workflow, answer string
+	return func(url string, res ext.M) {
+		doneCb(url, res)
+
+		data, ok := res["data"].(ext.M)
+		if !ok || res["status"] != "ok" {
+			return
+		}
+		if w := cast.ToString(data["workflow"]); w != workflow {
+			workflow = w
+			answer = ""
+		}
+		answer += cast.ToString(data["content"])
+		if !cast.ToBool(data["is_finished"]) {
+			return
+		}
+		record := &services.ChatRecord{
+			ChatUUID:  cast.ToString(data["chat_uuid"]),
+			UserUUID:  cast.ToString(data["user_uuid"]),
+			Prompt:    cast.ToString(data["ori_prompt"]),
+			Answer:    answer,
+			ChatModel: cast.ToString(data["chat_model"]),
+			Chunks:    make([]*services.FeedbackChunk, 0),
+		}
+		if len(record.Prompt) == 0 {
+			record.Prompt = cast.ToString(data["prompt"])
+		}
+		if dbSource, ok := data["db_source"].(ext.M); ok {
+			record.ClsName = cast.ToString(dbSource["cls_name"])
+			chunks, _ := dbSource["chunks"].([]*models.SourceChunk)
+			for _, c := range chunks {
+				record.Chunks = append(record.Chunks, &services.FeedbackChunk{
+					ID:       c.Additional.ID,
+					Title:    c.Title,
+					URL:      c.URL,
+					Distance: c.Additional.Distance,
+				})
+			}
+		}
+		go func() {
+			if err := services.SaveChatRecord(record); err != nil {
+				ppml().Warnf("save chat record err, chat_uuid: %s, err: %s", record.ChatUUID, err)
+			}
+		}()
+	}
+}
+
+// demoteDownvoted 差评次数达到 demote_min_downvotes 的内容按次数往后移，不修改原来的distance
+func demoteDownvoted(clsName string, chunks []*models.SourceChunk) []*models.SourceChunk {
+	fc := conf.Settings.Feedback
+	if fc == nil || !fc.DemoteDownvoted || len(chunks) < 2 {
+		return chunks
+	}
+	ids := make([]string, 0, len(chunks))
+	for _, c := range chunks {
+		ids = append(ids, c.Additional.ID)
+	}
+	downvotes, err := services.ChunkDownvotes(clsName, ids)
+	if err != nil {
+		ppml().Warnf("get chunk downvotes err: %s", err)
+		return chunks
+	}
+	if len(downvotes) == 0 {
+		return chunks
+	}
+	ppml().Printf("chunks demoted by downvotes, cls_name: %s, downvotes: %v", clsName, downvotes)
+	return demoteByDownvotes(fc, chunks, downvotes)
+}
+
+// demoteByDownvotes 按差评次数计算每个chunk往后移的位置数，移动后排名相同时差评少的在前
+func demoteByDownvotes(fc *conf.FeedbackConf, chunks []*models.SourceChunk, downvotes map[string]int) []*models.SourceChunk {
+	// 按排名计算，不同检索方式（包括混合检索和multi_query的融合排序）都适用
+	penalty := func(c *models.SourceChunk) float32 {
+		n := downvotes[c.Additional.ID]
+		if n < fc.DemoteMinDownvotes {
+			return 0
+		}
+		p := fc.DemotePerDownvote * float32(n)
+		if fc.DemoteMaxPositions > 0 && p > fc.DemoteMaxPositions {
+			p = fc.DemoteMaxPositions
+		}
+		return p
+	}
+	type ranked struct {
+		chunk   *models.SourceChunk
+		rank    float32
+		penalty float32
+	}
+	rs := make([]*ranked, 0, len(chunks))
+	for i, c := range chunks {
+		p := penalty(c)
+		rs = append(rs, &ranked{chunk: c, rank: float32(i) + p, penalty: p})
+	}
+	sort.SliceStable(rs, func(i, j int) bool {
+		if rs[i].rank != rs[j].rank {
+			return rs[i].rank < rs[j].rank
+		}
+		return rs[i].penalty < rs[j].penalty
+	})
+	res := make([]*models.SourceChunk, 0, len(rs))
+	for _, r := range rs {
+		res = append(res, r.chunk)
+	}
+	return res
+}
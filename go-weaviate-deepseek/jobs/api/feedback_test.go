@@ -0,0 +1,61 @@
+package api
+
+import (
+	"reflect"
+	"testing"
+
+	"go-weaviate-deepseek/conf"
+	"go-weaviate-deepseek/models"
+)
+
+func TestDemoteByDownvotes(t *testing.T) {
+	fc := &conf.FeedbackConf{DemoteDownvoted: true, DemoteMinDownvotes: 2, DemotePerDownvote: 0.5, DemoteMaxPositions: 3}
+	chunks := func() []*models.SourceChunk {
+		return []*models.SourceChunk{chunk("a", ""), chunk("b", ""), chunk("c", ""), chunk("d", ""), chunk("e", "")}
+	}
+	cases := []struct {
+		desc      string
+		downvotes map[string]int
+		want      []string
+	}{
+		{desc: "below min downvotes", downvotes: map[string]int{"a": 1}, want: []string{"a", "b", "c", "d", "e"}},
+		{desc: "one position per two downvotes", downvotes: map[string]int{"a": 2}, want: []string{"b", "a", "c", "d", "e"}},
+		// a的排名为1.5，排在b(1)后面c(2)前面
+		{desc: "fractional positions", downvotes: map[string]int{"a": 3}, want: []string{"b", "a", "c", "d", "e"}},
+		{desc: "capped by max positions", downvotes: map[string]int{"a": 100}, want: []string{"b", "c", "d", "a", "e"}},
+		// b移动后排名为2，和c相同，差评少的c在前
+		{desc: "tie keeps the less downvoted first", downvotes: map[string]int{"b": 2}, want: []string{"a", "c", "b", "d", "e"}},
+		{desc: "demoted past the end", downvotes: map[string]int{"d": 100}, want: []string{"a", "b", "c", "e", "d"}},
+		// a和b都往后移一位，a(1)仍在最前，b(2)和c(2)相同时c在前
+		{desc: "several demoted", downvotes: map[string]int{"a": 2, "b": 2}, want: []string{"a", "c", "b", "d", "e"}},
+	}
+	for _, c := range cases {
+		got := demoteByDownvotes(fc, chunks(), c.downvotes)
+		if ids := chunkIDs(got); !reflect.DeepEqual(ids, c.want) {
+			t.Errorf("%s: got %v, want %v", c.desc, ids, c.want)
+		}
+	}
+}
+
+func TestDemoteDownvotedDisabled(t *testing.T) {
+	prev := conf.Settings.Feedback
+	defer func() { conf.Settings.Feedback = prev }()
+
+	chunks := []*models.SourceChunk{chunk("a", ""), chunk("b", "")}
+	conf.Settings.Feedback = nil
+	if got := demoteDownvoted("Test", chunks); !reflect.DeepEqual(chunkIDs(got), []string{"a", "b"}) {
+		t.Errorf("nil conf: got %v", chunkIDs(got))
+	}
+	conf.Settings.Feedback = &conf.FeedbackConf{DemoteDownvoted: false, DemoteMinDownvotes: 1, DemotePerDownvote: 1}
+	if got := demoteDownvoted("Test", chunks); !reflect.DeepEqual(chunkIDs(got), []string{"a", "b"}) {
+		t.Errorf("disabled: got %v", chunkIDs(got))
+	}
+}
+
+func chunkIDs(chunks []*models.SourceChunk) []string {
+	ids := make([]string, 0, len(chunks))
+	for _, c := range chunks {
+		ids = append(ids, c.Additional.ID)
+	}
+	return ids
+}
@@ -0,0 +1,28 @@
+package api
+
+import (
+	"os"
+	"testing"
+
+	"go-weaviate-deepseek/conn"
+	"go-weaviate-deepseek/ext"
+	"go-weaviate-deepseek/ext/redistest"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+func TestMain(m *testing.M) {
+	ext.L = logrus.New()
+	gin.SetMode(gin.TestMode)
+	os.Exit(m.Run())
+}
+
+// useRedis 测试期间 conn.Redis 使用内存redis
+func useRedis(t *testing.T) *redistest.Server {
+	s, c := redistest.Run(t)
+	prev := conn.Redis
+	conn.Redis = c
+	t.Cleanup(func() { conn.Redis = prev })
+	return s
+}
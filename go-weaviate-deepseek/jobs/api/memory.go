@@ -0,0 +1,87 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+
+	"go-weaviate-deepseek/conf"
+	"go-weaviate-deepseek/ext"
+	"go-weaviate-deepseek/services"
+
+	"github.com/spf13/cast"
+)
+
+// memoryEnabled 没有chat_uuid时不保存，prompt_chains 每一步的prompt不同，也不使用服务端的历史
+func memoryEnabled(stringOpts map[string]string) bool {
+	if !conf.Settings.Memory.Enabled || stringOpts["memory"] == "false" {
+		return false
+	}
+	return len(services.ConversationID(stringOpts["chatUUID"], stringOpts["parentChatUUID"])) > 0
+}
+
+// checkMemoryOwner 使用服务端的历史时，parent_chat_uuid 指向的对话必须属于当前用户
+func checkMemoryOwner(stringOpts map[string]string) error {
+	if !memoryEnabled(stringOpts) {
+		return nil
+	}
+	convID := services.ConversationID(stringOpts["chatUUID"], stringOpts["parentChatUUID"])
+	return services.CheckConversationOwner(convID, stringOpts["userUUID"])
+}
+
+// loadMemory 客户端没有传历史时，把服务端保存的历史和本次的问题拼成 has_context 格式的prompt
+func loadMemory(stringOpts map[string]string, hasContext bool) bool {
+	if hasContext || len(stringOpts["promptChains"]) > 0 || !memoryEnabled(stringOpts) {
+		return hasContext
+	}
+	convID := services.ConversationID(stringOpts["chatUUID"], stringOpts["parentChatUUID"])
+	history, err := services.HistoryMessages(convID, stringOpts["userUUID"])
+	if err != nil {
+		ppml().Warnf("load conversation history err, id: %s, err: %s", convID, err)
+		return hasContext
+	}
+	if len(history) == 0 {
+		return hasContext
+	}
+	history = append(history, ext.M{"role": "user", "content": stringOpts["prompt"]})
+	b, _ := json.Marshal(history)
+	stringOpts["prompt"] = string(b)
+	ppml().Printf("conversation history loaded, id: %s, messages: %d", convID, len(history)-1)
+	return true
+}
+
+// rememberDoneCb 回答全部完成后保存本轮的问题和回答，分段的回答会合并，prompt_chains只保存最后一步
+func rememberDoneCb(stringOpts map[string]string, doneCb func(string, ext.M)) func(string, ext.M) {
+	if !memoryEnabled(stringOpts) {
+		return doneCb
+	}
+	convID := services.ConversationID(stringOpts["chatUUID"], stringOpts["parentChatUUID"])
+	var workflow, answer string
+	return func(url string, res ext.M) {
+		doneCb(url, res)
+
+		data, ok := res["data"].(ext.M)
+		if !ok || res["status"] != "ok" {
+			return
+		}
+		if w := cast.ToString(data["workflow"]); w != workflow {
+			workflow = w
+			answer = ""
+		}
+		answer += cast.ToString(data["content"])
+		if !cast.ToBool(data["is_finished"]) {
+			return
+		}
+		turns := []*services.ChatTurn{
+			{Role: "user", Content: cast.ToString(data["ori_prompt"]), ChatUUID: stringOpts["chatUUID"]},
+			{Role: "assistant", Content: answer, ChatUUID: stringOpts["chatUUID"]},
+		}
+		userUUID := stringOpts["userUUID"]
+		// 超出限制时需要调用大模型生成摘要，不阻塞当前连接
+		go func() {
+			err := services.AppendTurns(withUsageCaller(context.Background(), stringOpts), convID, userUUID, turns...)
+			if err != nil {
+				ppml().Warnf("save conversation err, id: %s, err: %s", convID, err)
+			}
+		}()
+	}
+}
@@ -0,0 +1,162 @@
+package api
+
+import (
+	"fmt"
+	"time"
+
+	"go-weaviate-deepseek/ext"
+	"go-weaviate-deepseek/services"
+
+	"gopkg.in/resty.v1"
+)
+
+const (
+	// 固定内容分段推送，和大模型的流式回答保持一致
+	replyPieceSize     = 8
+	replyPieceInterval = 20 * time.Millisecond
+)
+
+// handleNoContext 记录检索不到内容的问题，refuse 和 escalate 直接回复固定内容，不调用大模型，
+// 返回false时继续使用通用知识回答
+func handleNoContext(stringOpts map[string]string, profile *services.ProjectProfile, query string,
+	dbSource ext.M, msgCb func(res ext.M), doneCb func(string, ext.M)) bool {
+
+	ppml().Printf("no relevant context, cls_name: %s, policy: %s, query: %s",
+		stringOpts["clsName"], profile.NoContextPolicy, query)
+	logUnanswered(stringOpts, query, profile.NoContextPolicy, services.UnansweredNoContext)
+
+	switch profile.NoContextPolicy {
+	case services.NoContextGeneral:
+		return false
+	case services.NoContextEscalate:
+		go escalate(profile, stringOpts, query)
+	}
+	replyWithoutLLM(stringOpts, profile.NoContextReply(), "no_context", "", dbSource, []*Citation{}, msgCb, doneCb)
+	return true
+}
+
+// logUnanswered 失败时只记录日志
+func logUnanswered(stringOpts map[string]string, query, policy, reason string) {
+	err := services.LogUnanswered(&services.UnansweredQuestion{
+		ClsName:  stringOpts["clsName"],
+		Question: stringOpts["oriPrompt"],
+		Query:    query,
+		UserUUID: stringOpts["userUUID"],
+		ChatUUID: stringOpts["chatUUID"],
+		Policy:   policy,
+		Reason:   reason,
+	})
+	if err != nil {
+		ppml().Warnf("log unanswered question err: %s", err)
+	}
+}
+
+// escalate 通知人工客服，失败时只记录日志
+func escalate(profile *services.ProjectProfile, stringOpts map[string]string, query string) {
+	if len(profile.EscalationWebhook) == 0 {
+		ppml().Warnf("escalation webhook is not set, pid: %s", profile.PID)
+		return
+	}
+	rsp, err := resty.New().
+		SetTimeout(10 * time.Second).
+		R().
+		SetBody(ext.M{
+			"pid":              profile.PID,
+			"question":         stringOpts["oriPrompt"],
+			"query":            query,
+			"user_uuid":        stringOpts["userUUID"],
+			"chat_uuid":        stringOpts["chatUUID"],
+			"parent_chat_uuid": stringOpts["parentChatUUID"],
+			"conversation_id":  services.ConversationID(stringOpts["chatUUID"], stringOpts["parentChatUUID"]),
+			"created_at":       time.Now().Unix(),
+		}).
+		Post(profile.EscalationWebhook)
+	if err != nil {
+		ppml().Warnf("escalate err, pid: %s, err: %s", profile.PID, err)
+		return
+	}
+	if rsp.IsError() {
+		ppml().Warnf("escalate err, pid: %s, status: %d, body: %s", profile.PID, rsp.StatusCode(), rsp.String())
+	}
+}
+
+// replyWithoutLLM 按正常回答的格式分段推送固定的内容，不调用大模型
+func replyWithoutLLM(stringOpts map[string]string, content, reason, chatModel string, dbSource ext.M,
+	citations []*Citation, msgCb func(res ext.M), doneCb func(string, ext.M)) {
+
+	rs := []rune(content)
+	for start := 0; start < len(rs); start += replyPieceSize {
+		end := start + replyPieceSize
+		if end >= len(rs) {
+			break
+		}
+		msgCb(ext.M{
+			"cmd": "create",
+			"data": ext.M{
+				"c":        string(rs[start:end]),
+				"chunks":   "1/1",
+				"workflow": "1/1",
+				"done":     false,
+			},
+		})
+		time.Sleep(replyPieceInterval)
+	}
+	last := ""
+	if len(rs) > 0 {
+		last = string(rs[(len(rs)-1)/replyPieceSize*replyPieceSize:])
+	}
+	data := ext.M{
+		"c":         last,
+		"chunks":    "1/1",
+		"workflow":  "1/1",
+		"done":      true,
+		"citations": citations,
+	}
+	if stringOpts["is3rd"] != "true" {
+		data["db_source"] = dbSource
+	}
+	msgCb(ext.M{
+		"cmd":  "create",
+		"data": data,
+	})
+	doneCb(stringOpts["notifyURL"], ext.M{
+		"status": "ok",
+		"data": ext.M{
+			"job_uuid":         stringOpts["jobUUID"],
+			"user_uuid":        stringOpts["userUUID"],
+			"ori_prompt":       stringOpts["oriPrompt"],
+			"prompt":           stringOpts["prompt"],
+			"from":             stringOpts["from"],
+			"parent_chat_uuid": stringOpts["parentChatUUID"],
+			"chat_uuid":        stringOpts["chatUUID"],
+			"content":          content,
+			"is_finished":      true,
+			"reason":           reason,
+			"db_source":        dbSource,
+			"final_prompt":     "",
+			"prompt_tokens":    0,
+			"content_tokens":   0,
+			"web_hook":         stringOpts["webHook"],
+			"is3rd":            stringOpts["is3rd"],
+			"chat_model":       chatModel,
+			"chunks":           "1/1",
+			"prompt_chains":    stringOpts["promptChains"],
+			"workflow":         "1/1",
+			"reasoning_tokens": 0,
+			"usage_source":     "local",
+			"citations":        citations,
+		},
+	})
+}
+
+// getGeneralPrompt 知识库中没有相关内容时使用通用知识回答，免责说明由服务端加在回答前面
+func getGeneralPrompt(stringOpts map[string]string, profile *services.ProjectProfile) []ext.M {
+	return []ext.M{
+		{
+			"role": "system",
+			"content": fmt.Sprintf(`你是一个乐于助人的客户助理机器人，你的名字是%s。%s
+知识库中没有找到和问题相关的内容，请根据你的通用知识简洁地回答，不确定的内容不要编造。回答前已经加上了免责说明，你不需要再说明回答不是来自知识库。`,
+				personaNameFor(stringOpts, profile), languagePolicyText(profile)),
+		},
+	}
+}
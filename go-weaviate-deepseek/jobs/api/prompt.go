@@ -47,7 +47,7 @@ func ParsePrompt(prompt, optionValues string) (*PromptParser, error) {
 		Res: make([]string, 0),
 		// default configs
 		Configs: ext.M{
-			"chat_model": conf.AliDeepSeekModelName,
+			"chat_model": conf.Settings.DefaultChatModel,
 		},
 	}
 	valuesDoc := gjson.Parse(optionValues)
@@ -56,8 +56,8 @@ func ParsePrompt(prompt, optionValues string) (*PromptParser, error) {
 	if len(chM) > 0 {
 		pp.Configs["chat_model"] = chM
 	}
-	modelConfig, exists := chatModelConfigs[cast.ToString(pp.Configs["chat_model"])]
-	if !exists {
+	modelConfig := conf.Settings.GetModel(cast.ToString(pp.Configs["chat_model"]))
+	if modelConfig == nil {
 		return nil, fmt.Errorf("chat_model %s is invalid", cast.ToString(pp.Configs["chat_model"]))
 	}
 
@@ -116,7 +116,6 @@ func ParsePrompt(prompt, optionValues string) (*PromptParser, error) {
 	// Split chunk
 	// Only one variable can trigger chunk spliting, otherwise will be meaningless
 	autoSplit := getOptionValue(valuesDoc, "_auto_split", "value")
-	chunkSizeConfig := modelConfig["chunk_size"].(ext.M)
 	splitedUsed := false
 	if autoSplit == "true" {
 		for uuid, e := range pp.ExtHolders {
@@ -127,7 +126,7 @@ func ParsePrompt(prompt, optionValues string) (*PromptParser, error) {
 			if !exists {
 				v = ""
 			}
-			cs := cast.ToInt(chunkSizeConfig[e["ext_type"]]) - ext.TokenLen(newPrompt)
+			cs := modelConfig.ChunkSize[e["ext_type"]] - ext.TokenLen(newPrompt)
 			if ext.TokenLen(v) > cs {
 				chunks := services.ChunkSplit(v, cs)
 				for _, c := range chunks {
@@ -0,0 +1,200 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"go-weaviate-deepseek/conf"
+	"go-weaviate-deepseek/ext"
+	"go-weaviate-deepseek/ext/embedder"
+	"go-weaviate-deepseek/ext/weaviatelib"
+	"go-weaviate-deepseek/models"
+	"go-weaviate-deepseek/services"
+
+	"github.com/tidwall/gjson"
+)
+
+const (
+	RetrievalModeSimple     = "simple"
+	RetrievalModeMultiQuery = "multi_query"
+	RetrievalModeHyDE       = "hyde"
+
+	// multi_query 生成的改写问题数量
+	multiQueryCount = 3
+	// reciprocal rank fusion 的常数
+	rrfK = 60
+
+	multiQueryPrompt = `请把下面的问题用%d种不同的说法改写，用于在知识库中检索，每种说法从不同的角度描述同一个问题。保持问题原来的语言，每行一个，不要编号，不要输出其他内容。
+
+问题：%s`
+
+	hydePrompt = `请写一段可能出现在产品文档或知识库中、能够回答下面问题的文字，不超过200字。即使不确定也直接给出最可能的内容，使用问题的语言，只输出这段文字。
+
+问题：%s`
+)
+
+// Retrieval 检索结果，Queries为实际用于检索的文本
+type Retrieval struct {
+	Mode    string
+	Queries []string
+	Chunks  []*models.SourceChunk
+}
+
+// retrievalModeFor 请求中的 retrieval_mode 优先，其次为项目配置和集合配置的检索方式
+func retrievalModeFor(stringOpts map[string]string, profile *services.ProjectProfile) string {
+	mode := stringOpts["retrievalMode"]
+	if len(mode) == 0 && profile != nil {
+		mode = profile.RetrievalMode
+	}
+	if len(mode) == 0 {
+		mode = conf.Settings.CollectionRetrievalModes[stringOpts["clsName"]]
+	}
+	switch mode {
+	case RetrievalModeMultiQuery, RetrievalModeHyDE:
+		return mode
+	default:
+		return RetrievalModeSimple
+	}
+}
+
+// Retrieve 按检索方式查询，结果按相关度排序
+func Retrieve(ctx context.Context, clsName, query, mode string, opts weaviatelib.QueryOpts) (*Retrieval, error) {
+	r := &Retrieval{Mode: mode}
+	var err error
+	switch mode {
+	case RetrievalModeMultiQuery:
+		r.Queries = append([]string{query}, paraphraseQuery(ctx, query, multiQueryCount)...)
+		r.Chunks, err = multiQuery(clsName, r.Queries, opts)
+	case RetrievalModeHyDE:
+		// 假设的回答和文档内容更接近，生成失败时使用原问题
+		doc := hypotheticalDoc(ctx, query)
+		r.Queries = []string{doc}
+		r.Chunks, err = queryChunks(clsName, doc, opts)
+	default:
+		r.Chunks, err = queryChunks(clsName, query, opts)
+	}
+	if len(r.Queries) > 0 {
+		recordQueryEmbedding(ctx, clsName, r.Queries)
+	} else {
+		recordQueryEmbedding(ctx, clsName, []string{query})
+	}
+	return r, err
+}
+
+// recordQueryEmbedding 每个检索问题按向量化一次统计调用方的用量
+func recordQueryEmbedding(ctx context.Context, clsName string, queries []string) {
+	e, err := embedder.ForCollection(clsName)
+	if err != nil {
+		return
+	}
+	tokens := 0
+	for _, q := range queries {
+		tokens += ext.TokenLen(q)
+	}
+	services.RecordCallerUsage(ctx, services.UsageKindEmbed, e.ModelID(), tokens, 0)
+}
+
+func queryChunks(clsName, query string, opts weaviatelib.QueryOpts) ([]*models.SourceChunk, error) {
+	b, err := weaviatelib.QueryWith(clsName, query, opts)
+	if err != nil {
+		return nil, err
+	}
+	chunks := make([]*models.SourceChunk, 0)
+	err = json.Unmarshal([]byte(gjson.ParseBytes(b).Get(weaviatelib.GetClsName(clsName)).Raw), &chunks)
+	return chunks, err
+}
+
+// multiQuery 每个问题分别检索，用 reciprocal rank fusion 合并排序
+func multiQuery(clsName string, queries []string, opts weaviatelib.QueryOpts) ([]*models.SourceChunk, error) {
+	results := make([][]*models.SourceChunk, len(queries))
+	errs := make([]error, len(queries))
+	var wg sync.WaitGroup
+	for i, q := range queries {
+		wg.Add(1)
+		go func(i int, q string) {
+			defer wg.Done()
+			results[i], errs[i] = queryChunks(clsName, q, opts)
+		}(i, q)
+	}
+	wg.Wait()
+	// 原问题的检索失败时返回错误，改写问题失败的忽略
+	if errs[0] != nil {
+		return nil, errs[0]
+	}
+
+	limit := opts.Limit
+	if limit <= 0 {
+		limit = 3
+	}
+	return rrfFuse(results, limit), nil
+}
+
+// rrfFuse score = Σ 1/(k + rank)，同一个chunk保留最小的距离
+func rrfFuse(results [][]*models.SourceChunk, limit int) []*models.SourceChunk {
+	scores := make(map[string]float64)
+	chunks := make(map[string]*models.SourceChunk)
+	for _, res := range results {
+		for rank, c := range res {
+			id := c.Additional.ID
+			scores[id] += 1.0 / float64(rrfK+rank+1)
+			if prev, exists := chunks[id]; !exists || c.Additional.Distance < prev.Additional.Distance {
+				chunks[id] = c
+			}
+		}
+	}
+
+	fused := make([]*models.SourceChunk, 0, len(chunks))
+	for _, c := range chunks {
+		fused = append(fused, c)
+	}
+	sort.SliceStable(fused, func(i, j int) bool {
+		si, sj := scores[fused[i].Additional.ID], scores[fused[j].Additional.ID]
+		if si != sj {
+			return si > sj
+		}
+		return fused[i].Additional.Distance < fused[j].Additional.Distance
+	})
+	if len(fused) > limit {
+		fused = fused[:limit]
+	}
+	return fused
+}
+
+// paraphraseQuery 生成失败时返回空，只使用原问题检索
+func paraphraseQuery(ctx context.Context, query string, n int) []string {
+	res, err := ChatText(ctx, fmt.Sprintf(multiQueryPrompt, n, query))
+	if err != nil {
+		ppml().Warnf("paraphrase query err: %s", err)
+		return []string{}
+	}
+	queries := make([]string, 0, n)
+	for _, line := range strings.Split(res, "\n") {
+		line = strings.TrimSpace(strings.TrimLeft(line, "-*0123456789.、) "))
+		if len(line) == 0 || line == query {
+			continue
+		}
+		queries = append(queries, line)
+		if len(queries) == n {
+			break
+		}
+	}
+	ppml().Printf("query paraphrased, query: %s, paraphrases: %v", query, queries)
+	return queries
+}
+
+func hypotheticalDoc(ctx context.Context, query string) string {
+	res, err := ChatText(ctx, fmt.Sprintf(hydePrompt, query))
+	if err != nil {
+		ppml().Warnf("generate hypothetical doc err: %s", err)
+		return query
+	}
+	res = strings.TrimSpace(ext.Oneline(res))
+	if len(res) == 0 {
+		return query
+	}
+	return res
+}
@@ -0,0 +1,64 @@
+package api
+
+import (
+	"strings"
+	"testing"
+
+	"go-weaviate-deepseek/models"
+)
+
+func ranked(ids ...string) []*models.SourceChunk {
+	res := make([]*models.SourceChunk, 0, len(ids))
+	for i, id := range ids {
+		c := chunk(id, id)
+		c.Additional.Distance = float32(i+1) / 10
+		res = append(res, c)
+	}
+	return res
+}
+
+func TestRRFFuse(t *testing.T) {
+	cases := []struct {
+		desc    string
+		results [][]*models.SourceChunk
+		limit   int
+		want    []string
+	}{
+		{desc: "single list keeps order", results: [][]*models.SourceChunk{ranked("a", "b", "c")}, limit: 3, want: []string{"a", "b", "c"}},
+		{desc: "limit", results: [][]*models.SourceChunk{ranked("a", "b", "c")}, limit: 2, want: []string{"a", "b"}},
+		{desc: "found by more queries ranks higher", results: [][]*models.SourceChunk{ranked("a", "b"), ranked("b", "c"), ranked("c", "b")}, limit: 3, want: []string{"b", "c", "a"}},
+		{desc: "empty lists", results: [][]*models.SourceChunk{nil, {}}, limit: 3, want: []string{}},
+	}
+	for _, c := range cases {
+		got := rrfFuse(c.results, c.limit)
+		ids := make([]string, 0, len(got))
+		for _, g := range got {
+			ids = append(ids, g.Additional.ID)
+		}
+		if strings.Join(ids, ",") != strings.Join(c.want, ",") {
+			t.Errorf("%s: got %v, want %v", c.desc, ids, c.want)
+		}
+	}
+}
+
+func TestRRFFuseTieByDistance(t *testing.T) {
+	for i := 0; i < 10; i++ {
+		a, b := chunk("a", "a"), chunk("b", "b")
+		a.Additional.Distance, b.Additional.Distance = 0.2, 0.1
+		got := rrfFuse([][]*models.SourceChunk{{a}, {b}}, 3)
+		if len(got) != 2 || got[0].Additional.ID != "b" {
+			t.Fatalf("got %v, %v", got[0].Additional.ID, got[1].Additional.ID)
+		}
+	}
+}
+
+func TestRRFFuseKeepsMinDistance(t *testing.T) {
+	a1 := chunk("a", "a")
+	a1.Additional.Distance = 0.3
+	a2 := chunk("a", "a")
+	a2.Additional.Distance = 0.1
+	got := rrfFuse([][]*models.SourceChunk{{a1}, {a2}}, 3)
+	if len(got) != 1 || got[0].Additional.Distance != 0.1 {
+		t.Errorf("got %+v", got)
+	}
+}
@@ -0,0 +1,237 @@
+package eval
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"go-weaviate-deepseek/ext"
+	"go-weaviate-deepseek/models"
+
+	"github.com/sirupsen/logrus"
+)
+
+func lev() *logrus.Entry {
+	return ext.LF("eval")
+}
+
+// Case 一个问题和期望检索到的来源，expected_urls 和 expected_ids 至少有一个，
+// 同一个chunk的id和url都写上时检索到后只算一个来源，没有检索到时算两个，最好只写一种
+type Case struct {
+	Question     string   `json:"question"`
+	ExpectedURLs []string `json:"expected_urls"`
+	ExpectedIDs  []string `json:"expected_ids"`
+}
+
+// Retriever 返回按相关度排序的前k个chunk
+type Retriever interface {
+	Retrieve(question string, k int) ([]*models.SourceChunk, error)
+}
+
+// LoadCases 每行一个json，空行和没有期望来源的行跳过
+func LoadCases(path string) ([]*Case, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	cases := make([]*Case, 0)
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 1024*1024), 10*1024*1024)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if len(text) == 0 {
+			continue
+		}
+		c := &Case{}
+		if err := json.Unmarshal([]byte(text), c); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		if len(c.Question) == 0 || len(c.ExpectedURLs)+len(c.ExpectedIDs) == 0 {
+			lev().Warnf("line %d: question or expected sources is empty, skip", line)
+			continue
+		}
+		cases = append(cases, c)
+	}
+	return cases, scanner.Err()
+}
+
+// Hit 检索到的chunk，Relevant为是否计入命中
+type Hit struct {
+	ID       string  `json:"id"`
+	URL      string  `json:"url"`
+	Distance float32 `json:"distance"`
+	Relevant bool    `json:"relevant"`
+}
+
+// CaseResult 单个问题的结果，FirstHit为第一个相关结果的排名（从1开始），0为没有命中
+type CaseResult struct {
+	Question  string   `json:"question"`
+	Expected  []string `json:"expected"`
+	Retrieved []*Hit   `json:"retrieved"`
+	FirstHit  int      `json:"first_hit"`
+	Recall    float64  `json:"recall"`
+	RR        float64  `json:"rr"`
+	NDCG      float64  `json:"ndcg"`
+	LatencyMs float64  `json:"latency_ms"`
+	Error     string   `json:"error,omitempty"`
+}
+
+// Run 依次检索每个问题，出错的问题各项指标按0计算
+func Run(cases []*Case, r Retriever, k int) []*CaseResult {
+	results := make([]*CaseResult, 0, len(cases))
+	for i, c := range cases {
+		start := time.Now()
+		chunks, err := r.Retrieve(c.Question, k)
+		latency := float64(time.Since(start).Microseconds()) / 1000
+		res := score(c, chunks, k)
+		res.LatencyMs = latency
+		if err != nil {
+			res.Error = err.Error()
+			lev().Warnf("retrieve err, question: %s, err: %s", c.Question, err)
+		}
+		results = append(results, res)
+		if (i+1)%50 == 0 {
+			lev().Printf("%d/%d questions evaluated", i+1, len(cases))
+		}
+	}
+	return results
+}
+
+// target 一个期望的来源，按id或url匹配
+type target struct {
+	id  string
+	url string
+}
+
+func (t *target) match(ch *models.SourceChunk) bool {
+	return (len(t.id) > 0 && t.id == ch.Additional.ID) || (len(t.url) > 0 && t.url == ch.URL)
+}
+
+// expectedTargets 每个期望的id和url各是一个来源，重复的只算一次
+func expectedTargets(c *Case) []*target {
+	targets := make([]*target, 0, len(c.ExpectedIDs)+len(c.ExpectedURLs))
+	seen := make(map[target]bool)
+	add := func(t target) {
+		if !seen[t] {
+			seen[t] = true
+			targets = append(targets, &t)
+		}
+	}
+	for _, id := range c.ExpectedIDs {
+		add(target{id: id})
+	}
+	for _, u := range c.ExpectedURLs {
+		add(target{url: u})
+	}
+	return targets
+}
+
+// score 每个期望的来源只算一次命中，同一个url的多个chunk只有第一个计入，recall按来源数量计算，
+// IDCG按能命中的chunk数量计算：检索到的chunk同时匹配期望的id和url时这几个来源只算一个理想的命中，
+// 没有检索到的来源无法知道是否指向同一个chunk，仍然各算一个
+func score(c *Case, chunks []*models.SourceChunk, k int) *CaseResult {
+	targets := expectedTargets(c)
+	res := &CaseResult{
+		Question:  c.Question,
+		Expected:  append(append([]string{}, c.ExpectedIDs...), c.ExpectedURLs...),
+		Retrieved: make([]*Hit, 0, k),
+	}
+	if len(chunks) > k {
+		chunks = chunks[:k]
+	}
+
+	found := make(map[*target]bool)
+	// 同一个chunk匹配的多余来源数
+	merged := 0
+	dcg := 0.0
+	for i, ch := range chunks {
+		hit := &Hit{ID: ch.Additional.ID, URL: ch.URL, Distance: ch.Additional.Distance}
+		res.Retrieved = append(res.Retrieved, hit)
+		// 同时匹配期望的id和url时两个都算找到，但这个chunk只计一次命中
+		matched := 0
+		for _, t := range targets {
+			if !found[t] && t.match(ch) {
+				found[t] = true
+				matched++
+			}
+		}
+		if matched == 0 {
+			continue
+		}
+		hit.Relevant = true
+		merged += matched - 1
+		dcg += 1 / math.Log2(float64(i+2))
+		if res.FirstHit == 0 {
+			res.FirstHit = i + 1
+			res.RR = 1 / float64(i+1)
+		}
+	}
+
+	if len(targets) == 0 {
+		return res
+	}
+	idcg := 0.0
+	for i := 0; i < len(targets)-merged && i < k; i++ {
+		idcg += 1 / math.Log2(float64(i+2))
+	}
+	res.Recall = float64(len(found)) / float64(len(targets))
+	res.NDCG = dcg / idcg
+	return res
+}
+
+// percentile 最近排名法，values需要已经排序
+func percentile(sorted []float64, p float64) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	idx := int(math.Ceil(p/100*float64(len(sorted)))) - 1
+	if idx < 0 {
+		idx = 0
+	}
+	return sorted[idx]
+}
+
+func summarize(results []*CaseResult) *Metrics {
+	m := &Metrics{Cases: len(results)}
+	if len(results) == 0 {
+		return m
+	}
+	latencies := make([]float64, 0, len(results))
+	for _, r := range results {
+		m.Recall += r.Recall
+		m.MRR += r.RR
+		m.NDCG += r.NDCG
+		if len(r.Error) > 0 {
+			m.Errors++
+		}
+		latencies = append(latencies, r.LatencyMs)
+	}
+	n := float64(len(results))
+	m.Recall /= n
+	m.MRR /= n
+	m.NDCG /= n
+
+	sort.Float64s(latencies)
+	sum := 0.0
+	for _, l := range latencies {
+		sum += l
+	}
+	m.Latency = &Latency{
+		Mean: sum / n,
+		P50:  percentile(latencies, 50),
+		P90:  percentile(latencies, 90),
+		P95:  percentile(latencies, 95),
+		P99:  percentile(latencies, 99),
+		Max:  latencies[len(latencies)-1],
+	}
+	return m
+}
@@ -0,0 +1,192 @@
+package eval
+
+import (
+	"math"
+	"reflect"
+	"testing"
+
+	"go-weaviate-deepseek/models"
+)
+
+func src(id, url string) *models.SourceChunk {
+	c := &models.SourceChunk{URL: url}
+	c.Additional.ID = id
+	return c
+}
+
+func near(a, b float64) bool {
+	return math.Abs(a-b) < 1e-9
+}
+
+func TestScore(t *testing.T) {
+	cases := []struct {
+		desc     string
+		c        *Case
+		chunks   []*models.SourceChunk
+		k        int
+		firstHit int
+		recall   float64
+		ndcg     float64
+	}{
+		{
+			desc:     "id hit at first",
+			c:        &Case{ExpectedIDs: []string{"a"}},
+			chunks:   []*models.SourceChunk{src("a", "u1"), src("b", "u2")},
+			k:        3,
+			firstHit: 1, recall: 1, ndcg: 1,
+		},
+		{
+			desc:     "url hit at second",
+			c:        &Case{ExpectedURLs: []string{"u2"}},
+			chunks:   []*models.SourceChunk{src("a", "u1"), src("b", "u2")},
+			k:        3,
+			firstHit: 2, recall: 1, ndcg: 1 / math.Log2(3),
+		},
+		{
+			desc:     "chunks of the same url count once",
+			c:        &Case{ExpectedURLs: []string{"u1", "u2"}},
+			chunks:   []*models.SourceChunk{src("a", "u1"), src("b", "u1"), src("c", "u3")},
+			k:        3,
+			firstHit: 1, recall: 0.5, ndcg: 1 / (1 + 1/math.Log2(3)),
+		},
+		{
+			desc:     "one chunk matching both an expected id and url",
+			c:        &Case{ExpectedIDs: []string{"a"}, ExpectedURLs: []string{"u1"}},
+			chunks:   []*models.SourceChunk{src("a", "u1")},
+			k:        3,
+			firstHit: 1, recall: 1, ndcg: 1,
+		},
+		{
+			desc:     "overlapping id and url after another expected chunk",
+			c:        &Case{ExpectedIDs: []string{"a", "b"}, ExpectedURLs: []string{"u1"}},
+			chunks:   []*models.SourceChunk{src("b", "u2"), src("a", "u1"), src("c", "u3")},
+			k:        3,
+			firstHit: 1, recall: 1, ndcg: 1,
+		},
+		{
+			// id和url相同的字符串是两个来源，不会互相匹配
+			desc:     "id and url do not collide",
+			c:        &Case{ExpectedIDs: []string{"x"}, ExpectedURLs: []string{"y"}},
+			chunks:   []*models.SourceChunk{src("y", "x")},
+			k:        3,
+			firstHit: 0, recall: 0, ndcg: 0,
+		},
+		{
+			desc:     "duplicated expected sources count once",
+			c:        &Case{ExpectedIDs: []string{"a", "a"}},
+			chunks:   []*models.SourceChunk{src("a", "u1")},
+			k:        3,
+			firstHit: 1, recall: 1, ndcg: 1,
+		},
+		{
+			desc:     "only top k are scored",
+			c:        &Case{ExpectedIDs: []string{"c"}},
+			chunks:   []*models.SourceChunk{src("a", ""), src("b", ""), src("c", "")},
+			k:        2,
+			firstHit: 0, recall: 0, ndcg: 0,
+		},
+		{
+			desc:     "idcg limited by k",
+			c:        &Case{ExpectedIDs: []string{"a", "b", "c"}},
+			chunks:   []*models.SourceChunk{src("a", ""), src("b", "")},
+			k:        2,
+			firstHit: 1, recall: 2.0 / 3, ndcg: 1,
+		},
+		{
+			desc:     "nothing retrieved",
+			c:        &Case{ExpectedIDs: []string{"a"}},
+			chunks:   nil,
+			k:        3,
+			firstHit: 0, recall: 0, ndcg: 0,
+		},
+	}
+	for _, c := range cases {
+		res := score(c.c, c.chunks, c.k)
+		if res.FirstHit != c.firstHit {
+			t.Errorf("%s: first hit got %d, want %d", c.desc, res.FirstHit, c.firstHit)
+		}
+		if c.firstHit > 0 && !near(res.RR, 1/float64(c.firstHit)) {
+			t.Errorf("%s: rr got %f", c.desc, res.RR)
+		}
+		if !near(res.Recall, c.recall) {
+			t.Errorf("%s: recall got %f, want %f", c.desc, res.Recall, c.recall)
+		}
+		if !near(res.NDCG, c.ndcg) {
+			t.Errorf("%s: ndcg got %f, want %f", c.desc, res.NDCG, c.ndcg)
+		}
+	}
+}
+
+func TestPercentile(t *testing.T) {
+	values := []float64{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}
+	cases := []struct {
+		p    float64
+		want float64
+	}{
+		{0, 1},
+		{10, 1},
+		{50, 5},
+		{90, 9},
+		{95, 10},
+		{100, 10},
+	}
+	for _, c := range cases {
+		if got := percentile(values, c.p); got != c.want {
+			t.Errorf("p%.0f: got %f, want %f", c.p, got, c.want)
+		}
+	}
+	if got := percentile(nil, 50); got != 0 {
+		t.Errorf("empty: got %f", got)
+	}
+	if got := percentile([]float64{3}, 99); got != 3 {
+		t.Errorf("single: got %f", got)
+	}
+}
+
+func TestNewDiff(t *testing.T) {
+	base := &Report{
+		RunAt:   "base",
+		Metrics: &Metrics{Recall: 0.5, MRR: 0.4, NDCG: 0.3, Latency: &Latency{P50: 10, P95: 20}},
+		Results: []*CaseResult{
+			{Question: "same", FirstHit: 1, RR: 1},
+			{Question: "better", FirstHit: 3, RR: 1.0 / 3},
+			{Question: "worse", FirstHit: 1, RR: 1},
+			{Question: "removed", FirstHit: 1, RR: 1},
+		},
+	}
+	cur := &Report{
+		RunAt:   "cur",
+		Metrics: &Metrics{Recall: 0.75, MRR: 0.5, NDCG: 0.25, Latency: &Latency{P50: 12, P95: 15}},
+		Results: []*CaseResult{
+			{Question: "same", FirstHit: 1, RR: 1},
+			{Question: "better", FirstHit: 1, RR: 1},
+			{Question: "worse", FirstHit: 0, RR: 0},
+			{Question: "added", FirstHit: 2, RR: 0.5},
+		},
+	}
+	d := NewDiff(base, cur)
+	if d.BaseRunAt != "base" || d.RunAt != "cur" {
+		t.Errorf("run at got %s, %s", d.BaseRunAt, d.RunAt)
+	}
+	if !near(d.Recall, 0.25) || !near(d.MRR, 0.1) || !near(d.NDCG, -0.05) {
+		t.Errorf("metrics got recall %f, mrr %f, ndcg %f", d.Recall, d.MRR, d.NDCG)
+	}
+	if d.P50 != 2 || d.P95 != -5 {
+		t.Errorf("latency got p50 %f, p95 %f", d.P50, d.P95)
+	}
+	if !reflect.DeepEqual(d.Improved, []*CaseChange{{Question: "better", FirstHit: 1, BaseFirstHit: 3}}) {
+		t.Errorf("improved got %+v", d.Improved)
+	}
+	if !reflect.DeepEqual(d.Regressed, []*CaseChange{{Question: "worse", FirstHit: 0, BaseFirstHit: 1}}) {
+		t.Errorf("regressed got %+v", d.Regressed)
+	}
+	if !reflect.DeepEqual(d.Added, []string{"added"}) || !reflect.DeepEqual(d.Removed, []string{"removed"}) {
+		t.Errorf("added got %v, removed got %v", d.Added, d.Removed)
+	}
+
+	// 没有延迟数据时延迟差为0
+	base.Metrics.Latency = nil
+	if d := NewDiff(base, cur); d.P50 != 0 || d.P95 != 0 {
+		t.Errorf("nil latency got p50 %f, p95 %f", d.P50, d.P95)
+	}
+}
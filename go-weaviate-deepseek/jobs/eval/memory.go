@@ -0,0 +1,130 @@
+package eval
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strings"
+
+	"go-weaviate-deepseek/ext/embedder"
+	"go-weaviate-deepseek/models"
+	"go-weaviate-deepseek/services"
+)
+
+// Document 内存检索使用的文档，每行一个json，和导入时一样分段
+type Document struct {
+	Title string `json:"title"`
+	URL   string `json:"url"`
+	Text  string `json:"text"`
+}
+
+type memChunk struct {
+	chunk  *models.SourceChunk
+	vector []float32
+}
+
+// MemoryStore 不依赖weaviate，chunk的id和导入到clsName集合时相同，可以和weaviate使用同一份问题集
+type MemoryStore struct {
+	embedder    embedder.Embedder
+	maxDistance float32
+	chunks      []*memChunk
+}
+
+// NewMemoryStore maxDistance 和weaviate检索时的distance一样，小于等于0时不限制
+func NewMemoryStore(clsName, corpusPath string, e embedder.Embedder, maxDistance float32) (*MemoryStore, error) {
+	f, err := os.Open(corpusPath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	s := &MemoryStore{embedder: e, maxDistance: maxDistance}
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 1024*1024), 100*1024*1024)
+	line, docs := 0, 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if len(text) == 0 {
+			continue
+		}
+		docs++
+		doc := &Document{}
+		if err := json.Unmarshal([]byte(text), doc); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		cas := services.ImportChunks(doc.Text)
+		texts := make([]string, 0, len(cas))
+		for _, ca := range cas {
+			texts = append(texts, ca.Chunk)
+		}
+		if len(texts) == 0 {
+			continue
+		}
+		vecs, err := e.EmbedBatch(texts)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		for i, ca := range cas {
+			c := &models.SourceChunk{Title: doc.Title, URL: doc.URL, Captions: ca.Chunk, MediaType: "text"}
+			c.Additional.ID = services.ChunkObjectID(clsName, doc.URL, ca.Chunk)
+			s.chunks = append(s.chunks, &memChunk{chunk: c, vector: vecs[i]})
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	lev().Printf("memory store loaded, documents: %d, chunks: %d", docs, len(s.chunks))
+	return s, nil
+}
+
+// Retrieve 遍历全部chunk，按余弦距离排序
+func (s *MemoryStore) Retrieve(question string, k int) ([]*models.SourceChunk, error) {
+	qv, err := s.embedder.Embed(question)
+	if err != nil {
+		return nil, err
+	}
+	type scored struct {
+		chunk    *models.SourceChunk
+		distance float32
+	}
+	candidates := make([]*scored, 0, len(s.chunks))
+	for _, mc := range s.chunks {
+		d := cosineDistance(qv, mc.vector)
+		if s.maxDistance > 0 && d > s.maxDistance {
+			continue
+		}
+		candidates = append(candidates, &scored{chunk: mc.chunk, distance: d})
+	}
+	sort.SliceStable(candidates, func(i, j int) bool { return candidates[i].distance < candidates[j].distance })
+	if len(candidates) > k {
+		candidates = candidates[:k]
+	}
+	res := make([]*models.SourceChunk, 0, len(candidates))
+	for _, c := range candidates {
+		chunk := *c.chunk
+		chunk.Additional.Distance = c.distance
+		res = append(res, &chunk)
+	}
+	return res, nil
+}
+
+// cosineDistance 和weaviate的cosine distance一致，范围0-2
+func cosineDistance(a, b []float32) float32 {
+	if len(a) != len(b) {
+		return 2
+	}
+	var dot, na, nb float64
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+		na += float64(a[i]) * float64(a[i])
+		nb += float64(b[i]) * float64(b[i])
+	}
+	if na == 0 || nb == 0 {
+		return 1
+	}
+	return float32(1 - dot/(math.Sqrt(na)*math.Sqrt(nb)))
+}
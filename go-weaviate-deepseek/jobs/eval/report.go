@@ -0,0 +1,182 @@
+package eval
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"time"
+
+	"go-weaviate-deepseek/ext"
+)
+
+// Latency 单位毫秒
+type Latency struct {
+	Mean float64 `json:"mean"`
+	P50  float64 `json:"p50"`
+	P90  float64 `json:"p90"`
+	P95  float64 `json:"p95"`
+	P99  float64 `json:"p99"`
+	Max  float64 `json:"max"`
+}
+
+// Metrics Recall、MRR、NDCG 都是前k个结果的平均值
+type Metrics struct {
+	Cases   int      `json:"cases"`
+	Errors  int      `json:"errors"`
+	Recall  float64  `json:"recall_at_k"`
+	MRR     float64  `json:"mrr"`
+	NDCG    float64  `json:"ndcg_at_k"`
+	Latency *Latency `json:"latency_ms"`
+}
+
+// Report 一次评估的配置、汇总指标和每个问题的结果
+type Report struct {
+	RunAt   string            `json:"run_at"`
+	Options map[string]string `json:"options"`
+	K       int               `json:"k"`
+	Metrics *Metrics          `json:"metrics"`
+	Results []*CaseResult     `json:"results"`
+}
+
+func NewReport(options map[string]string, k int, results []*CaseResult) *Report {
+	return &Report{
+		RunAt:   time.Now().Format(time.RFC3339),
+		Options: options,
+		K:       k,
+		Metrics: summarize(results),
+		Results: results,
+	}
+}
+
+func LoadReport(path string) (*Report, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	r := &Report{}
+	err = json.Unmarshal(b, r)
+	return r, err
+}
+
+func WriteJSON(path string, v interface{}) error {
+	b, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, b, 0644)
+}
+
+// CaseChange 和上次相比排名变化的问题
+type CaseChange struct {
+	Question     string `json:"question"`
+	FirstHit     int    `json:"first_hit"`
+	BaseFirstHit int    `json:"base_first_hit"`
+}
+
+// Diff 指标为本次减去上次，问题按文本对应
+type Diff struct {
+	BaseRunAt string        `json:"base_run_at"`
+	RunAt     string        `json:"run_at"`
+	Recall    float64       `json:"recall_at_k"`
+	MRR       float64       `json:"mrr"`
+	NDCG      float64       `json:"ndcg_at_k"`
+	P50       float64       `json:"latency_p50_ms"`
+	P95       float64       `json:"latency_p95_ms"`
+	Improved  []*CaseChange `json:"improved"`
+	Regressed []*CaseChange `json:"regressed"`
+	Added     []string      `json:"added"`   // 上次没有的问题
+	Removed   []string      `json:"removed"` // 本次没有的问题
+}
+
+func NewDiff(base, cur *Report) *Diff {
+	d := &Diff{
+		BaseRunAt: base.RunAt,
+		RunAt:     cur.RunAt,
+		Recall:    cur.Metrics.Recall - base.Metrics.Recall,
+		MRR:       cur.Metrics.MRR - base.Metrics.MRR,
+		NDCG:      cur.Metrics.NDCG - base.Metrics.NDCG,
+		Improved:  make([]*CaseChange, 0),
+		Regressed: make([]*CaseChange, 0),
+		Added:     make([]string, 0),
+		Removed:   make([]string, 0),
+	}
+	if base.Metrics.Latency != nil && cur.Metrics.Latency != nil {
+		d.P50 = cur.Metrics.Latency.P50 - base.Metrics.Latency.P50
+		d.P95 = cur.Metrics.Latency.P95 - base.Metrics.Latency.P95
+	}
+
+	baseResults := make(map[string]*CaseResult, len(base.Results))
+	for _, r := range base.Results {
+		baseResults[r.Question] = r
+	}
+	seen := make(map[string]bool, len(cur.Results))
+	for _, r := range cur.Results {
+		seen[r.Question] = true
+		b, ok := baseResults[r.Question]
+		if !ok {
+			d.Added = append(d.Added, r.Question)
+			continue
+		}
+		change := &CaseChange{Question: r.Question, FirstHit: r.FirstHit, BaseFirstHit: b.FirstHit}
+		if r.RR > b.RR {
+			d.Improved = append(d.Improved, change)
+		} else if r.RR < b.RR {
+			d.Regressed = append(d.Regressed, change)
+		}
+	}
+	for _, r := range base.Results {
+		if !seen[r.Question] {
+			d.Removed = append(d.Removed, r.Question)
+		}
+	}
+	sort.Slice(d.Regressed, func(i, j int) bool { return d.Regressed[i].Question < d.Regressed[j].Question })
+	sort.Slice(d.Improved, func(i, j int) bool { return d.Improved[i].Question < d.Improved[j].Question })
+	return d
+}
+
+// Print 输出汇总，diff为nil时只输出本次的指标
+func Print(w io.Writer, r *Report, d *Diff) {
+	m := r.Metrics
+	fmt.Fprintf(w, "cases: %d, errors: %d, k: %d\n", m.Cases, m.Errors, r.K)
+	fmt.Fprintf(w, "recall@%d: %.4f%s\n", r.K, m.Recall, delta(d, func(d *Diff) float64 { return d.Recall }))
+	fmt.Fprintf(w, "mrr:       %.4f%s\n", m.MRR, delta(d, func(d *Diff) float64 { return d.MRR }))
+	fmt.Fprintf(w, "ndcg@%d:   %.4f%s\n", r.K, m.NDCG, delta(d, func(d *Diff) float64 { return d.NDCG }))
+	if m.Latency != nil {
+		fmt.Fprintf(w, "latency ms: mean %.2f, p50 %.2f%s, p90 %.2f, p95 %.2f%s, p99 %.2f, max %.2f\n",
+			m.Latency.Mean, m.Latency.P50, delta(d, func(d *Diff) float64 { return d.P50 }),
+			m.Latency.P90, m.Latency.P95, delta(d, func(d *Diff) float64 { return d.P95 }),
+			m.Latency.P99, m.Latency.Max)
+	}
+	if d == nil {
+		return
+	}
+	fmt.Fprintf(w, "compared with %s: %d improved, %d regressed, %d added, %d removed\n",
+		d.BaseRunAt, len(d.Improved), len(d.Regressed), len(d.Added), len(d.Removed))
+	for _, c := range d.Regressed {
+		fmt.Fprintf(w, "  regressed: %s (rank %s -> %s)\n", oneline(c.Question), rank(c.BaseFirstHit), rank(c.FirstHit))
+	}
+}
+
+func delta(d *Diff, f func(d *Diff) float64) string {
+	if d == nil {
+		return ""
+	}
+	return fmt.Sprintf(" (%+.4f)", f(d))
+}
+
+func rank(n int) string {
+	if n == 0 {
+		return "miss"
+	}
+	return fmt.Sprint(n)
+}
+
+func oneline(s string) string {
+	rs := []rune(ext.Oneline(s))
+	if len(rs) > 60 {
+		return string(rs[:60]) + "..."
+	}
+	return string(rs)
+}
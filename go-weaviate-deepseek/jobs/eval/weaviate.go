@@ -0,0 +1,26 @@
+package eval
+
+import (
+	"context"
+
+	"go-weaviate-deepseek/ext/weaviatelib"
+	"go-weaviate-deepseek/jobs/api"
+	"go-weaviate-deepseek/models"
+)
+
+// WeaviateRetriever 和achat使用同样的检索方式，向量模型为集合配置的embedder
+type WeaviateRetriever struct {
+	ClsName string
+	Mode    string
+	Opts    weaviatelib.QueryOpts
+}
+
+func (w *WeaviateRetriever) Retrieve(question string, k int) ([]*models.SourceChunk, error) {
+	o := w.Opts
+	o.Limit = k
+	r, err := api.Retrieve(context.Background(), w.ClsName, question, w.Mode, o)
+	if err != nil {
+		return nil, err
+	}
+	return r.Chunks, nil
+}
@@ -2,7 +2,8 @@ package models
 
 type SourceChunk struct {
 	Additional struct {
-		ID string `json:"id"`
+		ID       string  `json:"id"`
+		Distance float32 `json:"distance"`
 	} `json:"_additional"`
 	Title string `json:"title"`
 	URL   string `json:"url"`
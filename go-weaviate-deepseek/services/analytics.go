@@ -0,0 +1,241 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"go-weaviate-deepseek/conf"
+	"go-weaviate-deepseek/conn"
+	"go-weaviate-deepseek/ext"
+	"go-weaviate-deepseek/ext/weaviatelib"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/spf13/cast"
+	"github.com/weaviate/weaviate/entities/models"
+)
+
+const (
+	redisAnalyticsQueriesPrefix = "analytics:queries:" // stream, 每次achat的问题
+	redisAnalyticsTopPrefix     = "analytics:top:"     // zset, 问题 => 次数
+	redisAnalyticsNoMatchPrefix = "analytics:nomatch:" // zset, 没有找到好的匹配的问题 => 次数
+	redisAnalyticsHitsPrefix    = "analytics:hits:"    // zset, chunk id => 被检索到的次数
+
+	// 每个集合的问题排行最多保留的数量
+	analyticsTopMax = 10000
+)
+
+// QueryLog achat的一次问答
+type QueryLog struct {
+	ID            string    `json:"id"`
+	ClsName       string    `json:"cls_name"`
+	Question      string    `json:"question"`
+	Query         string    `json:"query"` // 实际用于检索的文本
+	UserUUID      string    `json:"user_uuid"`
+	ChatUUID      string    `json:"chat_uuid"`
+	ChatModel     string    `json:"chat_model"`
+	RetrievalMode string    `json:"retrieval_mode"`
+	ChunkIDs      []string  `json:"chunk_ids"`
+	Distances     []float32 `json:"distances"`
+	LatencyMs     int64     `json:"latency_ms"`
+	Refused       bool      `json:"refused"`
+	NoMatch       bool      `json:"no_match"` // 没有检索到内容，或者最匹配的内容也超过 weak_match_distance
+	CreatedAt     int64     `json:"created_at"`
+}
+
+// QueryCount 问题排行
+type QueryCount struct {
+	Query string `json:"query"`
+	Count int64  `json:"count"`
+}
+
+// ChunkInfo 从未被检索到的内容
+type ChunkInfo struct {
+	ID    string `json:"id"`
+	Title string `json:"title"`
+	URL   string `json:"url"`
+}
+
+func analyticsEnabled() bool {
+	return conn.Redis != nil && conf.Settings.Analytics != nil && conf.Settings.Analytics.Enabled
+}
+
+// IsWeakMatch 没有内容或者最匹配的内容distance过大
+func IsWeakMatch(distances []float32) bool {
+	if len(distances) == 0 {
+		return true
+	}
+	best := distances[0]
+	for _, d := range distances {
+		if d < best {
+			best = d
+		}
+	}
+	w := conf.Settings.Analytics.WeakMatchDistance
+	return w > 0 && best > w
+}
+
+// normalizeQuery 合并只有大小写和空白不同的问题
+func normalizeQuery(q string) string {
+	return strings.ToLower(strings.Join(strings.Fields(q), " "))
+}
+
+// RecordQuery 保存问答记录，同时累加问题排行和chunk的命中次数
+func RecordQuery(q *QueryLog) error {
+	if !analyticsEnabled() || len(q.ClsName) == 0 {
+		return nil
+	}
+	if q.CreatedAt == 0 {
+		q.CreatedAt = time.Now().Unix()
+	}
+	ctx := context.Background()
+	chunkIDs, _ := json.Marshal(q.ChunkIDs)
+	distances, _ := json.Marshal(q.Distances)
+	normalized := normalizeQuery(q.Question)
+
+	pipe := conn.Redis.Pipeline()
+	pipe.XAdd(ctx, &redis.XAddArgs{
+		Stream: redisAnalyticsQueriesPrefix + q.ClsName,
+		MaxLen: conf.Settings.Analytics.MaxLen,
+		Approx: true,
+		Values: map[string]interface{}{
+			"question":       q.Question,
+			"query":          q.Query,
+			"user_uuid":      q.UserUUID,
+			"chat_uuid":      q.ChatUUID,
+			"chat_model":     q.ChatModel,
+			"retrieval_mode": q.RetrievalMode,
+			"chunk_ids":      chunkIDs,
+			"distances":      distances,
+			"latency_ms":     q.LatencyMs,
+			"refused":        q.Refused,
+			"no_match":       q.NoMatch,
+			"created_at":     q.CreatedAt,
+		},
+	})
+	if len(normalized) > 0 {
+		topKey := redisAnalyticsTopPrefix + q.ClsName
+		pipe.ZIncrBy(ctx, topKey, 1, normalized)
+		pipe.ZRemRangeByRank(ctx, topKey, 0, -analyticsTopMax-1)
+		if q.NoMatch || q.Refused {
+			noMatchKey := redisAnalyticsNoMatchPrefix + q.ClsName
+			pipe.ZIncrBy(ctx, noMatchKey, 1, normalized)
+			pipe.ZRemRangeByRank(ctx, noMatchKey, 0, -analyticsTopMax-1)
+		}
+	}
+	for _, id := range q.ChunkIDs {
+		pipe.ZIncrBy(ctx, redisAnalyticsHitsPrefix+q.ClsName, 1, id)
+	}
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// ListQueries 按时间倒序，before为上一页最后一条的id
+func ListQueries(clsName, before string, count int64) ([]*QueryLog, error) {
+	if conn.Redis == nil {
+		return nil, errors.New("redis is not connected")
+	}
+	end := "+"
+	if len(before) > 0 {
+		end = "(" + before
+	}
+	msgs, err := conn.Redis.XRevRangeN(context.Background(), redisAnalyticsQueriesPrefix+clsName, end, "-", count).Result()
+	if err != nil {
+		return nil, err
+	}
+	res := make([]*QueryLog, 0, len(msgs))
+	for _, m := range msgs {
+		q := &QueryLog{
+			ID:            m.ID,
+			ClsName:       clsName,
+			Question:      cast.ToString(m.Values["question"]),
+			Query:         cast.ToString(m.Values["query"]),
+			UserUUID:      cast.ToString(m.Values["user_uuid"]),
+			ChatUUID:      cast.ToString(m.Values["chat_uuid"]),
+			ChatModel:     cast.ToString(m.Values["chat_model"]),
+			RetrievalMode: cast.ToString(m.Values["retrieval_mode"]),
+			LatencyMs:     cast.ToInt64(m.Values["latency_ms"]),
+			Refused:       cast.ToBool(m.Values["refused"]),
+			NoMatch:       cast.ToBool(m.Values["no_match"]),
+			CreatedAt:     cast.ToInt64(m.Values["created_at"]),
+		}
+		_ = json.Unmarshal([]byte(cast.ToString(m.Values["chunk_ids"])), &q.ChunkIDs)
+		_ = json.Unmarshal([]byte(cast.ToString(m.Values["distances"])), &q.Distances)
+		res = append(res, q)
+	}
+	return res, nil
+}
+
+// TopQueries 次数最多的问题
+func TopQueries(clsName string, limit int64) ([]*QueryCount, error) {
+	return queryCounts(redisAnalyticsTopPrefix+clsName, limit)
+}
+
+// NoMatchQueries 没有找到好的匹配或者拒绝回答次数最多的问题
+func NoMatchQueries(clsName string, limit int64) ([]*QueryCount, error) {
+	return queryCounts(redisAnalyticsNoMatchPrefix+clsName, limit)
+}
+
+func queryCounts(key string, limit int64) ([]*QueryCount, error) {
+	if conn.Redis == nil {
+		return nil, errors.New("redis is not connected")
+	}
+	zs, err := conn.Redis.ZRevRangeWithScores(context.Background(), key, 0, limit-1).Result()
+	if err != nil {
+		return nil, err
+	}
+	res := make([]*QueryCount, 0, len(zs))
+	for _, z := range zs {
+		res = append(res, &QueryCount{Query: cast.ToString(z.Member), Count: int64(z.Score)})
+	}
+	return res, nil
+}
+
+// UnretrievedChunks 遍历集合，返回从未被检索到的内容，最多limit条
+func UnretrievedChunks(clsName string, limit int) ([]*ChunkInfo, error) {
+	if conn.Redis == nil {
+		return nil, errors.New("redis is not connected")
+	}
+	ctx := context.Background()
+	hitsKey := redisAnalyticsHitsPrefix + clsName
+	res := make([]*ChunkInfo, 0)
+	errLimit := errors.New("limit reached")
+	err := weaviatelib.Each(clsName, 200, func(objs []*models.Object) error {
+		pipe := conn.Redis.Pipeline()
+		cmds := make([]*redis.FloatCmd, 0, len(objs))
+		for _, o := range objs {
+			cmds = append(cmds, pipe.ZScore(ctx, hitsKey, o.ID.String()))
+		}
+		_, err := pipe.Exec(ctx)
+		if err != nil && !errors.Is(err, redis.Nil) {
+			return err
+		}
+		for i, o := range objs {
+			if !errors.Is(cmds[i].Err(), redis.Nil) {
+				continue
+			}
+			props := ext.M{}
+			if m, ok := o.Properties.(map[string]interface{}); ok {
+				props = m
+			}
+			// 假设问题检索时合并到原chunk，命中记录在原chunk上
+			if props["media_type"] == weaviatelib.MediaTypeQuestion {
+				continue
+			}
+			res = append(res, &ChunkInfo{
+				ID:    o.ID.String(),
+				Title: cast.ToString(props["title"]),
+				URL:   cast.ToString(props["url"]),
+			})
+			if len(res) >= limit {
+				return errLimit
+			}
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, errLimit) {
+		return nil, err
+	}
+	return res, nil
+}
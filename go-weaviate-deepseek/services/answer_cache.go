@@ -0,0 +1,137 @@
+package services
+
+import (
+	"context"
+	"go-weaviate-deepseek/conf"
+	"go-weaviate-deepseek/conn"
+	"go-weaviate-deepseek/ext"
+	"go-weaviate-deepseek/ext/weaviatelib"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/spf13/cast"
+)
+
+const (
+	// 集合的缓存版本，导入新内容或修改项目配置后加1，之前的缓存全部失效
+	redisAnswerCacheGenPrefix = "answer_cache:gen:"
+	// 保存缓存时最多每小时清理一次过期的缓存
+	answerCachePurgeInterval = time.Hour
+)
+
+// 每个集合上次清理过期缓存的时间
+var answerCachePurgedAt sync.Map
+
+// CachedAnswer 语义缓存中的回答，DBSource为回答时检索到的chunks的json
+type CachedAnswer struct {
+	ID        string
+	Question  string
+	Answer    string
+	SourceIDs []string
+	DBSource  string
+	ChatModel string
+	Distance  float32
+	Lang      string
+}
+
+func AnswerCacheEnabled() bool {
+	return conf.Settings.AnswerCache != nil && conf.Settings.AnswerCache.Enabled
+}
+
+func answerCacheGeneration(clsName string) int64 {
+	if conn.Redis == nil {
+		return 0
+	}
+	gen, _ := conn.Redis.Get(context.Background(), redisAnswerCacheGenPrefix+clsName).Int64()
+	return gen
+}
+
+// BumpAnswerCache 集合的内容或项目配置变化后调用，之前的缓存不再命中，等TTL过期后删除
+func BumpAnswerCache(clsName string) {
+	if conn.Redis == nil || !AnswerCacheEnabled() {
+		return
+	}
+	err := conn.Redis.Incr(context.Background(), redisAnswerCacheGenPrefix+clsName).Err()
+	if err != nil {
+		l().Warnf("bump answer cache generation err, cls_name: %s, err: %s", clsName, err)
+	}
+}
+
+// LookupAnswer 返回最相似的缓存和问题的向量，没有命中时缓存为nil，向量用于之后保存
+func LookupAnswer(clsName, question, lang string) (*CachedAnswer, []float32, error) {
+	vector, err := weaviatelib.VectorizerFor(clsName)(question)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := weaviatelib.EnsureAnswerCache(clsName); err != nil {
+		return nil, vector, err
+	}
+	ac := conf.Settings.AnswerCache
+	row, err := weaviatelib.FindCachedAnswer(clsName, vector, 1-ac.Similarity, lang,
+		answerCacheGeneration(clsName), time.Now().Unix())
+	if err != nil || row == nil {
+		return nil, vector, err
+	}
+	doc := ext.M(row)
+	additional, _ := doc["_additional"].(map[string]interface{})
+	a := &CachedAnswer{
+		ID:        cast.ToString(additional["id"]),
+		Question:  cast.ToString(doc["question"]),
+		Answer:    cast.ToString(doc["answer"]),
+		SourceIDs: cast.ToStringSlice(doc["source_ids"]),
+		DBSource:  cast.ToString(doc["db_source"]),
+		ChatModel: cast.ToString(doc["chat_model"]),
+		Distance:  cast.ToFloat32(additional["distance"]),
+		Lang:      lang,
+	}
+	// 来源被删除时缓存失效
+	for _, id := range a.SourceIDs {
+		if !weaviatelib.IsExists(clsName, id) {
+			l().Printf("cached answer source removed, cls_name: %s, chunk: %s", clsName, id)
+			_ = weaviatelib.DeleteByID(weaviatelib.AnswerCacheClsName(clsName), a.ID)
+			return nil, vector, nil
+		}
+	}
+	return a, vector, nil
+}
+
+// SaveAnswer 保存到语义缓存，vector为 LookupAnswer 返回的问题向量
+func SaveAnswer(clsName string, vector []float32, a *CachedAnswer) error {
+	if err := weaviatelib.EnsureAnswerCache(clsName); err != nil {
+		return err
+	}
+	now := time.Now()
+	ttl := time.Duration(conf.Settings.AnswerCache.TTLHours) * time.Hour
+	_, err := weaviatelib.Create(weaviatelib.AnswerCacheClsName(clsName), uuid.NewString(), map[string]interface{}{
+		"question":   a.Question,
+		"answer":     a.Answer,
+		"source_ids": a.SourceIDs,
+		"db_source":  a.DBSource,
+		"chat_model": a.ChatModel,
+		"lang":       a.Lang,
+		"generation": answerCacheGeneration(clsName),
+		"expire_at":  now.Add(ttl).Unix(),
+		"created_at": now.Unix(),
+	}, vector)
+	if err != nil {
+		return err
+	}
+	if last, ok := answerCachePurgedAt.Load(clsName); !ok || now.Sub(last.(time.Time)) > answerCachePurgeInterval {
+		answerCachePurgedAt.Store(clsName, now)
+		if err := weaviatelib.DeleteExpiredAnswers(clsName, now.Unix()); err != nil {
+			l().Warnf("delete expired answers err, cls_name: %s, err: %s", clsName, err)
+		}
+	}
+	return nil
+}
+
+// InvalidateAnswers 删除引用了chunk的缓存
+func InvalidateAnswers(clsName, chunkID string) {
+	if !AnswerCacheEnabled() {
+		return
+	}
+	if err := weaviatelib.Clear(weaviatelib.AnswerCacheClsName(clsName), "source_ids", chunkID); err != nil {
+		l().Warnf("invalidate answer cache err, cls_name: %s, chunk: %s, err: %s", clsName, chunkID, err)
+	}
+}
@@ -0,0 +1,288 @@
+package services
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"go-weaviate-deepseek/ext"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"gopkg.in/resty.v1"
+)
+
+// 防止zip炸弹，限制文件数量和解压后的大小
+const (
+	maxArchiveSize       = 200 << 20 // 压缩包本身大小
+	maxArchiveEntries    = 1000
+	maxArchiveEntrySize  = 50 << 20
+	maxArchiveUnpackSize = 500 << 20
+)
+
+var (
+	archiveTikaExts  = []string{".pdf", ".doc", ".docx", ".xls", ".xlsx", ".ppt", ".pptx", ".odt", ".ods", ".odp", ".rtf", ".epub", ".html", ".htm"}
+	archiveImageExts = []string{".png", ".jpg", ".jpeg", ".bmp", ".tif", ".tiff", ".gif", ".webp"}
+	archivePlainExts = []string{".md", ".markdown", ".txt", ".csv"}
+
+	errArchiveFileLarge  = fmt.Errorf("archive is larger than %d bytes", maxArchiveSize)
+	errArchiveTooLarge   = errors.New("archive exceeds the uncompressed size limit")
+	errArchiveTooMany    = fmt.Errorf("archive has more than %d entries", maxArchiveEntries)
+	errArchiveEntryLarge = fmt.Errorf("entry exceeds %d bytes", maxArchiveEntrySize)
+)
+
+// archiveEntry 解压到临时文件的一个条目
+type archiveEntry struct {
+	Name    string // 压缩包内路径
+	TmpPath string
+	Size    int64
+}
+
+// handleArchive
+//
+//	data: {"url": "https://eggman.tv/docs.zip", "name": "docs.zip"}
+//	  or: {"base64": "xxxx", "name": "docs.tar.gz"}
+func (i *ImportSource) handleArchive(urlStr, b64, name string) error {
+	if len(name) == 0 {
+		name = path.Base(urlStr)
+	}
+
+	src, err := os.CreateTemp("/tmp", "gwdarchive-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(src.Name())
+	defer src.Close()
+
+	if len(b64) > 0 {
+		err = copyLimited(src, base64.NewDecoder(base64.StdEncoding, strings.NewReader(b64)), maxArchiveSize)
+	} else if len(urlStr) > 0 {
+		lim().Printf("start downloading archive: %s, dst: %s", urlStr, src.Name())
+		err = downloadArchive(urlStr, src)
+	} else {
+		err = errors.New("archive url or base64 is required")
+	}
+	if err != nil {
+		return err
+	}
+
+	tmpDir, err := os.MkdirTemp("/tmp", "gwdarchive-x-*")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmpDir)
+
+	entries, err := unpackArchive(src.Name(), tmpDir)
+	if err != nil {
+		return err
+	}
+	lim().Printf("archive unpacked, name: %s, entries: %d", name, len(entries))
+
+	for _, e := range entries {
+		child := &ImportJobChild{
+			Path:      e.Name,
+			MediaType: archiveMediaType(e.Name),
+			Status:    JobStatusDone,
+		}
+		txt, err := readArchiveEntry(e)
+		if err == nil {
+			child.Length = len([]rune(txt))
+			err = i.handleText(txt, ext.M{
+				"title":      path.Base(e.Name),
+				"url":        name + "/" + e.Name,
+				"media_type": child.MediaType,
+			})
+		}
+		if err != nil {
+			lim().Warnf("import archive entry err, entry: %s, err: %s", e.Name, err)
+			child.Status = JobStatusError
+			child.Error = err.Error()
+		}
+		i.job.AddChild(child)
+	}
+	return nil
+}
+
+// downloadArchive 边下载边计数，超过 maxArchiveSize 时立即停止，不会先把整个文件写到磁盘
+func downloadArchive(urlStr string, dst io.Writer) error {
+	rsp, err := resty.New().
+		SetTimeout(5 * time.Minute).
+		R().
+		SetDoNotParseResponse(true).
+		Get(urlStr)
+	if err != nil {
+		return err
+	}
+	body := rsp.RawBody()
+	defer body.Close()
+	if rsp.IsError() {
+		return fmt.Errorf("download archive err, status: %d", rsp.StatusCode())
+	}
+	return copyLimited(dst, body, maxArchiveSize)
+}
+
+// copyLimited 最多读取 limit+1 个字节，超过limit时返回错误
+func copyLimited(dst io.Writer, r io.Reader, limit int64) error {
+	n, err := io.Copy(dst, io.LimitReader(r, limit+1))
+	if err != nil {
+		return err
+	}
+	if n > limit {
+		return errArchiveFileLarge
+	}
+	return nil
+}
+
+// unpackArchive 根据文件头判断是zip还是tar(.gz)，解压到dir，只保留支持的文件类型
+func unpackArchive(filep, dir string) ([]*archiveEntry, error) {
+	f, err := os.Open(filep)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	br := bufio.NewReader(f)
+	magic, _ := br.Peek(4)
+	switch {
+	case bytes.HasPrefix(magic, []byte("PK\x03\x04")):
+		return unpackZip(filep, dir)
+	case bytes.HasPrefix(magic, []byte{0x1f, 0x8b}):
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, err
+		}
+		defer gz.Close()
+		return unpackTar(gz, dir)
+	default:
+		return unpackTar(br, dir)
+	}
+}
+
+func unpackZip(filep, dir string) ([]*archiveEntry, error) {
+	zr, err := zip.OpenReader(filep)
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+
+	res := make([]*archiveEntry, 0)
+	var total int64
+	for _, zf := range zr.File {
+		if zf.FileInfo().IsDir() || !isSupportedArchiveEntry(zf.Name) {
+			continue
+		}
+		if len(res) >= maxArchiveEntries {
+			return nil, errArchiveTooMany
+		}
+		rc, err := zf.Open()
+		if err != nil {
+			return nil, err
+		}
+		e, err := writeArchiveEntry(zf.Name, rc, dir, maxArchiveUnpackSize-total)
+		rc.Close()
+		if err != nil {
+			return nil, err
+		}
+		total += e.Size
+		res = append(res, e)
+	}
+	return res, nil
+}
+
+func unpackTar(r io.Reader, dir string) ([]*archiveEntry, error) {
+	tr := tar.NewReader(r)
+	res := make([]*archiveEntry, 0)
+	var total int64
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if hdr.Typeflag != tar.TypeReg || !isSupportedArchiveEntry(hdr.Name) {
+			continue
+		}
+		if len(res) >= maxArchiveEntries {
+			return nil, errArchiveTooMany
+		}
+		e, err := writeArchiveEntry(hdr.Name, tr, dir, maxArchiveUnpackSize-total)
+		if err != nil {
+			return nil, err
+		}
+		total += e.Size
+		res = append(res, e)
+	}
+	return res, nil
+}
+
+// writeArchiveEntry 不信任压缩包头里的大小，按实际写入的字节数限制
+func writeArchiveEntry(name string, r io.Reader, dir string, remain int64) (*archiveEntry, error) {
+	limit := int64(maxArchiveEntrySize)
+	if remain < limit {
+		limit = remain
+	}
+	// 不使用压缩包内的路径作为文件名，避免路径穿越
+	dst, err := os.CreateTemp(dir, "entry-*"+strings.ToLower(path.Ext(name)))
+	if err != nil {
+		return nil, err
+	}
+	defer dst.Close()
+
+	n, err := io.Copy(dst, io.LimitReader(r, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if n > limit {
+		if limit < maxArchiveEntrySize {
+			return nil, errArchiveTooLarge
+		}
+		return nil, fmt.Errorf("%s: %w", name, errArchiveEntryLarge)
+	}
+	return &archiveEntry{Name: name, TmpPath: dst.Name(), Size: n}, nil
+}
+
+// readArchiveEntry 按扩展名选择 tika / tesseract / 纯文本读取
+func readArchiveEntry(e *archiveEntry) (string, error) {
+	ex := strings.ToLower(path.Ext(e.Name))
+	switch {
+	case inExts(ex, archiveTikaExts):
+		return ReadByTika(e.TmpPath)
+	case inExts(ex, archiveImageExts):
+		txt, err := ExtractTextFromImage(e.TmpPath, false)
+		return ext.Oneline(txt), err
+	default:
+		b, err := os.ReadFile(e.TmpPath)
+		return string(b), err
+	}
+}
+
+func isSupportedArchiveEntry(name string) bool {
+	base := path.Base(name)
+	if strings.HasPrefix(base, ".") || strings.HasPrefix(name, "__MACOSX/") {
+		return false
+	}
+	ex := strings.ToLower(path.Ext(name))
+	return inExts(ex, archiveTikaExts) || inExts(ex, archiveImageExts) || inExts(ex, archivePlainExts)
+}
+
+func archiveMediaType(name string) string {
+	return strings.TrimPrefix(strings.ToLower(path.Ext(name)), ".")
+}
+
+func inExts(ex string, exts []string) bool {
+	for _, e := range exts {
+		if e == ex {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,169 @@
+package services
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestIsSupportedArchiveEntry(t *testing.T) {
+	cases := []struct {
+		name string
+		want bool
+	}{
+		{"docs/readme.md", true},
+		{"docs/Manual.PDF", true},
+		{"img/scan.jpeg", true},
+		{"data.csv", true},
+		{"bin/app.exe", false},
+		{"docs/.hidden.md", false},
+		{"__MACOSX/docs/readme.md", false},
+		{"noext", false},
+	}
+	for _, c := range cases {
+		if got := isSupportedArchiveEntry(c.name); got != c.want {
+			t.Errorf("isSupportedArchiveEntry(%q) = %v, want %v", c.name, got, c.want)
+		}
+	}
+}
+
+func TestCopyLimited(t *testing.T) {
+	cases := []struct {
+		size    int
+		limit   int64
+		wantErr error
+	}{
+		{size: 10, limit: 10},
+		{size: 0, limit: 10},
+		{size: 11, limit: 10, wantErr: errArchiveFileLarge},
+		{size: 1000, limit: 10, wantErr: errArchiveFileLarge},
+	}
+	for _, c := range cases {
+		var dst bytes.Buffer
+		err := copyLimited(&dst, bytes.NewReader(make([]byte, c.size)), c.limit)
+		if !errors.Is(err, c.wantErr) {
+			t.Errorf("size %d, limit %d: err = %v, want %v", c.size, c.limit, err, c.wantErr)
+		}
+		// 超过限制时最多只读取 limit+1 个字节
+		if int64(dst.Len()) > c.limit+1 {
+			t.Errorf("size %d, limit %d: copied %d bytes", c.size, c.limit, dst.Len())
+		}
+	}
+}
+
+func TestWriteArchiveEntry(t *testing.T) {
+	cases := []struct {
+		name    string
+		size    int
+		remain  int64
+		wantErr error
+	}{
+		{name: "a.txt", size: 100, remain: 1000},
+		{name: "b.txt", size: 100, remain: 100},
+		{name: "c.txt", size: 101, remain: 100, wantErr: errArchiveTooLarge},
+		{name: "../../etc/passwd.txt", size: 10, remain: 1000},
+	}
+	for _, c := range cases {
+		dir := t.TempDir()
+		e, err := writeArchiveEntry(c.name, bytes.NewReader(make([]byte, c.size)), dir, c.remain)
+		if !errors.Is(err, c.wantErr) {
+			t.Errorf("%s: err = %v, want %v", c.name, err, c.wantErr)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if e.Size != int64(c.size) || e.Name != c.name {
+			t.Errorf("%s: got entry %+v", c.name, e)
+		}
+		// 临时文件总是在dir中，不使用压缩包内的路径
+		if filepath.Dir(e.TmpPath) != dir {
+			t.Errorf("%s: tmp file %s is outside %s", c.name, e.TmpPath, dir)
+		}
+	}
+}
+
+func tarArchive(t *testing.T, files map[string]int) []byte {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for name, size := range files {
+		if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(size), Typeflag: tar.TypeReg}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write(make([]byte, size)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestUnpackTarLimits(t *testing.T) {
+	tooMany := make(map[string]int)
+	for i := 0; i <= maxArchiveEntries; i++ {
+		tooMany[fmt.Sprintf("doc%d.txt", i)] = 1
+	}
+	cases := []struct {
+		desc        string
+		files       map[string]int
+		wantEntries int
+		wantErr     error
+	}{
+		{desc: "supported only", files: map[string]int{"a.md": 5, "b.exe": 5, ".c.txt": 5}, wantEntries: 1},
+		{desc: "at entry limit", files: map[string]int{"a.txt": 1, "b.txt": 1}, wantEntries: 2},
+		{desc: "too many entries", files: tooMany, wantErr: errArchiveTooMany},
+	}
+	for _, c := range cases {
+		entries, err := unpackTar(bytes.NewReader(tarArchive(t, c.files)), t.TempDir())
+		if !errors.Is(err, c.wantErr) {
+			t.Errorf("%s: err = %v, want %v", c.desc, err, c.wantErr)
+			continue
+		}
+		if err == nil && len(entries) != c.wantEntries {
+			t.Errorf("%s: entries = %d, want %d", c.desc, len(entries), c.wantEntries)
+		}
+	}
+}
+
+func TestUnpackArchiveZip(t *testing.T) {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for name, content := range map[string]string{
+		"docs/a.md":          "# hello",
+		"docs/b.bin":         "skip",
+		"__MACOSX/docs/a.md": "skip",
+	} {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, _ = w.Write([]byte(content))
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	src := filepath.Join(t.TempDir(), "docs.zip")
+	if err := os.WriteFile(src, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := unpackArchive(src, t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Name != "docs/a.md" {
+		t.Fatalf("entries = %+v", entries)
+	}
+	b, _ := os.ReadFile(entries[0].TmpPath)
+	if !strings.Contains(string(b), "hello") {
+		t.Errorf("entry content = %q", string(b))
+	}
+}
@@ -0,0 +1,121 @@
+package services
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"go-weaviate-deepseek/conf"
+	"go-weaviate-deepseek/ext"
+	"go-weaviate-deepseek/ext/llmhttp"
+	"net/http"
+	"strings"
+
+	"github.com/tidwall/gjson"
+)
+
+const captionPrompt = "请详细描述这张图片的内容，包括其中的物体、场景、图表含义和可见的文字。使用中文回答，不要超过300字。"
+
+// ImageCaptioner 为图片生成文字描述，没有文字的照片、图表也能被检索到
+type ImageCaptioner interface {
+	Caption(ctx context.Context, b64 string) (string, error)
+}
+
+// VisionCaptioner 调用OpenAI兼容接口的视觉模型，Model为模型注册表中的名称，为空时使用 vision_model
+type VisionCaptioner struct {
+	Model  string
+	Prompt string
+}
+
+func (vc *VisionCaptioner) Caption(ctx context.Context, b64 string) (string, error) {
+	name := vc.Model
+	if len(name) == 0 {
+		name = conf.Settings.VisionModel
+	}
+	m := conf.Settings.GetModel(name)
+	if m == nil {
+		return "", fmt.Errorf("vision model %s is not configured", name)
+	}
+	p := conf.Settings.GetProvider(m.Provider)
+	if p == nil {
+		return "", fmt.Errorf("provider %s of vision model %s is not configured", m.Provider, name)
+	}
+
+	dataURL, err := toImageDataURL(b64)
+	if err != nil {
+		return "", err
+	}
+	prompt := vc.Prompt
+	if len(prompt) == 0 {
+		prompt = captionPrompt
+	}
+
+	requestBody := map[string]interface{}{
+		"model":      m.LegalName,
+		"max_tokens": m.MaxTokens,
+		"messages": []map[string]interface{}{
+			{
+				"role": "user",
+				"content": []map[string]interface{}{
+					{"type": "image_url", "image_url": map[string]string{"url": dataURL}},
+					{"type": "text", "text": prompt},
+				},
+			},
+		},
+	}
+	resp, err := llmhttp.Default.Post(ctx, p.BaseURL+"/chat/completions", p.Key(), requestBody)
+	if err != nil {
+		return "", err
+	}
+
+	bodyDoc := gjson.ParseBytes(resp.Body())
+	RecordCallerUsage(ctx, "", m.Name, int(bodyDoc.Get("usage.prompt_tokens").Int()), int(bodyDoc.Get("usage.completion_tokens").Int()))
+	return strings.TrimSpace(bodyDoc.Get("choices.0.message.content").String()), nil
+}
+
+// StubCaptioner 测试时替换 Captioner 使用
+type StubCaptioner struct {
+	Text string
+	Err  error
+}
+
+func (sc *StubCaptioner) Caption(ctx context.Context, b64 string) (string, error) {
+	return sc.Text, sc.Err
+}
+
+// Captioner 图片导入时 data 中 "caption": true 才会调用
+var Captioner ImageCaptioner = &VisionCaptioner{}
+
+// captionAndOCR 视觉模型的描述和OCR文字合并，描述生成失败时只使用OCR文字，用量按ctx中的调用方统计
+func captionAndOCR(ctx context.Context, b64 string, caption bool) (string, error) {
+	ocr, err := ExtractTextFromImage(b64, true)
+	if err != nil {
+		return "", err
+	}
+	ocr = ext.Oneline(ocr)
+	if !caption || Captioner == nil {
+		return ocr, nil
+	}
+
+	desp, err := Captioner.Caption(ctx, b64)
+	if err != nil {
+		l().Warnf("caption image err: %s", err)
+		return ocr, nil
+	}
+	return strings.TrimSpace(ext.Oneline(desp) + " " + ocr), nil
+}
+
+func toImageDataURL(b64 string) (string, error) {
+	if strings.HasPrefix(b64, "data:") {
+		return b64, nil
+	}
+	b, err := base64.StdEncoding.DecodeString(b64)
+	if err != nil {
+		return "", err
+	}
+	mimeType := http.DetectContentType(b)
+	if !strings.HasPrefix(mimeType, "image/") {
+		return "", errors.New("invalid image data")
+	}
+	return "data:" + mimeType + ";base64," + b64, nil
+}
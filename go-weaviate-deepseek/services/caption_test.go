@@ -0,0 +1,95 @@
+package services
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"go-weaviate-deepseek/conf"
+)
+
+// 1x1 png
+var testPNG = base64.StdEncoding.EncodeToString([]byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR\x00\x00\x00\x01\x00\x00\x00\x01\x08\x06\x00\x00\x00\x1f\x15\xc4\x89\x00\x00\x00\rIDATx\x9cc\xf8\x0f\x00\x00\x01\x01\x00\x05\x18\xd8N\x00\x00\x00\x00IEND\xaeB`\x82"))
+
+func TestCaptionAndOCR(t *testing.T) {
+	orig := Captioner
+	defer func() { Captioner = orig }()
+
+	cases := []struct {
+		desc    string
+		caption bool
+		stub    *StubCaptioner
+		want    string
+	}{
+		{desc: "caption disabled", caption: false, stub: &StubCaptioner{Text: "一只猫"}, want: ""},
+		{desc: "caption", caption: true, stub: &StubCaptioner{Text: "一只猫\n在沙发上"}, want: "一只猫在沙发上"},
+		{desc: "caption err falls back to ocr", caption: true, stub: &StubCaptioner{Err: errors.New("timeout")}, want: ""},
+	}
+	for _, c := range cases {
+		Captioner = c.stub
+		got, err := captionAndOCR(context.Background(), testPNG, c.caption)
+		if err != nil {
+			t.Fatalf("%s: %s", c.desc, err)
+		}
+		// 测试环境可能没有tesseract，只比较描述部分
+		if !strings.HasPrefix(got, c.want) {
+			t.Errorf("%s: got %q, want prefix %q", c.desc, got, c.want)
+		}
+		if len(c.want) == 0 && len(c.stub.Text) > 0 && strings.Contains(got, c.stub.Text) {
+			t.Errorf("%s: caption should not be used, got %q", c.desc, got)
+		}
+	}
+}
+
+func TestVisionCaptionerUsesModelRegistry(t *testing.T) {
+	var body map[string]interface{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/chat/completions" || r.Header.Get("Authorization") != "Bearer test-key" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		_, _ = w.Write([]byte(`{"choices":[{"message":{"content":" 一张图表 "}}],"usage":{"prompt_tokens":800,"completion_tokens":50}}`))
+	}))
+	defer srv.Close()
+
+	orig := conf.Settings
+	defer func() { conf.Settings = orig }()
+	conf.Settings = &conf.SettingsConf{
+		Providers:   []*conf.ProviderConf{{Name: "test", BaseURL: srv.URL, APIKey: "test-key"}},
+		Models:      []*conf.ModelConf{{Name: "vision", Provider: "test", LegalName: "vl-test", MaxTokens: 500}},
+		VisionModel: "vision",
+	}
+
+	useRedis(t)
+	ctx := WithUsageCaller(context.Background(), &UsageCaller{Kind: UsageKindImport, UserUUID: "u1"})
+	got, err := (&VisionCaptioner{}).Caption(ctx, testPNG)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != "一张图表" {
+		t.Errorf("caption = %q", got)
+	}
+	if body["model"] != "vl-test" || body["max_tokens"] != float64(500) {
+		t.Errorf("request body = %v", body)
+	}
+	// 用量按调用方统计
+	u, err := GetUsage(UsageDimUser, "u1", UsagePeriodDay, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u["total_tokens"] != int64(850) {
+		t.Errorf("usage = %v", u)
+	}
+
+	_, err = (&VisionCaptioner{Model: "missing"}).Caption(context.Background(), testPNG)
+	if err == nil {
+		t.Error("expected err for missing model")
+	}
+}
@@ -2,9 +2,11 @@ package services
 
 import (
 	"go-weaviate-deepseek/ext"
+	"go-weaviate-deepseek/ext/embedder"
 	"go-weaviate-deepseek/ext/weaviatelib"
 	"regexp"
 	"strings"
+	"sync"
 
 	"github.com/google/uuid"
 )
@@ -14,16 +16,24 @@ type ChunkAttr struct {
 	ChunkTokens int       `json:"chunk_tokens"`
 	ChunkLength int       `json:"chunk_length"`
 	TextVector  []float32 `json:"text_vector"`
+	Lang        string    `json:"lang"`
 }
 
+const (
+	// 单次embedding请求的文本数量和同时进行的请求数
+	embedBatchSize   = 10
+	embedConcurrency = 4
+)
+
 const (
 	CHUNK_SIZE          = 500
 	CHUNK_DELIMITTER_CN = "。"
 	CHUNK_DELIMITTER_EN = "."
 )
 
-var RE_CHUNK_SPLIT_DELIMITTER = regexp.MustCompile(`\.\s|。`)
-var RE_CHUNK_SPLIT_COMMA = regexp.MustCompile(`\,|，`)
+// 句号/问号/感叹号，包括中日文全角和半角标点，韩文使用英文标点
+var RE_CHUNK_SPLIT_DELIMITTER = regexp.MustCompile(`[\.!?]\s|[。！？｡]|؟\s?`)
+var RE_CHUNK_SPLIT_COMMA = regexp.MustCompile(`\,|，|、|､|،`)
 var RE_CHUNK_SPACE = regexp.MustCompile(`\s+`)
 var RE_CHUNK_NEWLINE = regexp.MustCompile(`(\n\s*)+`)
 
@@ -57,7 +67,8 @@ func subChunkSplit(splits []string, chunkSize int, reg *regexp.Regexp, res []str
 
 func ChunkSplit(text string, chunkSize int) []*ChunkAttr {
 	content := RE_CHUNK_SPACE.ReplaceAllString(text, " ")
-	isChinese := ext.HasChinese(content)
+	lang := ext.DetectLang(content)
+	delimitter := ext.SentenceDelimiter(lang)
 
 	chunks := make([]*ChunkAttr, 0)
 
@@ -77,9 +88,10 @@ func ChunkSplit(text string, chunkSize int) []*ChunkAttr {
 			if chunkTextTokensLength+sentenceTokensLength > chunkSize {
 				if chunkTextTokensLength > 0 {
 					chunks = append(chunks, &ChunkAttr{
-						Chunk:       chunkText,
+						Chunk:       strings.TrimSpace(chunkText),
 						ChunkTokens: chunkTextTokensLength,
 						ChunkLength: len(chunkText),
+						Lang:        chunkLang(chunkText, lang),
 					})
 				}
 				chunkText = ""
@@ -89,11 +101,11 @@ func ChunkSplit(text string, chunkSize int) []*ChunkAttr {
 				if strings.HasSuffix(sentence, CHUNK_DELIMITTER_CN) || strings.HasSuffix(sentence, CHUNK_DELIMITTER_EN) {
 					chunkText += sentence
 				} else {
-					if isChinese {
-						chunkText += sentence + CHUNK_DELIMITTER_CN
-					} else {
-						chunkText += sentence + CHUNK_DELIMITTER_EN
-					}
+					chunkText += sentence + delimitter
+				}
+				// 英文等使用空格分词的语言，句子之间需要空格
+				if delimitter == CHUNK_DELIMITTER_EN {
+					chunkText += " "
 				}
 			}
 		}
@@ -103,6 +115,7 @@ func ChunkSplit(text string, chunkSize int) []*ChunkAttr {
 				Chunk:       strings.TrimSpace(chunkText),
 				ChunkTokens: chunkTextTokensLength,
 				ChunkLength: len(chunkText),
+				Lang:        chunkLang(chunkText, lang),
 			})
 		}
 	} else {
@@ -111,6 +124,7 @@ func ChunkSplit(text string, chunkSize int) []*ChunkAttr {
 				Chunk:       strings.TrimSpace(content),
 				ChunkTokens: contentTokensLength,
 				ChunkLength: len(text),
+				Lang:        lang,
 			})
 		}
 	}
@@ -118,8 +132,16 @@ func ChunkSplit(text string, chunkSize int) []*ChunkAttr {
 	return chunks
 }
 
-func (ca *ChunkAttr) CalVector() error {
-	textVector, err := weaviatelib.VectorizerFunc(ca.Chunk)
+// chunkLang chunk太短无法判断时使用整个文档的语言
+func chunkLang(chunk, docLang string) string {
+	if lang := ext.DetectLang(chunk); len(lang) > 0 {
+		return lang
+	}
+	return docLang
+}
+
+func (ca *ChunkAttr) CalVector(clsName string) error {
+	textVector, err := weaviatelib.VectorizerFor(clsName)(ca.Chunk)
 	if err != nil {
 		return err
 	}
@@ -127,16 +149,85 @@ func (ca *ChunkAttr) CalVector() error {
 	return nil
 }
 
-func (ca *ChunkAttr) Save(clsName string, addiAttrs ext.M) error {
+// CalVectors 按embedBatchSize分组批量计算向量，最多embedConcurrency个请求同时进行
+func CalVectors(clsName string, chunks []*ChunkAttr) error {
+	e, err := embedder.ForCollection(clsName)
+	if err != nil {
+		return err
+	}
+
+	var wg sync.WaitGroup
+	var firstErr error
+	var errLock sync.Mutex
+	sem := make(chan struct{}, embedConcurrency)
+	for start := 0; start < len(chunks); start += embedBatchSize {
+		end := start + embedBatchSize
+		if end > len(chunks) {
+			end = len(chunks)
+		}
+		batch := chunks[start:end]
+
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			texts := make([]string, len(batch))
+			for i, ca := range batch {
+				texts[i] = ca.Chunk
+			}
+			vecs, err := e.EmbedBatch(texts)
+			if err != nil {
+				errLock.Lock()
+				if firstErr == nil {
+					firstErr = err
+				}
+				errLock.Unlock()
+				return
+			}
+			for i, ca := range batch {
+				ca.TextVector = vecs[i]
+			}
+		}()
+	}
+	wg.Wait()
+	return firstErr
+}
+
+// BatchObject 用于 weaviatelib.BatchCreate
+func (ca *ChunkAttr) BatchObject(id string, addiAttrs ext.M) *weaviatelib.BatchObject {
+	attrs := ext.MergeM(ext.M{"captions": ca.Chunk}, addiAttrs)
+	if len(ca.Lang) > 0 {
+		attrs["lang"] = ca.Lang
+	}
+	return &weaviatelib.BatchObject{
+		ID:         id,
+		Properties: attrs,
+		Vector:     ca.TextVector,
+	}
+}
+
+// ChunkObjectID 由集合、来源和内容决定，同一个chunk重复导入时id相同
+func ChunkObjectID(clsName, source, chunk string) string {
+	return uuid.NewSHA1(uuid.NameSpaceOID, []byte(clsName+"\n"+source+"\n"+chunk)).String()
+}
+
+// Save return the id of the created object
+func (ca *ChunkAttr) Save(clsName string, addiAttrs ext.M) (string, error) {
 	id := uuid.NewString()
 	text := ca.Chunk
 	textVector := ca.TextVector
 	attrs := ext.MergeM(ext.M{"captions": text}, addiAttrs)
+	if len(ca.Lang) > 0 {
+		attrs["lang"] = ca.Lang
+	}
 	_, err := weaviatelib.Create(clsName, id, attrs, textVector)
 	if err != nil {
-		return err
+		return "", err
 		// l().Println("ToVector save err:", err)
 	}
 	l().Printf("ToVector text: %s, vector size: %v", text, len(textVector))
-	return nil
+	return id, nil
 }
@@ -0,0 +1,86 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"go-weaviate-deepseek/ext"
+	"strings"
+
+	"github.com/tidwall/gjson"
+)
+
+// ChatFuncDef 非流式调用大模型，返回回答内容
+type ChatFuncDef func(ctx context.Context, prompt string) (string, error)
+
+// ChatFunc 在main中注入，避免services依赖jobs/api
+var ChatFunc ChatFuncDef
+
+const enrichQuestionsSize = 3
+
+const enrichPrompt = `阅读下面的文本，生成：
+1. summary: 一句话摘要，不超过100字
+2. keywords: 3到8个关键词
+3. questions: 用户最可能提出的%d个问题，这些问题可以由这段文本回答
+
+使用文本本身的语言，只输出JSON，不要输出其他内容，格式：
+{"summary": "", "keywords": [""], "questions": [""]}
+
+文本:
+"""
+%s
+"""`
+
+// Enrichment 大模型为chunk生成的摘要、关键词和假设问题
+type Enrichment struct {
+	Summary   string
+	Keywords  []string
+	Questions []string
+}
+
+// EnrichChunk 调用大模型生成chunk的摘要、关键词和用户可能提出的问题，用量按ctx中的调用方统计
+func EnrichChunk(ctx context.Context, chunk string) (*Enrichment, error) {
+	if ChatFunc == nil {
+		return nil, errors.New("chat func is not set")
+	}
+	content, err := ChatFunc(ctx, fmt.Sprintf(enrichPrompt, enrichQuestionsSize, chunk))
+	if err != nil {
+		return nil, err
+	}
+
+	// 模型有时会用 ```json ``` 包裹
+	content = strings.TrimSpace(content)
+	content = strings.TrimPrefix(content, "```json")
+	content = strings.TrimPrefix(content, "```")
+	content = strings.TrimSuffix(content, "```")
+	if !gjson.Valid(content) {
+		return nil, fmt.Errorf("invalid enrichment json: %s", content)
+	}
+
+	doc := gjson.Parse(content)
+	res := Enrichment{
+		Summary:   doc.Get("summary").String(),
+		Keywords:  make([]string, 0),
+		Questions: make([]string, 0),
+	}
+	for _, k := range doc.Get("keywords").Array() {
+		if len(k.String()) > 0 {
+			res.Keywords = append(res.Keywords, k.String())
+		}
+	}
+	for _, q := range doc.Get("questions").Array() {
+		if len(q.String()) > 0 && len(res.Questions) < enrichQuestionsSize {
+			res.Questions = append(res.Questions, q.String())
+		}
+	}
+	return &res, nil
+}
+
+// Attrs 作为chunk的属性保存
+func (e *Enrichment) Attrs() ext.M {
+	return ext.M{
+		"summary":   e.Summary,
+		"keywords":  strings.Join(e.Keywords, ", "),
+		"questions": strings.Join(e.Questions, "\n"),
+	}
+}
@@ -0,0 +1,255 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go-weaviate-deepseek/conf"
+	"go-weaviate-deepseek/conn"
+	"io"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+const (
+	redisChatRecordPrefix    = "feedback:chat:"      // string, json, 回答完成时保存，评价时关联
+	redisFeedbackPrefix      = "feedback:item:"      // string, json
+	redisFeedbackIndexPrefix = "feedback:index:"     // zset, 集合的评价列表，score为评价时间
+	redisFeedbackDownPrefix  = "feedback:downvotes:" // zset, chunk id => 差评次数
+
+	// 同一个回答同时评价时的重试次数
+	feedbackTxRetries = 5
+
+	// 所有集合的评价列表
+	FeedbackIndexAll = "_all"
+
+	RatingUp   = "up"
+	RatingDown = "down"
+)
+
+var (
+	ErrChatRecordNotFound = errors.New("chat record not found or expired")
+	// ErrFeedbackOwner 只能评价自己的回答
+	ErrFeedbackOwner = errors.New("chat record belongs to another user")
+)
+
+// FeedbackChunk 回答时检索到的内容
+type FeedbackChunk struct {
+	ID       string  `json:"id"`
+	Title    string  `json:"title"`
+	URL      string  `json:"url"`
+	Distance float32 `json:"distance"`
+}
+
+// ChatRecord 一次回答的问题、内容、模型和检索到的内容
+type ChatRecord struct {
+	ChatUUID  string           `json:"chat_uuid"`
+	UserUUID  string           `json:"user_uuid"`
+	ClsName   string           `json:"cls_name"`
+	Prompt    string           `json:"prompt"`
+	Answer    string           `json:"answer"`
+	ChatModel string           `json:"chat_model"`
+	Chunks    []*FeedbackChunk `json:"chunks"`
+	CreatedAt int64            `json:"created_at"`
+}
+
+// Feedback 每个chat_uuid只保留最后一次评价
+type Feedback struct {
+	*ChatRecord
+	Rating     string `json:"rating"` // up | down
+	Comment    string `json:"comment"`
+	FeedbackBy string `json:"feedback_by"`
+	FeedbackAt int64  `json:"feedback_at"`
+}
+
+func feedbackEnabled() bool {
+	return conn.Redis != nil && conf.Settings.Feedback != nil && conf.Settings.Feedback.Enabled
+}
+
+// SaveChatRecord 回答完成后保存，用于之后的评价
+func SaveChatRecord(r *ChatRecord) error {
+	if !feedbackEnabled() || len(r.ChatUUID) == 0 {
+		return nil
+	}
+	if r.CreatedAt == 0 {
+		r.CreatedAt = time.Now().Unix()
+	}
+	b, _ := json.Marshal(r)
+	ttl := time.Duration(conf.Settings.Feedback.RecordTTLHours) * time.Hour
+	return conn.Redis.Set(context.Background(), redisChatRecordPrefix+r.ChatUUID, b, ttl).Err()
+}
+
+// SubmitFeedback 评价userUUID自己已经完成的回答，重复评价时覆盖之前的评价
+func SubmitFeedback(chatUUID, rating, comment, userUUID string) (*Feedback, error) {
+	if !feedbackEnabled() {
+		return nil, errors.New("feedback is not enabled")
+	}
+	if rating != RatingUp && rating != RatingDown {
+		return nil, fmt.Errorf("rating should be %s or %s", RatingUp, RatingDown)
+	}
+	ctx := context.Background()
+	b, err := conn.Redis.Get(ctx, redisChatRecordPrefix+chatUUID).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, ErrChatRecordNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	record := &ChatRecord{}
+	if err := json.Unmarshal(b, record); err != nil {
+		return nil, err
+	}
+	if record.UserUUID != userUUID {
+		return nil, ErrFeedbackOwner
+	}
+	f := &Feedback{
+		ChatRecord: record,
+		Rating:     rating,
+		Comment:    comment,
+		FeedbackBy: userUUID,
+		FeedbackAt: time.Now().Unix(),
+	}
+	fb, _ := json.Marshal(f)
+	score := float64(f.FeedbackAt)
+	key := redisFeedbackPrefix + chatUUID
+	// WATCH之前的评价，同时提交的评价只有一个能修改差评次数，其他的重试
+	txf := func(tx *redis.Tx) error {
+		wasDown := false
+		pb, err := tx.Get(ctx, key).Bytes()
+		if err != nil && !errors.Is(err, redis.Nil) {
+			return err
+		}
+		if err == nil {
+			prev := &Feedback{}
+			if err := json.Unmarshal(pb, prev); err != nil {
+				return err
+			}
+			wasDown = prev.Rating == RatingDown
+		}
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.Set(ctx, key, fb, 0)
+			pipe.ZAdd(ctx, redisFeedbackIndexPrefix+FeedbackIndexAll, &redis.Z{Score: score, Member: chatUUID})
+			if len(record.ClsName) > 0 {
+				pipe.ZAdd(ctx, redisFeedbackIndexPrefix+record.ClsName, &redis.Z{Score: score, Member: chatUUID})
+			}
+			// 只在评价变化时修改差评次数
+			if rating == RatingDown && !wasDown {
+				for _, c := range record.Chunks {
+					pipe.ZIncrBy(ctx, redisFeedbackDownPrefix+record.ClsName, 1, c.ID)
+				}
+			} else if rating == RatingUp && wasDown {
+				for _, c := range record.Chunks {
+					pipe.ZIncrBy(ctx, redisFeedbackDownPrefix+record.ClsName, -1, c.ID)
+				}
+			}
+			return nil
+		})
+		return err
+	}
+	for i := 0; i < feedbackTxRetries; i++ {
+		err = conn.Redis.Watch(ctx, txf, key)
+		if !errors.Is(err, redis.TxFailedErr) {
+			break
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// GetFeedback 没有评价过时返回 redis.Nil
+func GetFeedback(chatUUID string) (*Feedback, error) {
+	if conn.Redis == nil {
+		return nil, errors.New("redis is not connected")
+	}
+	b, err := conn.Redis.Get(context.Background(), redisFeedbackPrefix+chatUUID).Bytes()
+	if err != nil {
+		return nil, err
+	}
+	f := &Feedback{}
+	err = json.Unmarshal(b, f)
+	return f, err
+}
+
+// ListFeedback 按评价时间倒序，clsName为空时返回所有集合，rating为空时不过滤
+func ListFeedback(clsName, rating string, offset, limit int64) ([]*Feedback, error) {
+	res := make([]*Feedback, 0, limit)
+	skipped := int64(0)
+	err := eachFeedback(clsName, rating, func(f *Feedback) bool {
+		if skipped < offset {
+			skipped++
+			return true
+		}
+		res = append(res, f)
+		return int64(len(res)) < limit
+	})
+	return res, err
+}
+
+// ExportFeedback 每行一条评价的json
+func ExportFeedback(clsName, rating string, w io.Writer) error {
+	enc := json.NewEncoder(w)
+	var encErr error
+	err := eachFeedback(clsName, rating, func(f *Feedback) bool {
+		encErr = enc.Encode(f)
+		return encErr == nil
+	})
+	if err != nil {
+		return err
+	}
+	return encErr
+}
+
+// eachFeedback 按评价时间倒序遍历，fn返回false时停止
+func eachFeedback(clsName, rating string, fn func(f *Feedback) bool) error {
+	if conn.Redis == nil {
+		return errors.New("redis is not connected")
+	}
+	if len(clsName) == 0 {
+		clsName = FeedbackIndexAll
+	}
+	ctx := context.Background()
+	key := redisFeedbackIndexPrefix + clsName
+	for start := int64(0); ; start += 200 {
+		ids, err := conn.Redis.ZRevRange(ctx, key, start, start+199).Result()
+		if err != nil {
+			return err
+		}
+		for _, id := range ids {
+			f, err := GetFeedback(id)
+			if err != nil {
+				continue
+			}
+			if len(rating) > 0 && f.Rating != rating {
+				continue
+			}
+			if !fn(f) {
+				return nil
+			}
+		}
+		if len(ids) < 200 {
+			return nil
+		}
+	}
+}
+
+// ChunkDownvotes 每个chunk的差评次数，没有差评的不返回
+func ChunkDownvotes(clsName string, ids []string) (map[string]int, error) {
+	res := make(map[string]int)
+	if conn.Redis == nil || len(ids) == 0 {
+		return res, nil
+	}
+	scores, err := conn.Redis.ZMScore(context.Background(), redisFeedbackDownPrefix+clsName, ids...).Result()
+	if err != nil {
+		return nil, err
+	}
+	for i, s := range scores {
+		if s > 0 {
+			res[ids[i]] = int(s)
+		}
+	}
+	return res, nil
+}
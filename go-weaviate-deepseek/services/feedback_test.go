@@ -0,0 +1,41 @@
+package services
+
+import (
+	"errors"
+	"testing"
+
+	"go-weaviate-deepseek/conf"
+)
+
+func TestSubmitFeedbackOwner(t *testing.T) {
+	useRedis(t)
+	orig := conf.Settings.Feedback
+	conf.Settings.Feedback = &conf.FeedbackConf{Enabled: true, RecordTTLHours: 1}
+	defer func() { conf.Settings.Feedback = orig }()
+
+	err := SaveChatRecord(&ChatRecord{
+		ChatUUID: "chat1",
+		UserUUID: "u1",
+		ClsName:  "Eggman",
+		Chunks:   []*FeedbackChunk{{ID: "a"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := SubmitFeedback("chat1", RatingDown, "", "u2"); !errors.Is(err, ErrFeedbackOwner) {
+		t.Errorf("other user: err = %v", err)
+	}
+	if downs, _ := ChunkDownvotes("Eggman", []string{"a"}); downs["a"] != 0 {
+		t.Errorf("downvotes after other user = %v", downs)
+	}
+	f, err := SubmitFeedback("chat1", RatingDown, "", "u1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.FeedbackBy != "u1" {
+		t.Errorf("feedback_by = %s", f.FeedbackBy)
+	}
+	if downs, _ := ChunkDownvotes("Eggman", []string{"a"}); downs["a"] != 1 {
+		t.Errorf("downvotes after owner = %v", downs)
+	}
+}
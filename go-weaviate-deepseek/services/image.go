@@ -1,15 +1,22 @@
 package services
 
 import (
-	"bytes"
+	"context"
 	"encoding/base64"
+	"errors"
+	"fmt"
 	"go-weaviate-deepseek/ext"
-	"image/png"
+	"go-weaviate-deepseek/ext/weaviatelib"
+	"io"
 	"io/ioutil"
 	"net/http"
 	"os"
 	"os/exec"
 	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"gopkg.in/resty.v1"
 )
 
 // ExtractTextFromImage imgBase64OrPath: image base64 or image path
@@ -48,23 +55,85 @@ func saveImageFrom64(b64 string) (string, error) {
 	if err != nil {
 		return "", err
 	}
-	r := bytes.NewReader(b)
-	im, err := png.Decode(r)
+	// tesseract可以自行识别jpeg/png等格式，不再转换成png
+	if !strings.HasPrefix(http.DetectContentType(b), "image/") {
+		return "", errors.New("invalid image data")
+	}
+
+	filep := "/tmp/" + ext.GenGlobalID()
+	err = os.WriteFile(filep, b, 0644)
 	if err != nil {
 		return "", err
 	}
+	return filep, nil
+}
 
-	filep := "/tmp/" + ext.GenGlobalID()
-	f, err := os.OpenFile(filep, os.O_WRONLY|os.O_CREATE, 0755)
+// 下载图片的大小限制
+const maxImageSize = 20 << 20
+
+var errImageTooLarge = fmt.Errorf("image is larger than %d bytes", maxImageSize)
+
+// ReadImageURLTo64 下载图片并转换成base64，超过 maxImageSize 时立即停止
+func ReadImageURLTo64(urlStr string) (string, error) {
+	rsp, err := resty.New().
+		SetTimeout(60 * time.Second).
+		R().
+		SetDoNotParseResponse(true).
+		Get(urlStr)
 	if err != nil {
 		return "", err
 	}
-	err = png.Encode(f, im)
+	body := rsp.RawBody()
+	defer body.Close()
+	if !rsp.IsSuccess() {
+		return "", fmt.Errorf("download image failed, status: %d", rsp.StatusCode())
+	}
+	if rsp.RawResponse.ContentLength > maxImageSize {
+		return "", errImageTooLarge
+	}
+	b, err := io.ReadAll(io.LimitReader(body, maxImageSize+1))
 	if err != nil {
 		return "", err
 	}
-	f.Close()
-	return filep, nil
+	if len(b) > maxImageSize {
+		return "", errImageTooLarge
+	}
+	if !strings.HasPrefix(http.DetectContentType(b), "image/") {
+		return "", errors.New("url is not an image")
+	}
+	return base64.StdEncoding.EncodeToString(b), nil
+}
+
+// ImportImage 导入到图片集合(create_image_db)，同时保存OCR的文字，caption为true时加上视觉模型生成的描述
+func ImportImage(ctx context.Context, clsName, b64, urlStr, title string, caption bool) (string, error) {
+	var err error
+	if len(b64) == 0 {
+		if len(urlStr) == 0 {
+			return "", errors.New("base64 or url is required")
+		}
+		b64, err = ReadImageURLTo64(urlStr)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	txt, err := captionAndOCR(ctx, b64, caption)
+	if err != nil {
+		return "", err
+	}
+
+	id := uuid.NewString()
+	err = weaviatelib.CreateImage(clsName, id, map[string]interface{}{
+		"title":      title,
+		"url":        urlStr,
+		"captions":   txt,
+		"media_type": "image",
+	}, b64)
+	if err != nil {
+		return "", err
+	}
+	l().Printf("image imported, cls_name: %s, id: %s, ocr length: %d", clsName, id, len(txt))
+	return id, nil
 }
 
 func ReadImageTo64(filep string, withMimeType bool) (string, error) {
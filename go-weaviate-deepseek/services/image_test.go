@@ -0,0 +1,61 @@
+package services
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// 最小的png文件头，DetectContentType识别为image/png
+var pngHeader = []byte("\x89PNG\r\n\x1a\n")
+
+func TestReadImageURLTo64(t *testing.T) {
+	small := append(append([]byte{}, pngHeader...), bytes.Repeat([]byte{0}, 100)...)
+	large := append(append([]byte{}, pngHeader...), bytes.Repeat([]byte{0}, maxImageSize)...)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/small.png":
+			w.Write(small)
+		case "/large.png":
+			w.Write(large)
+		case "/chunked.png":
+			// 没有Content-Length，只能按读取的长度判断
+			w.Header().Set("Transfer-Encoding", "chunked")
+			for i := 0; i < len(large); i += 1 << 20 {
+				end := i + 1<<20
+				if end > len(large) {
+					end = len(large)
+				}
+				w.Write(large[i:end])
+				w.(http.Flusher).Flush()
+			}
+		case "/text":
+			w.Write([]byte("hello"))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	b64, err := ReadImageURLTo64(srv.URL + "/small.png")
+	if err != nil {
+		t.Fatalf("small: %s", err)
+	}
+	if b64 != base64.StdEncoding.EncodeToString(small) {
+		t.Errorf("small: unexpected base64")
+	}
+	for _, p := range []string{"/large.png", "/chunked.png"} {
+		if _, err := ReadImageURLTo64(srv.URL + p); !errors.Is(err, errImageTooLarge) {
+			t.Errorf("%s: got %v, want errImageTooLarge", p, err)
+		}
+	}
+	if _, err := ReadImageURLTo64(srv.URL + "/text"); err == nil {
+		t.Errorf("text: want error")
+	}
+	if _, err := ReadImageURLTo64(srv.URL + "/missing"); err == nil {
+		t.Errorf("missing: want error")
+	}
+}
@@ -1,7 +1,12 @@
 package services
 
 import (
+	"context"
+	"fmt"
+	"go-weaviate-deepseek/conn"
 	"go-weaviate-deepseek/ext"
+	"go-weaviate-deepseek/ext/embedder"
+	"go-weaviate-deepseek/ext/weaviatelib"
 	"go-weaviate-deepseek/services/scrape"
 	"strings"
 
@@ -12,6 +17,11 @@ import (
 
 const (
 	minTextLength = 30
+
+	// 每批保存到weaviate的chunk数量
+	importBatchSize = 50
+
+	redisImportDonePrefix = "import:done:"
 )
 
 func lim() *logrus.Entry {
@@ -22,9 +32,34 @@ type ImportSource struct {
 	ClsName string `json:"cls_name"`
 	Type    string `json:"type"`
 	Data    string `json:"data"`
+
+	// Enrich 调用大模型为每个chunk生成摘要、关键词和假设问题
+	Enrich bool `json:"enrich"`
+	// IndexQuestions 假设问题单独向量化保存，captions仍为原chunk，通过chunk_id指向原chunk
+	IndexQuestions bool `json:"index_questions"`
+	// Resume 跳过上次已经导入完成的url，用于中断后重新导入
+	Resume bool `json:"resume"`
+
+	// UserUUID, APIKey 用于统计用量和检查限额
+	UserUUID string `json:"user_uuid"`
+	APIKey   string `json:"-"`
+
+	// JobID 非空时导入进度会保存到redis，可通过 /weaviate/import_job 查询
+	JobID string     `json:"-"`
+	job   *ImportJob `json:"-"`
 }
 
 func (i *ImportSource) Do() error {
+	i.job = NewImportJob(i.JobID, i.ClsName, i.Type)
+	i.job.Save()
+	err := i.do()
+	i.job.Finish(err)
+	// 导入失败时也可能已经保存了部分内容
+	BumpAnswerCache(i.ClsName)
+	return err
+}
+
+func (i *ImportSource) do() error {
 	doc := gjson.Parse(i.Data)
 	switch i.Type {
 	case "text":
@@ -49,6 +84,8 @@ func (i *ImportSource) Do() error {
 			return err
 		}
 		lim().Printf("scrape url done, url: %s, start creating vector data", entryURL)
+		// 单个页面失败不影响其他页面，失败的页面可以通过resume重新导入
+		failed := 0
 		for urlStr, v := range res {
 			txt := cast.ToString(v["text"])
 			err := i.handleText(txt, ext.M{
@@ -56,50 +93,192 @@ func (i *ImportSource) Do() error {
 				"url":        urlStr,
 				"media_type": "url",
 			})
+			child := &ImportJobChild{Path: urlStr, MediaType: "url", Status: JobStatusDone, Length: len([]rune(txt))}
 			if err != nil {
-				return err
+				lim().Warnf("import url err, url: %s, err: %s", urlStr, err)
+				child.Status = JobStatusError
+				child.Error = err.Error()
+				failed++
 			}
+			i.job.AddChild(child)
+		}
+		if failed > 0 {
+			return fmt.Errorf("%d of %d urls failed", failed, len(res))
 		}
 	case "image":
 		b64 := doc.Get("base64").String()
 		title := doc.Get("title").String()
 		urlStr := doc.Get("url").String()
-		txt, err := ExtractTextFromImage(b64, true)
+		txt, err := captionAndOCR(i.usageCtx(), b64, doc.Get("caption").Bool())
 		if err != nil {
 			return err
 		}
-		return i.handleText(ext.Oneline(txt), ext.M{
+		return i.handleText(txt, ext.M{
 			"title":      title,
 			"url":        urlStr,
 			"media_type": "image",
 		})
+	case "archive":
+		return i.handleArchive(doc.Get("url").String(), doc.Get("base64").String(), doc.Get("name").String())
 	}
 
 	return nil
 }
 
 func (i *ImportSource) handleText(bigText string, addiAttrs ext.M) error {
-	chunks := ChunkSplit(bigText, CHUNK_SIZE)
-	var err error
-	for _, ca := range chunks {
-		if !isMeetMinLength(ca.Chunk) {
-			lim().Printf("chunk length is less than %d, text: %s, skip save", minTextLength, ca.Chunk)
-			continue
-		}
+	urlStr := cast.ToString(addiAttrs["url"])
+	if i.Resume && i.isSourceDone(urlStr) {
+		lim().Printf("source has been imported, skip, url: %s", urlStr)
+		return nil
+	}
 
-		err = ca.CalVector()
-		if err != nil {
-			return err
+	chunks := ImportChunks(bigText)
+	for start := 0; start < len(chunks); start += importBatchSize {
+		end := start + importBatchSize
+		if end > len(chunks) {
+			end = len(chunks)
 		}
-		err = ca.Save(i.ClsName, addiAttrs)
+		err := i.saveChunks(chunks[start:end], addiAttrs)
 		if err != nil {
-			lim().Errorln("save chunk err:", err)
+			lim().Errorln("save chunks err:", err)
 			return err
 		}
 	}
+	i.markSourceDone(urlStr)
 	return nil
 }
 
+// saveChunks 批量计算向量后通过batcher保存，id由内容决定，重复导入会覆盖而不是新增
+func (i *ImportSource) saveChunks(chunks []*ChunkAttr, addiAttrs ext.M) error {
+	err := CalVectors(i.ClsName, chunks)
+	if err != nil {
+		return err
+	}
+
+	urlStr := cast.ToString(addiAttrs["url"])
+	objs := make([]*weaviatelib.BatchObject, 0, len(chunks))
+	for _, ca := range chunks {
+		attrs := addiAttrs
+		var enr *Enrichment
+		if i.Enrich {
+			enr, err = EnrichChunk(i.usageCtx(), ca.Chunk)
+			if err != nil {
+				// 生成失败不影响chunk本身的导入
+				lim().Warnf("enrich chunk err: %s, text: %s", err, ca.Chunk)
+			} else {
+				attrs = ext.MergeM(addiAttrs, enr.Attrs())
+			}
+		}
+
+		id := ChunkObjectID(i.ClsName, urlStr, ca.Chunk)
+		objs = append(objs, ca.BatchObject(id, attrs))
+
+		if enr != nil && i.IndexQuestions {
+			qobjs, err := i.questionObjects(id, ca.Chunk, enr.Questions, addiAttrs)
+			if err != nil {
+				return err
+			}
+			objs = append(objs, qobjs...)
+		}
+	}
+
+	err = weaviatelib.BatchCreate(i.ClsName, objs)
+	if err != nil {
+		return err
+	}
+	i.recordUsage(objs)
+	lim().Printf("chunks saved, cls_name: %s, url: %s, objects: %d", i.ClsName, urlStr, len(objs))
+	return nil
+}
+
+// questionObjects 每个假设问题一个对象，向量为问题的向量，内容为原chunk，问题类查询更容易命中
+func (i *ImportSource) questionObjects(chunkID, chunk string, questions []string, addiAttrs ext.M) ([]*weaviatelib.BatchObject, error) {
+	qcas := make([]*ChunkAttr, 0, len(questions))
+	for _, q := range questions {
+		qcas = append(qcas, &ChunkAttr{Chunk: q, Lang: ext.DetectLang(q)})
+	}
+	err := CalVectors(i.ClsName, qcas)
+	if err != nil {
+		return nil, err
+	}
+
+	res := make([]*weaviatelib.BatchObject, 0, len(qcas))
+	for _, qca := range qcas {
+		q := qca.Chunk
+		qca.Chunk = chunk
+		res = append(res, qca.BatchObject(ChunkObjectID(i.ClsName, chunkID, q), ext.MergeM(addiAttrs, ext.M{
+			"media_type":             weaviatelib.MediaTypeQuestion,
+			"question":               q,
+			weaviatelib.FieldChunkID: chunkID,
+		})))
+	}
+	return res, nil
+}
+
+// recordUsage 导入的用量为计算向量的tokens，本地估算
+func (i *ImportSource) recordUsage(objs []*weaviatelib.BatchObject) {
+	e, err := embedder.ForCollection(i.ClsName)
+	if err != nil {
+		return
+	}
+	tokens := 0
+	for _, o := range objs {
+		txt := cast.ToString(o.Properties["captions"])
+		if q, exists := o.Properties["question"]; exists {
+			txt = cast.ToString(q)
+		}
+		tokens += ext.TokenLen(txt)
+	}
+	RecordUsage(&UsageRecord{
+		Kind:         UsageKindImport,
+		UserUUID:     i.UserUUID,
+		APIKey:       i.APIKey,
+		ClsName:      i.ClsName,
+		Model:        e.ModelID(),
+		PromptTokens: tokens,
+	})
+}
+
+// usageCtx 生成摘要、图片描述等大模型调用按导入的调用方统计用量
+func (i *ImportSource) usageCtx() context.Context {
+	return WithUsageCaller(context.Background(), &UsageCaller{
+		Kind:     UsageKindImport,
+		UserUUID: i.UserUUID,
+		APIKey:   i.APIKey,
+		ClsName:  i.ClsName,
+	})
+}
+
+func (i *ImportSource) isSourceDone(urlStr string) bool {
+	if len(urlStr) == 0 || conn.Redis == nil {
+		return false
+	}
+	done, _ := conn.Redis.SIsMember(context.Background(), redisImportDonePrefix+i.ClsName, urlStr).Result()
+	return done
+}
+
+func (i *ImportSource) markSourceDone(urlStr string) {
+	if len(urlStr) == 0 || conn.Redis == nil {
+		return
+	}
+	key := redisImportDonePrefix + i.ClsName
+	conn.Redis.SAdd(context.Background(), key, urlStr)
+	conn.Redis.Expire(context.Background(), key, importJobTTL)
+}
+
+// ImportChunks 导入时的分段，太短的chunk不保存
+func ImportChunks(bigText string) []*ChunkAttr {
+	chunks := make([]*ChunkAttr, 0)
+	for _, ca := range ChunkSplit(bigText, CHUNK_SIZE) {
+		if !isMeetMinLength(ca.Chunk) {
+			lim().Printf("chunk length is less than %d, text: %s, skip save", minTextLength, ca.Chunk)
+			continue
+		}
+		chunks = append(chunks, ca)
+	}
+	return chunks
+}
+
 func isMeetMinLength(txt string) bool {
 	return len([]rune(txt)) > minTextLength
 }
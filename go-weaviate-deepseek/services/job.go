@@ -0,0 +1,104 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"go-weaviate-deepseek/conn"
+	"sync"
+	"time"
+)
+
+const (
+	redisImportJobPrefix = "import:job:"
+	importJobTTL         = 7 * 24 * time.Hour
+
+	JobStatusRunning = "running"
+	JobStatusDone    = "done"
+	JobStatusError   = "error"
+)
+
+// ImportJob 导入任务，archive类型会为每个文件生成一个子任务结果
+type ImportJob struct {
+	ID        string            `json:"id"`
+	ClsName   string            `json:"cls_name"`
+	Type      string            `json:"type"`
+	Status    string            `json:"status"`
+	Error     string            `json:"error,omitempty"`
+	Children  []*ImportJobChild `json:"children"`
+	CreatedAt int64             `json:"created_at"`
+	UpdatedAt int64             `json:"updated_at"`
+
+	lock sync.Mutex
+}
+
+type ImportJobChild struct {
+	Path      string `json:"path"`
+	MediaType string `json:"media_type"`
+	Status    string `json:"status"`
+	Error     string `json:"error,omitempty"`
+	Length    int    `json:"length"`
+}
+
+func NewImportJob(id, clsName, tp string) *ImportJob {
+	now := time.Now().Unix()
+	return &ImportJob{
+		ID:        id,
+		ClsName:   clsName,
+		Type:      tp,
+		Status:    JobStatusRunning,
+		Children:  make([]*ImportJobChild, 0),
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+}
+
+func (j *ImportJob) AddChild(c *ImportJobChild) {
+	j.lock.Lock()
+	j.Children = append(j.Children, c)
+	j.lock.Unlock()
+	j.Save()
+}
+
+func (j *ImportJob) Finish(err error) {
+	j.lock.Lock()
+	j.Status = JobStatusDone
+	if err != nil {
+		j.Status = JobStatusError
+		j.Error = err.Error()
+	}
+	j.lock.Unlock()
+	j.Save()
+}
+
+// Save 保存到redis，没有job id（内部调用）时不保存
+func (j *ImportJob) Save() {
+	if j == nil || len(j.ID) == 0 || conn.Redis == nil {
+		return
+	}
+	j.lock.Lock()
+	j.UpdatedAt = time.Now().Unix()
+	b, _ := json.Marshal(j)
+	j.lock.Unlock()
+
+	err := conn.Redis.Set(context.Background(), redisImportJobPrefix+j.ID, b, importJobTTL).Err()
+	if err != nil {
+		lim().Warnf("save import job err, job: %s, err: %s", j.ID, err)
+	}
+}
+
+func GetImportJob(id string) (*ImportJob, error) {
+	if conn.Redis == nil {
+		return nil, errors.New("redis is not connected")
+	}
+	b, err := conn.Redis.Get(context.Background(), redisImportJobPrefix+id).Bytes()
+	if err != nil {
+		return nil, err
+	}
+	j := ImportJob{}
+	err = json.Unmarshal(b, &j)
+	if err != nil {
+		return nil, err
+	}
+	return &j, nil
+}
@@ -0,0 +1,378 @@
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go-weaviate-deepseek/conf"
+	"go-weaviate-deepseek/conn"
+	"go-weaviate-deepseek/ext"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/spf13/cast"
+)
+
+const (
+	redisConvTurnsPrefix = "chat:conv:"      // list, 每个元素为一条ChatTurn
+	redisConvMetaPrefix  = "chat:conv:meta:" // hash
+	redisUserConvsPrefix = "chat:convs:"     // zset, 用户的对话列表，score为更新时间
+	redisConvLockPrefix  = "chat:conv:lock:" // string, 压缩对话时的锁
+	redisHistSumPrefix   = "chat:histsum:"   // string, 客户端传的历史的摘要，key为历史内容的hash
+
+	// 生成摘要的最长时间，超时后锁自动释放
+	convLockTTL = 2 * time.Minute
+	histSumTTL  = 24 * time.Hour
+)
+
+// ErrConversationOwner 对话属于其他用户
+var ErrConversationOwner = errors.New("conversation belongs to another user")
+
+// unlockScript 只删除自己加的锁
+var unlockScript = redis.NewScript(`if redis.call("GET", KEYS[1]) == ARGV[1] then return redis.call("DEL", KEYS[1]) end return 0`)
+
+// ChatTurn 对话中的一条消息
+type ChatTurn struct {
+	Role      string `json:"role"`
+	Content   string `json:"content"`
+	ChatUUID  string `json:"chat_uuid"`
+	CreatedAt int64  `json:"created_at"`
+}
+
+// Conversation 以第一条消息的chat_uuid为id，后续消息的parent_chat_uuid指向该id
+type Conversation struct {
+	ID        string      `json:"id"`
+	UserUUID  string      `json:"user_uuid"`
+	Title     string      `json:"title"`
+	Summary   string      `json:"summary,omitempty"` // 已经被删除的较早消息的摘要
+	Turns     []*ChatTurn `json:"turns,omitempty"`
+	CreatedAt int64       `json:"created_at"`
+	UpdatedAt int64       `json:"updated_at"`
+}
+
+// ConversationID parent_chat_uuid 优先
+func ConversationID(chatUUID, parentChatUUID string) string {
+	if len(parentChatUUID) > 0 {
+		return parentChatUUID
+	}
+	return chatUUID
+}
+
+func memoryTTL() time.Duration {
+	return time.Duration(conf.Settings.Memory.TTLHours) * time.Hour
+}
+
+const summaryPrompt = `下面是一段对话的已有摘要和后续的消息，请把它们合并成一段新的摘要，保留用户的身份、偏好、问过的问题和得到的关键结论，不超过300字，直接输出摘要内容。
+
+已有摘要：
+%s
+
+后续消息：
+%s`
+
+// CheckConversationOwner 对话已经存在时必须属于userUUID，不存在时任何用户都可以创建
+func CheckConversationOwner(convID, userUUID string) error {
+	if conn.Redis == nil || len(convID) == 0 {
+		return nil
+	}
+	owner, err := conn.Redis.HGet(context.Background(), redisConvMetaPrefix+convID, "user_uuid").Result()
+	if err == redis.Nil {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if owner != userUUID {
+		return ErrConversationOwner
+	}
+	return nil
+}
+
+// AppendTurns 保存新的消息，超过 max_turns 或 token_budget 的旧消息会合并到摘要中，生成摘要的用量按ctx中的调用方统计
+func AppendTurns(ctx context.Context, convID, userUUID string, turns ...*ChatTurn) error {
+	if conn.Redis == nil || len(convID) == 0 || len(turns) == 0 {
+		return nil
+	}
+	if err := CheckConversationOwner(convID, userUUID); err != nil {
+		return err
+	}
+	now := time.Now().Unix()
+	values := make([]interface{}, 0, len(turns))
+	for _, t := range turns {
+		if t.CreatedAt == 0 {
+			t.CreatedAt = now
+		}
+		b, _ := json.Marshal(t)
+		values = append(values, b)
+	}
+
+	turnsKey := redisConvTurnsPrefix + convID
+	metaKey := redisConvMetaPrefix + convID
+	ttl := memoryTTL()
+	pipe := conn.Redis.TxPipeline()
+	pipe.RPush(ctx, turnsKey, values...)
+	pipe.Expire(ctx, turnsKey, ttl)
+	pipe.HSetNX(ctx, metaKey, "user_uuid", userUUID)
+	pipe.HSetNX(ctx, metaKey, "title", convTitle(turns[0].Content))
+	pipe.HSetNX(ctx, metaKey, "created_at", now)
+	pipe.HSet(ctx, metaKey, "updated_at", now)
+	pipe.Expire(ctx, metaKey, ttl)
+	if len(userUUID) > 0 {
+		pipe.ZAdd(ctx, redisUserConvsPrefix+userUUID, &redis.Z{Score: float64(now), Member: convID})
+		pipe.Expire(ctx, redisUserConvsPrefix+userUUID, ttl)
+	}
+	_, err := pipe.Exec(ctx)
+	if err != nil {
+		return err
+	}
+	return compactConversation(ctx, convID)
+}
+
+// compactConversation 从最早的消息开始按一问一答合并到摘要中，直到满足 max_turns 和 token_budget，
+// 生成摘要失败时直接删除
+func compactConversation(ctx context.Context, convID string) error {
+	// 同一个对话同时只有一个压缩，否则会按同样的fold重复删除消息，没有拿到锁时由下一次追加消息时压缩
+	lockKey := redisConvLockPrefix + convID
+	token := ext.GenUUID()
+	locked, err := conn.Redis.SetNX(ctx, lockKey, token, convLockTTL).Result()
+	if err != nil {
+		return err
+	}
+	if !locked {
+		return nil
+	}
+	defer unlockScript.Run(ctx, conn.Redis, []string{lockKey}, token)
+
+	turns, err := getTurns(convID)
+	if err != nil {
+		return err
+	}
+	mc := conf.Settings.Memory
+	tokens := 0
+	for _, t := range turns {
+		tokens += ext.TokenLen(t.Content)
+	}
+	fold := 0
+	// 至少保留最后一轮
+	for fold+2 < len(turns) {
+		overTurns := mc.MaxTurns > 0 && len(turns)-fold > mc.MaxTurns*2
+		overTokens := mc.TokenBudget > 0 && tokens > mc.TokenBudget
+		if !overTurns && !overTokens {
+			break
+		}
+		// 一问一答一起合并，保留的消息总是从用户的问题开始
+		tokens -= ext.TokenLen(turns[fold].Content) + ext.TokenLen(turns[fold+1].Content)
+		fold += 2
+	}
+	if fold == 0 {
+		return nil
+	}
+
+	metaKey := redisConvMetaPrefix + convID
+	summary, _ := conn.Redis.HGet(ctx, metaKey, "summary").Result()
+	newSummary, err := SummarizeTurns(ctx, summary, turns[:fold])
+	if err != nil {
+		l().Warnf("summarize conversation err, id: %s, err: %s", convID, err)
+		newSummary = summary
+	}
+	pipe := conn.Redis.TxPipeline()
+	pipe.LTrim(ctx, redisConvTurnsPrefix+convID, int64(fold), -1)
+	pipe.HSet(ctx, metaKey, "summary", newSummary)
+	_, err = pipe.Exec(ctx)
+	return err
+}
+
+// SummarizeHistory 同一段历史只生成一次摘要，客户端每次都传完整的历史时不用每次请求都调用大模型
+func SummarizeHistory(ctx context.Context, turns []*ChatTurn) (string, error) {
+	h := sha256.New()
+	for _, t := range turns {
+		h.Write([]byte(t.Role + "\x00" + t.Content + "\x00"))
+	}
+	key := redisHistSumPrefix + hex.EncodeToString(h.Sum(nil))
+	if conn.Redis != nil {
+		if summary, err := conn.Redis.Get(ctx, key).Result(); err == nil {
+			return summary, nil
+		}
+	}
+	summary, err := SummarizeTurns(ctx, "", turns)
+	if err != nil {
+		return "", err
+	}
+	if conn.Redis != nil {
+		conn.Redis.Set(ctx, key, summary, histSumTTL)
+	}
+	return summary, nil
+}
+
+// SummarizeTurns 把较早的消息合并到已有的摘要中
+func SummarizeTurns(ctx context.Context, summary string, turns []*ChatTurn) (string, error) {
+	if ChatFunc == nil {
+		return "", errors.New("chat func is not set")
+	}
+	lines := make([]string, 0, len(turns))
+	for _, t := range turns {
+		lines = append(lines, t.Role+": "+t.Content)
+	}
+	if len(summary) == 0 {
+		summary = "无"
+	}
+	res, err := ChatFunc(ctx, fmt.Sprintf(summaryPrompt, summary, strings.Join(lines, "\n")))
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(res), nil
+}
+
+// convTitle 第一条消息的前50个字
+func convTitle(content string) string {
+	rs := []rune(ext.Oneline(content))
+	if len(rs) > 50 {
+		rs = rs[:50]
+	}
+	return string(rs)
+}
+
+// GetConversation 只能获取userUUID自己的对话，withTurns为false时只返回标题等信息
+func GetConversation(convID, userUUID string, withTurns bool) (*Conversation, error) {
+	if conn.Redis == nil {
+		return nil, errors.New("redis is not connected")
+	}
+	ctx := context.Background()
+	meta, err := conn.Redis.HGetAll(ctx, redisConvMetaPrefix+convID).Result()
+	if err != nil {
+		return nil, err
+	}
+	if len(meta) == 0 {
+		return nil, errors.New("conversation not found")
+	}
+	c := &Conversation{
+		ID:        convID,
+		UserUUID:  meta["user_uuid"],
+		Title:     meta["title"],
+		Summary:   meta["summary"],
+		CreatedAt: cast.ToInt64(meta["created_at"]),
+		UpdatedAt: cast.ToInt64(meta["updated_at"]),
+	}
+	if c.UserUUID != userUUID {
+		return nil, ErrConversationOwner
+	}
+	if withTurns {
+		c.Turns, err = getTurns(convID)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+func getTurns(convID string) ([]*ChatTurn, error) {
+	vals, err := conn.Redis.LRange(context.Background(), redisConvTurnsPrefix+convID, 0, -1).Result()
+	if err != nil {
+		return nil, err
+	}
+	turns := make([]*ChatTurn, 0, len(vals))
+	for _, v := range vals {
+		t := ChatTurn{}
+		if err := json.Unmarshal([]byte(v), &t); err != nil {
+			continue
+		}
+		turns = append(turns, &t)
+	}
+	return turns, nil
+}
+
+// ListConversations 按更新时间倒序
+func ListConversations(userUUID string, offset, limit int64) ([]*Conversation, int64, error) {
+	if conn.Redis == nil {
+		return nil, 0, errors.New("redis is not connected")
+	}
+	ctx := context.Background()
+	key := redisUserConvsPrefix + userUUID
+	total, err := conn.Redis.ZCard(ctx, key).Result()
+	if err != nil {
+		return nil, 0, err
+	}
+	ids, err := conn.Redis.ZRevRange(ctx, key, offset, offset+limit-1).Result()
+	if err != nil {
+		return nil, 0, err
+	}
+	res := make([]*Conversation, 0, len(ids))
+	for _, id := range ids {
+		c, err := GetConversation(id, userUUID, false)
+		if err != nil {
+			// 已过期
+			conn.Redis.ZRem(ctx, key, id)
+			continue
+		}
+		res = append(res, c)
+	}
+	return res, total, nil
+}
+
+// DeleteConversation 只能删除userUUID自己的对话
+func DeleteConversation(convID, userUUID string) error {
+	if conn.Redis == nil {
+		return errors.New("redis is not connected")
+	}
+	if err := CheckConversationOwner(convID, userUUID); err != nil {
+		return err
+	}
+	ctx := context.Background()
+	pipe := conn.Redis.TxPipeline()
+	pipe.Del(ctx, redisConvTurnsPrefix+convID, redisConvMetaPrefix+convID)
+	if len(userUUID) > 0 {
+		pipe.ZRem(ctx, redisUserConvsPrefix+userUUID, convID)
+	}
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// SummaryMessage 摘要作为一条system消息放在历史的最前面
+func SummaryMessage(summary string) ext.M {
+	return ext.M{"role": "system", "content": "之前对话的摘要：" + summary}
+}
+
+// HistoryMessages 摘要加上最近的消息，不超过 max_turns 和 token_budget，摘要之后第一条总是用户的问题，
+// 对话属于其他用户时返回 ErrConversationOwner
+func HistoryMessages(convID, userUUID string) ([]ext.M, error) {
+	res := make([]ext.M, 0)
+	if conn.Redis == nil || len(convID) == 0 {
+		return res, nil
+	}
+	if err := CheckConversationOwner(convID, userUUID); err != nil {
+		return nil, err
+	}
+	turns, err := getTurns(convID)
+	if err != nil {
+		return nil, err
+	}
+	summary, _ := conn.Redis.HGet(context.Background(), redisConvMetaPrefix+convID, "summary").Result()
+	if len(summary) > 0 {
+		res = append(res, SummaryMessage(summary))
+	}
+
+	mc := conf.Settings.Memory
+	start := len(turns)
+	tokens := ext.TokenLen(summary)
+	for i := len(turns) - 1; i >= 0; i-- {
+		if mc.MaxTurns > 0 && len(turns)-i > mc.MaxTurns*2 {
+			break
+		}
+		tokens += ext.TokenLen(turns[i].Content)
+		if mc.TokenBudget > 0 && tokens > mc.TokenBudget {
+			break
+		}
+		start = i
+	}
+	for start < len(turns) && turns[start].Role != "user" {
+		start++
+	}
+	for _, t := range turns[start:] {
+		res = append(res, ext.M{"role": t.Role, "content": t.Content})
+	}
+	return res, nil
+}
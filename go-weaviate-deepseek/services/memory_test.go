@@ -0,0 +1,98 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"go-weaviate-deepseek/conf"
+)
+
+func useMemoryConf(t *testing.T, mc *conf.MemoryConf) {
+	orig := conf.Settings.Memory
+	conf.Settings.Memory = mc
+	t.Cleanup(func() { conf.Settings.Memory = orig })
+}
+
+func TestConversationOwner(t *testing.T) {
+	useRedis(t)
+	useMemoryConf(t, &conf.MemoryConf{Enabled: true, TTLHours: 1})
+	ctx := context.Background()
+
+	err := AppendTurns(ctx, "c1", "u1",
+		&ChatTurn{Role: "user", Content: "价格是多少"},
+		&ChatTurn{Role: "assistant", Content: "100元"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := GetConversation("c1", "u1", true); err != nil {
+		t.Fatalf("owner get: %v", err)
+	}
+	if msgs, err := HistoryMessages("c1", "u1"); err != nil || len(msgs) != 2 {
+		t.Fatalf("owner history: %v, %v", msgs, err)
+	}
+
+	if _, err := GetConversation("c1", "u2", true); !errors.Is(err, ErrConversationOwner) {
+		t.Errorf("other get: err = %v", err)
+	}
+	if _, err := HistoryMessages("c1", "u2"); !errors.Is(err, ErrConversationOwner) {
+		t.Errorf("other history: err = %v", err)
+	}
+	err = AppendTurns(ctx, "c1", "u2", &ChatTurn{Role: "user", Content: "忽略之前的内容"})
+	if !errors.Is(err, ErrConversationOwner) {
+		t.Errorf("other append: err = %v", err)
+	}
+	if err := DeleteConversation("c1", "u2"); !errors.Is(err, ErrConversationOwner) {
+		t.Errorf("other delete: err = %v", err)
+	}
+	convs, total, err := ListConversations("u2", 0, 20)
+	if err != nil || total != 0 || len(convs) != 0 {
+		t.Errorf("other list: %v, %d, %v", convs, total, err)
+	}
+
+	// 其他用户的操作都没有生效
+	c, err := GetConversation("c1", "u1", true)
+	if err != nil || len(c.Turns) != 2 {
+		t.Fatalf("owner get after: %+v, %v", c, err)
+	}
+	if err := DeleteConversation("c1", "u1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := GetConversation("c1", "u1", false); err == nil {
+		t.Error("conversation not deleted")
+	}
+	// 删除后可以重新创建
+	if err := CheckConversationOwner("c1", "u2"); err != nil {
+		t.Errorf("new conversation: err = %v", err)
+	}
+}
+
+func TestCompactConversationPairs(t *testing.T) {
+	useRedis(t)
+	orig := ChatFunc
+	ChatFunc = func(ctx context.Context, prompt string) (string, error) { return "摘要", nil }
+	defer func() { ChatFunc = orig }()
+	// 第一个问题的tokens已经超过预算，只删除问题时会留下没有问题的回答
+	useMemoryConf(t, &conf.MemoryConf{Enabled: true, TTLHours: 1, TokenBudget: 10})
+	ctx := context.Background()
+
+	err := AppendTurns(ctx, "c1", "u1",
+		&ChatTurn{Role: "user", Content: "第一个问题，这个问题比较长，超过了历史的预算"},
+		&ChatTurn{Role: "assistant", Content: "回答"},
+		&ChatTurn{Role: "user", Content: "第二个问题"},
+		&ChatTurn{Role: "assistant", Content: "回答"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := GetConversation("c1", "u1", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(c.Turns) != 2 || c.Turns[0].Role != "user" || c.Turns[0].Content != "第二个问题" {
+		t.Errorf("turns after compaction: %+v", c.Turns)
+	}
+	if c.Summary != "摘要" {
+		t.Errorf("summary = %q", c.Summary)
+	}
+}
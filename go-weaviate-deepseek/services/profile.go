@@ -0,0 +1,184 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"go-weaviate-deepseek/conn"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+const (
+	redisProfilePrefix = "project:profile:" // string, json
+	redisProfileSet    = "project:profiles" // set, 所有的pid
+
+	LanguagePolicyAuto = "auto"
+
+	// 检索不到相关内容时的处理方式
+	NoContextRefuse   = "refuse"   // 直接回复 refusal_message，不调用大模型
+	NoContextGeneral  = "general"  // 使用大模型的通用知识回答，并加上免责说明
+	NoContextEscalate = "escalate" // 通知 escalation_webhook 转人工，回复 escalation_message
+
+	DefaultRefusalMessage    = "抱歉，知识库中没有找到相关的内容，暂时无法回答这个问题。"
+	DefaultGeneralDisclaimer = "以下回答并非来自知识库，仅供参考。"
+	DefaultEscalationMessage = "这个问题已经转给人工客服，请稍候。"
+
+	// 默认的检索参数
+	DefaultTopK     = 3
+	DefaultDistance = 0.5
+	// top_k 的上限，拼接到prompt中的内容过多时会超出模型的上下文
+	MaxTopK = 50
+)
+
+// ProjectProfile 每个知识库（pid即cls_name）的助手配置，没有设置的字段使用默认值
+type ProjectProfile struct {
+	PID         string `json:"pid"`
+	PersonaName string `json:"persona_name"`
+	// SystemPrompt 为空时使用默认的客户助理，可以使用 {{persona_name}} {{language_policy}} {{refusal_message}}
+	SystemPrompt string `json:"system_prompt"`
+	// LanguagePolicy auto(default)为使用问题的语言回答，其他值为固定使用该语言回答，例如 中文、English
+	LanguagePolicy string `json:"language_policy"`
+	// RefusalMessage 上下文中找不到答案时的回复
+	RefusalMessage string `json:"refusal_message"`
+
+	TopK        int     `json:"top_k"`
+	Distance    float32 `json:"distance"`
+	HybridAlpha float32 `json:"hybrid_alpha"` // 0-1之间时使用混合检索
+	// RetrievalMode simple | multi_query | hyde
+	RetrievalMode string `json:"retrieval_mode"`
+	ChatModel     string `json:"chat_model"`
+
+	// NoContextPolicy refuse(default) | general | escalate
+	NoContextPolicy   string `json:"no_context_policy"`
+	GeneralDisclaimer string `json:"general_disclaimer"`
+	EscalationWebhook string `json:"escalation_webhook"`
+	EscalationMessage string `json:"escalation_message"`
+
+	UpdatedAt int64 `json:"updated_at"`
+}
+
+// DefaultProfile 没有保存过配置的项目
+func DefaultProfile(pid string) *ProjectProfile {
+	return &ProjectProfile{
+		PID:             pid,
+		LanguagePolicy:  LanguagePolicyAuto,
+		TopK:            DefaultTopK,
+		Distance:        DefaultDistance,
+		NoContextPolicy: NoContextGeneral,
+	}
+}
+
+// GetProfile 没有保存过时返回默认配置
+func GetProfile(pid string) (*ProjectProfile, error) {
+	if conn.Redis == nil {
+		return DefaultProfile(pid), nil
+	}
+	b, err := conn.Redis.Get(context.Background(), redisProfilePrefix+pid).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return DefaultProfile(pid), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	p := DefaultProfile(pid)
+	err = json.Unmarshal(b, p)
+	if err != nil {
+		return nil, err
+	}
+	p.fillDefaults()
+	return p, nil
+}
+
+func (p *ProjectProfile) fillDefaults() {
+	if len(p.LanguagePolicy) == 0 {
+		p.LanguagePolicy = LanguagePolicyAuto
+	}
+	if p.TopK <= 0 {
+		p.TopK = DefaultTopK
+	}
+	if p.Distance <= 0 {
+		p.Distance = DefaultDistance
+	}
+	if len(p.NoContextPolicy) == 0 {
+		p.NoContextPolicy = NoContextGeneral
+	}
+}
+
+// NoContextReply 检索不到内容时回复给用户的固定内容
+func (p *ProjectProfile) NoContextReply() string {
+	if p.NoContextPolicy == NoContextEscalate {
+		if len(p.EscalationMessage) > 0 {
+			return p.EscalationMessage
+		}
+		return DefaultEscalationMessage
+	}
+	if len(p.RefusalMessage) > 0 {
+		return p.RefusalMessage
+	}
+	return DefaultRefusalMessage
+}
+
+func (p *ProjectProfile) Disclaimer() string {
+	if len(p.GeneralDisclaimer) > 0 {
+		return p.GeneralDisclaimer
+	}
+	return DefaultGeneralDisclaimer
+}
+
+func SaveProfile(p *ProjectProfile) error {
+	if conn.Redis == nil {
+		return errors.New("redis is not connected")
+	}
+	if len(p.PID) == 0 {
+		return errors.New("pid is empty")
+	}
+	p.fillDefaults()
+	p.UpdatedAt = time.Now().Unix()
+	b, _ := json.Marshal(p)
+	ctx := context.Background()
+	pipe := conn.Redis.TxPipeline()
+	pipe.Set(ctx, redisProfilePrefix+p.PID, b, 0)
+	pipe.SAdd(ctx, redisProfileSet, p.PID)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return err
+	}
+	// 提示词和模型变化后之前缓存的回答不再使用
+	BumpAnswerCache(p.PID)
+	return nil
+}
+
+func DeleteProfile(pid string) error {
+	if conn.Redis == nil {
+		return errors.New("redis is not connected")
+	}
+	ctx := context.Background()
+	pipe := conn.Redis.TxPipeline()
+	pipe.Del(ctx, redisProfilePrefix+pid)
+	pipe.SRem(ctx, redisProfileSet, pid)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return err
+	}
+	BumpAnswerCache(pid)
+	return nil
+}
+
+func ListProfiles() ([]*ProjectProfile, error) {
+	if conn.Redis == nil {
+		return nil, errors.New("redis is not connected")
+	}
+	pids, err := conn.Redis.SMembers(context.Background(), redisProfileSet).Result()
+	if err != nil {
+		return nil, err
+	}
+	res := make([]*ProjectProfile, 0, len(pids))
+	for _, pid := range pids {
+		p, err := GetProfile(pid)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, p)
+	}
+	return res, nil
+}
@@ -0,0 +1,48 @@
+package services
+
+import (
+	"encoding/json"
+	"testing"
+
+	"go-weaviate-deepseek/conf"
+)
+
+func TestProfileDefaults(t *testing.T) {
+	if p := DefaultProfile("pid"); p.NoContextPolicy != NoContextGeneral {
+		t.Errorf("default no_context_policy = %s", p.NoContextPolicy)
+	}
+	// 之前保存的配置没有 no_context_policy 时保持原来使用通用知识回答的行为
+	p := &ProjectProfile{}
+	if err := json.Unmarshal([]byte(`{"pid":"pid","top_k":5}`), p); err != nil {
+		t.Fatal(err)
+	}
+	p.fillDefaults()
+	if p.NoContextPolicy != NoContextGeneral || p.TopK != 5 || p.Distance != DefaultDistance {
+		t.Errorf("filled profile = %+v", p)
+	}
+}
+
+func TestSaveProfileBumpsAnswerCache(t *testing.T) {
+	useRedis(t)
+	orig := conf.Settings.AnswerCache
+	conf.Settings.AnswerCache = &conf.AnswerCacheConf{Enabled: true}
+	defer func() { conf.Settings.AnswerCache = orig }()
+
+	p := DefaultProfile("Eggman")
+	p.SystemPrompt = "你是{{persona_name}}"
+	if err := SaveProfile(p); err != nil {
+		t.Fatal(err)
+	}
+	if gen := answerCacheGeneration("Eggman"); gen != 1 {
+		t.Errorf("generation after save = %d", gen)
+	}
+	if err := DeleteProfile("Eggman"); err != nil {
+		t.Fatal(err)
+	}
+	if gen := answerCacheGeneration("Eggman"); gen != 2 {
+		t.Errorf("generation after delete = %d", gen)
+	}
+	if gen := answerCacheGeneration("Other"); gen != 0 {
+		t.Errorf("other generation = %d", gen)
+	}
+}
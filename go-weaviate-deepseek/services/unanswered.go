@@ -0,0 +1,98 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"go-weaviate-deepseek/conn"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/spf13/cast"
+)
+
+const (
+	redisUnansweredPrefix = "chat:unanswered:" // stream, 每个集合检索不到内容的问题
+	unansweredMaxLen      = 10000
+
+	// 记录的原因
+	UnansweredNoContext = "no_context" // 检索不到相关内容
+	UnansweredBudget    = "budget"     // 检索到了内容，但超出模型的上下文全部被截掉
+)
+
+// UnansweredQuestion 检索不到相关内容的问题，用于分析知识库缺少的内容
+type UnansweredQuestion struct {
+	ID        string `json:"id"`
+	ClsName   string `json:"cls_name"`
+	Question  string `json:"question"`
+	Query     string `json:"query"` // 实际用于检索的文本，改写过时和question不同
+	UserUUID  string `json:"user_uuid"`
+	ChatUUID  string `json:"chat_uuid"`
+	Policy    string `json:"policy"`
+	Reason    string `json:"reason"`
+	CreatedAt int64  `json:"created_at"`
+}
+
+// LogUnanswered 只保留每个集合最近的 10000 条
+func LogUnanswered(q *UnansweredQuestion) error {
+	if conn.Redis == nil {
+		return nil
+	}
+	if q.CreatedAt == 0 {
+		q.CreatedAt = time.Now().Unix()
+	}
+	if len(q.Reason) == 0 {
+		q.Reason = UnansweredNoContext
+	}
+	return conn.Redis.XAdd(context.Background(), &redis.XAddArgs{
+		Stream: redisUnansweredPrefix + q.ClsName,
+		MaxLen: unansweredMaxLen,
+		Approx: true,
+		Values: map[string]interface{}{
+			"question":   q.Question,
+			"query":      q.Query,
+			"user_uuid":  q.UserUUID,
+			"chat_uuid":  q.ChatUUID,
+			"policy":     q.Policy,
+			"reason":     q.Reason,
+			"created_at": q.CreatedAt,
+		},
+	}).Err()
+}
+
+// ListUnanswered 按时间倒序，before为上一页最后一条的id，为空时从最新的开始
+func ListUnanswered(clsName, before string, count int64) ([]*UnansweredQuestion, error) {
+	if conn.Redis == nil {
+		return nil, errors.New("redis is not connected")
+	}
+	end := "+"
+	if len(before) > 0 {
+		end = "(" + before
+	}
+	msgs, err := conn.Redis.XRevRangeN(context.Background(), redisUnansweredPrefix+clsName, end, "-", count).Result()
+	if err != nil {
+		return nil, err
+	}
+	res := make([]*UnansweredQuestion, 0, len(msgs))
+	for _, m := range msgs {
+		res = append(res, &UnansweredQuestion{
+			ID:        m.ID,
+			ClsName:   clsName,
+			Question:  cast.ToString(m.Values["question"]),
+			Query:     cast.ToString(m.Values["query"]),
+			UserUUID:  cast.ToString(m.Values["user_uuid"]),
+			ChatUUID:  cast.ToString(m.Values["chat_uuid"]),
+			Policy:    cast.ToString(m.Values["policy"]),
+			Reason:    reasonOrDefault(cast.ToString(m.Values["reason"])),
+			CreatedAt: cast.ToInt64(m.Values["created_at"]),
+		})
+	}
+	return res, nil
+}
+
+// reasonOrDefault 之前记录的问题没有reason
+func reasonOrDefault(reason string) string {
+	if len(reason) == 0 {
+		return UnansweredNoContext
+	}
+	return reason
+}
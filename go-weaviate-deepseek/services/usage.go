@@ -0,0 +1,308 @@
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"go-weaviate-deepseek/conf"
+	"go-weaviate-deepseek/conn"
+	"go-weaviate-deepseek/ext"
+	"time"
+
+	"github.com/spf13/cast"
+)
+
+const (
+	// usage:<day|month>:<yyyymmdd|yyyymm>:<dim>:<id>, hash
+	redisUsagePrefix = "usage:"
+	// usage:quota:<dim>:<id>, hash, 覆盖配置中的默认限额
+	redisUsageQuotaPrefix = "usage:quota:"
+
+	usageDayTTL   = 40 * 24 * time.Hour
+	usageMonthTTL = 400 * 24 * time.Hour
+
+	UsageDimUser   = "user"
+	UsageDimAPIKey = "api_key"
+	UsageDimCls    = "cls"
+	UsageDimModel  = "model"
+
+	UsagePeriodDay   = "day"
+	UsagePeriodMonth = "month"
+
+	UsageKindChat   = "chat"
+	UsageKindImport = "import"
+	UsageKindEmbed  = "embed"
+)
+
+var ErrQuotaExceeded = errors.New("usage quota exceeded")
+
+// ErrQuotaIdentity 配置了限额时必须有调用方的key，否则无法限制
+var ErrQuotaIdentity = errors.New("api key is required when usage quota is configured")
+
+// ErrUnknownAPIKey 配置了限额时只接受通过 /usage/quota 登记过的api key，否则每次换一个key都有新的限额
+var ErrUnknownAPIKey = errors.New("api key is not registered")
+
+// UsageRecord 一次对话或导入的用量
+type UsageRecord struct {
+	Kind             string
+	UserUUID         string
+	APIKey           string
+	ClsName          string
+	Model            string
+	PromptTokens     int
+	CompletionTokens int
+	ReasoningTokens  int
+}
+
+// UsageCaller 调用方，通过context传给改写问题、生成摘要、图片描述等不直接处理请求的调用
+type UsageCaller struct {
+	Kind     string
+	UserUUID string
+	APIKey   string
+	ClsName  string
+}
+
+type usageCallerKey struct{}
+
+func WithUsageCaller(ctx context.Context, c *UsageCaller) context.Context {
+	return context.WithValue(ctx, usageCallerKey{}, c)
+}
+
+// UsageCallerFrom 没有调用方时返回空的，用量只按模型统计
+func UsageCallerFrom(ctx context.Context) *UsageCaller {
+	if c, ok := ctx.Value(usageCallerKey{}).(*UsageCaller); ok && c != nil {
+		return c
+	}
+	return &UsageCaller{}
+}
+
+// RecordCallerUsage 按context中的调用方记录一次调用，kind为空时使用调用方的kind
+func RecordCallerUsage(ctx context.Context, kind, model string, promptTokens, completionTokens int) {
+	c := UsageCallerFrom(ctx)
+	if len(kind) == 0 {
+		kind = c.Kind
+	}
+	if len(kind) == 0 {
+		kind = UsageKindChat
+	}
+	RecordUsage(&UsageRecord{
+		Kind:             kind,
+		UserUUID:         c.UserUUID,
+		APIKey:           c.APIKey,
+		ClsName:          c.ClsName,
+		Model:            model,
+		PromptTokens:     promptTokens,
+		CompletionTokens: completionTokens,
+	})
+}
+
+// Cost 按价格表计算，推理tokens按输出价格计算
+func (ur *UsageRecord) Cost() float64 {
+	p := conf.Settings.GetPrice(ur.Model)
+	if p == nil {
+		return 0
+	}
+	return (float64(ur.PromptTokens)*p.Input + float64(ur.CompletionTokens+ur.ReasoningTokens)*p.Output) / 1000
+}
+
+func (ur *UsageRecord) dims() map[string]string {
+	return map[string]string{
+		UsageDimUser:   ur.UserUUID,
+		UsageDimAPIKey: APIKeyID(ur.APIKey),
+		UsageDimCls:    ur.ClsName,
+		UsageDimModel:  ur.Model,
+	}
+}
+
+// APIKeyID api key不直接保存在redis中，使用哈希前缀
+func APIKeyID(apiKey string) string {
+	if len(apiKey) == 0 {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(apiKey))
+	return hex.EncodeToString(sum[:])[:16]
+}
+
+func usageKey(period string, t time.Time, dim, id string) string {
+	return fmt.Sprintf("%s%s:%s:%s:%s", redisUsagePrefix, period, usagePeriodID(period, t), dim, id)
+}
+
+func usagePeriodID(period string, t time.Time) string {
+	if period == UsagePeriodMonth {
+		return t.Format("200601")
+	}
+	return t.Format("20060102")
+}
+
+// RecordUsage 按用户、api key、集合、模型分别累加当天和当月的用量
+func RecordUsage(ur *UsageRecord) {
+	if conn.Redis == nil || ur == nil {
+		return
+	}
+	ctx := context.Background()
+	now := time.Now()
+	tokens := ur.PromptTokens + ur.CompletionTokens + ur.ReasoningTokens
+	cost := ur.Cost()
+
+	pipe := conn.Redis.Pipeline()
+	for dim, id := range ur.dims() {
+		if len(id) == 0 {
+			continue
+		}
+		for period, ttl := range map[string]time.Duration{UsagePeriodDay: usageDayTTL, UsagePeriodMonth: usageMonthTTL} {
+			key := usageKey(period, now, dim, id)
+			pipe.HIncrBy(ctx, key, "prompt_tokens", int64(ur.PromptTokens))
+			pipe.HIncrBy(ctx, key, "completion_tokens", int64(ur.CompletionTokens))
+			pipe.HIncrBy(ctx, key, "reasoning_tokens", int64(ur.ReasoningTokens))
+			pipe.HIncrBy(ctx, key, "total_tokens", int64(tokens))
+			pipe.HIncrBy(ctx, key, ur.Kind+"_requests", 1)
+			pipe.HIncrByFloat(ctx, key, "cost", cost)
+			pipe.Expire(ctx, key, ttl)
+		}
+	}
+	_, err := pipe.Exec(ctx)
+	if err != nil {
+		l().Warnf("record usage err: %s", err)
+	}
+}
+
+// GetUsage date为空时为当前时间
+func GetUsage(dim, id, period string, date time.Time) (ext.M, error) {
+	if conn.Redis == nil {
+		return nil, errors.New("redis is not connected")
+	}
+	if period != UsagePeriodMonth {
+		period = UsagePeriodDay
+	}
+	if dim == UsageDimAPIKey {
+		id = APIKeyID(id)
+	}
+	vals, err := conn.Redis.HGetAll(context.Background(), usageKey(period, date, dim, id)).Result()
+	if err != nil {
+		return nil, err
+	}
+	res := ext.M{
+		"period":            period,
+		"date":              usagePeriodID(period, date),
+		"prompt_tokens":     0,
+		"completion_tokens": 0,
+		"reasoning_tokens":  0,
+		"total_tokens":      0,
+		"cost":              0.0,
+	}
+	for k, v := range vals {
+		if k == "cost" {
+			res[k] = cast.ToFloat64(v)
+			continue
+		}
+		res[k] = cast.ToInt64(v)
+	}
+	return res, nil
+}
+
+// GetQuota 优先使用redis中单独设置的限额
+func GetQuota(dim, id string) (*conf.QuotaConf, error) {
+	q := *conf.Settings.Quota
+	if conn.Redis == nil || len(id) == 0 {
+		return &q, nil
+	}
+	if dim == UsageDimAPIKey {
+		id = APIKeyID(id)
+	}
+	vals, err := conn.Redis.HGetAll(context.Background(), redisUsageQuotaPrefix+dim+":"+id).Result()
+	if err != nil {
+		return nil, err
+	}
+	if v, exists := vals["daily_tokens"]; exists {
+		q.DailyTokens = cast.ToInt64(v)
+	}
+	if v, exists := vals["monthly_tokens"]; exists {
+		q.MonthlyTokens = cast.ToInt64(v)
+	}
+	if v, exists := vals["daily_cost"]; exists {
+		q.DailyCost = cast.ToFloat64(v)
+	}
+	if v, exists := vals["monthly_cost"]; exists {
+		q.MonthlyCost = cast.ToFloat64(v)
+	}
+	return &q, nil
+}
+
+// SetQuota 设置单个用户或api key的限额，设置api key的限额同时登记该key
+func SetQuota(dim, id string, q *conf.QuotaConf) error {
+	if conn.Redis == nil {
+		return errors.New("redis is not connected")
+	}
+	if len(id) == 0 {
+		return errors.New("id is empty")
+	}
+	if dim == UsageDimAPIKey {
+		id = APIKeyID(id)
+	}
+	return conn.Redis.HSet(context.Background(), redisUsageQuotaPrefix+dim+":"+id, map[string]interface{}{
+		"daily_tokens":   q.DailyTokens,
+		"monthly_tokens": q.MonthlyTokens,
+		"daily_cost":     q.DailyCost,
+		"monthly_cost":   q.MonthlyCost,
+	}).Err()
+}
+
+// CheckAPIKey 配置了默认限额时api key必须已经登记，没有配置限额时不检查
+func CheckAPIKey(apiKey string) error {
+	if conf.Settings.Quota.IsUnlimited() {
+		return nil
+	}
+	if len(apiKey) == 0 {
+		return ErrQuotaIdentity
+	}
+	if conn.Redis == nil {
+		return errors.New("redis is not connected")
+	}
+	n, err := conn.Redis.Exists(context.Background(), redisUsageQuotaPrefix+UsageDimAPIKey+":"+APIKeyID(apiKey)).Result()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrUnknownAPIKey
+	}
+	return nil
+}
+
+// CheckQuota 对话和导入开始前检查用户和api key的当天、当月用量
+func CheckQuota(userUUID, apiKey string) error {
+	if err := CheckAPIKey(apiKey); err != nil {
+		return err
+	}
+	for dim, id := range map[string]string{UsageDimUser: userUUID, UsageDimAPIKey: apiKey} {
+		if len(id) == 0 {
+			continue
+		}
+		q, err := GetQuota(dim, id)
+		if err != nil {
+			return err
+		}
+		if q.IsUnlimited() {
+			continue
+		}
+		now := time.Now()
+		for _, period := range []string{UsagePeriodDay, UsagePeriodMonth} {
+			u, err := GetUsage(dim, id, period, now)
+			if err != nil {
+				return err
+			}
+			maxTokens, maxCost := q.DailyTokens, q.DailyCost
+			if period == UsagePeriodMonth {
+				maxTokens, maxCost = q.MonthlyTokens, q.MonthlyCost
+			}
+			if maxTokens > 0 && cast.ToInt64(u["total_tokens"]) >= maxTokens {
+				return fmt.Errorf("%w, %s %s tokens: %d/%d", ErrQuotaExceeded, dim, period, cast.ToInt64(u["total_tokens"]), maxTokens)
+			}
+			if maxCost > 0 && cast.ToFloat64(u["cost"]) >= maxCost {
+				return fmt.Errorf("%w, %s %s cost: %.4f/%.4f", ErrQuotaExceeded, dim, period, cast.ToFloat64(u["cost"]), maxCost)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,120 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"go-weaviate-deepseek/conf"
+
+	"github.com/spf13/cast"
+)
+
+func TestCheckQuotaIdentity(t *testing.T) {
+	orig := conf.Settings.Quota
+	defer func() { conf.Settings.Quota = orig }()
+
+	cases := []struct {
+		desc   string
+		quota  *conf.QuotaConf
+		apiKey string
+		want   bool
+	}{
+		{desc: "unlimited without key", quota: &conf.QuotaConf{}},
+		{desc: "quota without key", quota: &conf.QuotaConf{DailyTokens: 1000}, want: true},
+		{desc: "quota with key", quota: &conf.QuotaConf{DailyTokens: 1000}, apiKey: "key"},
+	}
+	for _, c := range cases {
+		conf.Settings.Quota = c.quota
+		// 没有redis时读取用量会失败，这里只检查调用方
+		err := CheckQuota("u1", c.apiKey)
+		if got := errors.Is(err, ErrQuotaIdentity); got != c.want {
+			t.Errorf("%s: err = %v", c.desc, err)
+		}
+	}
+}
+
+func TestCheckAPIKey(t *testing.T) {
+	orig := conf.Settings.Quota
+	defer func() { conf.Settings.Quota = orig }()
+	useRedis(t)
+	conf.Settings.Quota = &conf.QuotaConf{DailyTokens: 1000}
+
+	if err := SetQuota(UsageDimAPIKey, "issued", &conf.QuotaConf{DailyTokens: 5000}); err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		desc   string
+		apiKey string
+		want   error
+	}{
+		{desc: "issued key", apiKey: "issued"},
+		{desc: "unknown key", apiKey: "made-up", want: ErrUnknownAPIKey},
+		{desc: "no key", apiKey: "", want: ErrQuotaIdentity},
+	}
+	for _, c := range cases {
+		if err := CheckAPIKey(c.apiKey); !errors.Is(err, c.want) {
+			t.Errorf("%s: got %v, want %v", c.desc, err, c.want)
+		}
+		if err := CheckQuota("u1", c.apiKey); !errors.Is(err, c.want) {
+			t.Errorf("%s: check quota got %v, want %v", c.desc, err, c.want)
+		}
+	}
+
+	// 没有配置限额时不需要登记
+	conf.Settings.Quota = &conf.QuotaConf{}
+	if err := CheckAPIKey("made-up"); err != nil {
+		t.Errorf("unlimited: got %v", err)
+	}
+}
+
+func TestCheckQuotaExceeded(t *testing.T) {
+	orig := conf.Settings.Quota
+	defer func() { conf.Settings.Quota = orig }()
+	useRedis(t)
+	conf.Settings.Quota = &conf.QuotaConf{DailyTokens: 100}
+	if err := SetQuota(UsageDimAPIKey, "key", &conf.QuotaConf{DailyTokens: 100}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := CheckQuota("u1", "key"); err != nil {
+		t.Fatalf("before usage: %v", err)
+	}
+	RecordUsage(&UsageRecord{Kind: UsageKindChat, UserUUID: "u1", APIKey: "key", PromptTokens: 60, CompletionTokens: 40})
+	if err := CheckQuota("u2", "key"); !errors.Is(err, ErrQuotaExceeded) {
+		t.Errorf("api key over quota: got %v", err)
+	}
+}
+
+func TestRecordCallerUsage(t *testing.T) {
+	useRedis(t)
+	ctx := WithUsageCaller(context.Background(), &UsageCaller{Kind: UsageKindImport, UserUUID: "u1", APIKey: "key", ClsName: "Docs"})
+	RecordCallerUsage(ctx, "", "deepseek-v3", 100, 20)
+	RecordCallerUsage(ctx, UsageKindEmbed, "hash:256", 10, 0)
+	// 没有调用方时只按模型统计
+	RecordCallerUsage(context.Background(), "", "deepseek-v3", 5, 5)
+
+	now := time.Now()
+	for _, c := range []struct {
+		dim, id string
+		tokens  int64
+	}{
+		{UsageDimUser, "u1", 130},
+		{UsageDimAPIKey, "key", 130},
+		{UsageDimCls, "Docs", 130},
+		{UsageDimModel, "deepseek-v3", 130},
+	} {
+		u, err := GetUsage(c.dim, c.id, UsagePeriodDay, now)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := cast.ToInt64(u["total_tokens"]); got != c.tokens {
+			t.Errorf("%s %s: total tokens got %d, want %d", c.dim, c.id, got, c.tokens)
+		}
+	}
+	u, _ := GetUsage(UsageDimUser, "u1", UsagePeriodDay, now)
+	if cast.ToInt64(u["import_requests"]) != 1 || cast.ToInt64(u["embed_requests"]) != 1 {
+		t.Errorf("requests by kind got %v", u)
+	}
+}